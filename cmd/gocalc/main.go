@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/f1rsov08/go_calc_2/internal/agent"
+	"github.com/f1rsov08/go_calc_2/internal/orchestrator"
+	"github.com/f1rsov08/go_calc_2/internal/web"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Использование: gocalc <команда> [флаги]
+
+Команды:
+  orchestrator  запустить оркестратор
+  agent         запустить агента
+  web           запустить веб-интерфейс
+  all           запустить все компоненты в одном процессе (для локальной разработки)
+
+Флаги команды: gocalc <команда> -h
+`
+
+// Компонент, запускаемый командой
+type component struct {
+	name     string
+	run      func() error // Запускает компонент, не блокируя вызывающего
+	shutdown func(ctx context.Context) error
+	// Дополнительное время на остановку сверх общего таймаута
+	extra time.Duration
+}
+
+func main() {
+	godotenv.Load(".env")
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command := os.Args[1]
+
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	// Время, которое дается агентам на завершение уже выданных задач
+	shutdownTimeout, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT_MS"))
+	if err != nil {
+		shutdownTimeout = 30000
+	}
+	timeout := fs.Duration("shutdown-timeout", time.Duration(shutdownTimeout)*time.Millisecond, "время на завершение выданных задач при остановке (SHUTDOWN_TIMEOUT_MS)")
+
+	switch command {
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	}
+	components, err := commandComponents(command, fs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+		os.Exit(2)
+	}
+	fs.Parse(os.Args[2:])
+
+	for _, c := range components {
+		if err := c.run(); err != nil {
+			log.Fatalf("%s: %v", c.name, err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	// Компоненты останавливаются одновременно: оркестратор ждет результаты, которые отправляет агент
+	var wg sync.WaitGroup
+	for _, c := range components {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), *timeout+c.extra)
+			defer cancel()
+			if err := c.shutdown(shutdownCtx); err != nil {
+				log.Printf("%s shutdown: %v", c.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// commandComponents возвращает компоненты, которые запускает команда, и регистрирует их флаги в fs
+func commandComponents(command string, fs *flag.FlagSet) ([]component, error) {
+	switch command {
+	case "orchestrator":
+		return []component{orchestratorComponent(fs)}, nil
+	case "agent":
+		return []component{agentComponent(fs, false)}, nil
+	case "web":
+		return []component{webComponent(fs)}, nil
+	case "all":
+		return []component{orchestratorComponent(fs), agentComponent(fs, true), webComponent(fs)}, nil
+	default:
+		return nil, fmt.Errorf("неизвестная команда %q", command)
+	}
+}
+
+func orchestratorComponent(fs *flag.FlagSet) component {
+	config := orchestrator.ConfigFromEnv()
+	config.RegisterFlags(fs)
+	var app *orchestrator.Application
+	return component{
+		name: "orchestrator",
+		run: func() error {
+			app = orchestrator.NewWithConfig(config)
+			return app.RunServer()
+		},
+		shutdown: func(ctx context.Context) error { return app.Shutdown(ctx) },
+		// Оркестратору дается запас, чтобы агент успел вернуть прерванные задачи до закрытия соединений
+		extra: time.Second,
+	}
+}
+
+func agentComponent(fs *flag.FlagSet, shared bool) component {
+	config := agent.ConfigFromEnv()
+	config.Shared = shared
+	config.RegisterFlags(fs)
+	var app *agent.Application
+	return component{
+		name: "agent",
+		run: func() error {
+			app = agent.NewWithConfig(config)
+			return app.Run()
+		},
+		shutdown: func(ctx context.Context) error { return app.Shutdown(ctx) },
+	}
+}
+
+func webComponent(fs *flag.FlagSet) component {
+	config := web.ConfigFromEnv()
+	config.RegisterFlags(fs)
+	var app *web.Application
+	return component{
+		name: "web",
+		run: func() error {
+			app = web.NewWithConfig(config)
+			go func() {
+				if err := app.Run(); err != nil {
+					log.Fatal("web: ", err)
+				}
+			}()
+			return nil
+		},
+		shutdown: func(ctx context.Context) error { return app.Shutdown(ctx) },
+	}
+}
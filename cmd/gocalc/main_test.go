@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCommandComponents(t *testing.T) {
+	tests := []struct {
+		command string
+		names   []string
+		flags   []string // Флаги, которые принимает команда
+		foreign []string // Флаги других команд
+	}{
+		{"orchestrator", []string{"orchestrator"}, []string{"-grpc-addr", ":7000"}, []string{"-computing-power", "2"}},
+		{"agent", []string{"agent"}, []string{"-computing-power", "2"}, []string{"-grpc-addr", ":7000"}},
+		{"web", []string{"web"}, nil, []string{"-computing-power", "2"}},
+		{"all", []string{"orchestrator", "agent", "web"}, []string{"-grpc-addr", ":7000", "-computing-power", "2"}, nil},
+	}
+
+	for _, test := range tests {
+		fs := flag.NewFlagSet(test.command, flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		components, err := commandComponents(test.command, fs)
+		if err != nil {
+			t.Fatalf("Command %q: %v", test.command, err)
+		}
+		var names []string
+		for _, c := range components {
+			names = append(names, c.name)
+		}
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("Command %q: expected components %v, but got %v", test.command, test.names, names)
+		}
+		if err := fs.Parse(test.flags); err != nil {
+			t.Errorf("Command %q: expected flags %v to be accepted, but got %v", test.command, test.flags, err)
+		}
+		if test.foreign != nil {
+			if err := fs.Parse(test.foreign); err == nil {
+				t.Errorf("Command %q: expected flags %v to be rejected", test.command, test.foreign)
+			}
+		}
+	}
+
+	if _, err := commandComponents("unknown", flag.NewFlagSet("unknown", flag.ContinueOnError)); err == nil {
+		t.Error("Expected unknown command to be rejected")
+	}
+}
@@ -2,18 +2,46 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
 	pb "github.com/f1rsov08/go_calc_2/proto"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type Config struct {
-	ComputingPower int
-	WaitTime       int
+	ID                  string // Идентификатор агента
+	OrchestratorAddr    string // Адреса gRPC серверов оркестраторов через запятую в порядке приоритета
+	PullAll             bool   // Запрашивать задачи у всех доступных оркестраторов, а не только у первого из них
+	HealthCheckInterval int    // Пауза между проверками здоровья оркестраторов в миллисекундах
+	ComputingPower      int
+	MinComputingPower   int     // Минимальное количество горутин, 0 - равно ComputingPower
+	MaxComputingPower   int     // Максимальное количество горутин, 0 - равно ComputingPower
+	MaxCPU              float64 // Загрузка процессора в процентах, выше которой горутины не добавляются
+	WaitTime            int
+	MaxWaitTime         int      // Предельная пауза между запросами при ошибках и пустой очереди
+	Operations          []string // Операции, о поддержке которых агент сообщает оркестратору
+	MaxOperand          float64  // Максимальный модуль аргумента, 0 - без ограничений
+	Labels              Labels   // Метки, по которым оркестратор выбирает задачи для агента
+	SendAttempts        int      // Количество попыток отправки результатов оркестратору
+	Plugins             []string // Исполняемые файлы плагинов с дополнительными операциями
+	PluginTimeout       int      // Время на ответ плагина в миллисекундах
+	StatusAddr          string   // Адрес HTTP сервера с состоянием и метриками агента, пустой - не запускать
+	Faults              Faults   // Вероятности сбоев для проверки устойчивости оркестратора
+	FaultDelay          int      // Задержка результата при сбое delay в миллисекундах
+	Shared              bool     // Агент работает в одном процессе с другими компонентами, например в gocalc all
 }
 
 // Функция для создания конфигурации из переменных окружения
@@ -27,90 +55,603 @@ func ConfigFromEnv() *Config {
 	}
 	config.ComputingPower = computingPower
 
+	minComputingPower, err := strconv.Atoi(os.Getenv("MIN_COMPUTING_POWER"))
+	if err != nil {
+		minComputingPower = 0
+	}
+	config.MinComputingPower = minComputingPower
+
+	maxComputingPower, err := strconv.Atoi(os.Getenv("MAX_COMPUTING_POWER"))
+	if err != nil {
+		maxComputingPower = 0
+	}
+	config.MaxComputingPower = maxComputingPower
+
+	maxCPU, err := strconv.ParseFloat(os.Getenv("MAX_CPU_PERCENT"), 64)
+	if err != nil {
+		maxCPU = 80
+	}
+	config.MaxCPU = maxCPU
+
 	strWaitTime := os.Getenv("WAIT_TIME")
 	waitTime, err := strconv.Atoi(strWaitTime)
 	if err != nil {
 		waitTime = 100
 	}
 	config.WaitTime = waitTime
+
+	maxWaitTime, err := strconv.Atoi(os.Getenv("MAX_WAIT_TIME"))
+	if err != nil || maxWaitTime < waitTime {
+		maxWaitTime = max(1000, waitTime)
+	}
+	config.MaxWaitTime = maxWaitTime
+
+	config.OrchestratorAddr = os.Getenv("ORCHESTRATOR_ADDR")
+	if config.OrchestratorAddr == "" {
+		config.OrchestratorAddr = "localhost:50042"
+	}
+	config.PullAll, _ = strconv.ParseBool(os.Getenv("PULL_ALL_ORCHESTRATORS"))
+
+	healthCheckInterval, err := strconv.Atoi(os.Getenv("HEALTH_CHECK_MS"))
+	if err != nil || healthCheckInterval <= 0 {
+		healthCheckInterval = 1000
+	}
+	config.HealthCheckInterval = healthCheckInterval
+
+	config.ID = os.Getenv("AGENT_ID")
+	if config.ID == "" {
+		hostname, _ := os.Hostname()
+		config.ID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	// По умолчанию агент сообщает обо всех операциях из реестра
+	config.Operations = operation.Names()
+	if operations := os.Getenv("OPERATIONS"); operations != "" {
+		config.Operations = strings.Split(operations, ",")
+	}
+
+	maxOperand, err := strconv.ParseFloat(os.Getenv("MAX_OPERAND"), 64)
+	if err != nil {
+		maxOperand = 0
+	}
+	config.MaxOperand = maxOperand
+
+	labels, err := ParseLabels(os.Getenv("LABELS"))
+	if err != nil {
+		log.Println("labels ignored:", err)
+	}
+	config.Labels = labels
+
+	sendAttempts, err := strconv.Atoi(os.Getenv("SEND_ATTEMPTS"))
+	if err != nil || sendAttempts < 1 {
+		sendAttempts = 5
+	}
+	config.SendAttempts = sendAttempts
+
+	if plugins := os.Getenv("PLUGINS"); plugins != "" {
+		config.Plugins = strings.Split(plugins, ",")
+	}
+
+	pluginTimeout, err := strconv.Atoi(os.Getenv("PLUGIN_TIMEOUT_MS"))
+	if err != nil || pluginTimeout <= 0 {
+		pluginTimeout = 5000
+	}
+	config.PluginTimeout = pluginTimeout
+
+	config.StatusAddr = os.Getenv("STATUS_ADDR")
+
+	faults, err := ParseFaults(os.Getenv("FAULTS"))
+	if err != nil {
+		log.Println("fault injection disabled:", err)
+	}
+	config.Faults = faults
+
+	faultDelay, err := strconv.Atoi(os.Getenv("FAULT_DELAY_MS"))
+	if err != nil || faultDelay < 0 {
+		faultDelay = 10000
+	}
+	config.FaultDelay = faultDelay
 	return config
 }
 
+// workerLimits приводит границы количества горутин к допустимым значениям:
+// 1 <= MinComputingPower <= ComputingPower <= MaxComputingPower
+func (c *Config) workerLimits() {
+	if c.MinComputingPower <= 0 {
+		c.MinComputingPower = max(c.ComputingPower, 1)
+	}
+	if c.MaxComputingPower <= 0 {
+		c.MaxComputingPower = max(c.ComputingPower, c.MinComputingPower)
+	}
+	c.MaxComputingPower = max(c.MaxComputingPower, c.MinComputingPower)
+	c.ComputingPower = min(max(c.ComputingPower, c.MinComputingPower), c.MaxComputingPower)
+}
+
+// agentInfo возвращает сведения об агенте для оркестратора
+func (c *Config) agentInfo() *pb.AgentInfo {
+	return &pb.AgentInfo{
+		Id:         c.ID,
+		Operations: c.Operations,
+		MaxOperand: c.MaxOperand,
+		Labels:     c.Labels,
+	}
+}
+
 // Структура приложения, содержащая конфигурацию
 type Application struct {
-	config *Config
+	config        *Config
+	orchestrators []*orchestrator              // Оркестраторы в порядке приоритета
+	current       atomic.Pointer[orchestrator] // Первый доступный оркестратор
+	selecting     sync.Mutex                   // Защищает выбор текущего оркестратора
+	agent         *pb.AgentInfo
+
+	mu      sync.Mutex
+	running map[taskKey]*runningTask // Вычисляемые задачи
+	workers atomic.Int32             // Текущее количество горутин
+	cpu     cpuSampler
+	metrics *metrics
+	status  *http.Server // Сервер с состоянием агента, nil - если не запущен
+
+	plugins          []*plugin
+	pluginOperations []*pb.OperationSpec // Операции плагинов, о которых агент сообщает оркестратору
+
+	ready chan struct{} // Сигнал циклу запросов, что оркестратор снова доступен
+	stop  chan struct{} // Закрывается, когда агент должен перестать запрашивать задачи
+	done  chan struct{} // Закрывается, когда все задачи вычислены и результаты отправлены
 }
 
 // Функция для создания нового экземпляра приложения
 func New() *Application {
+	return NewWithConfig(ConfigFromEnv())
+}
+
+// Функция для создания нового экземпляра приложения с заданной конфигурацией
+func NewWithConfig(config *Config) *Application {
 	return &Application{
-		config: ConfigFromEnv(),
+		config:  config,
+		agent:   config.agentInfo(),
+		running: make(map[taskKey]*runningTask),
+		metrics: newMetrics(),
+		ready:   make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
 	}
 }
 
-// Метод для запуска HTTP-сервера
-func (a *Application) Run() {
-	conn, err := grpc.NewClient("localhost:50042", grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		panic(err)
+// Метод для запуска агента. Соединения с оркестраторами устанавливаются в фоне,
+// поэтому агент можно запустить раньше оркестраторов
+func (a *Application) Run() error {
+	// Сбой crash завершает процесс, а вместе с ним и остальные компоненты
+	if a.config.Shared && a.config.Faults.Crash > 0 {
+		return errors.New("crash fault is not supported when the agent shares a process with other components")
+	}
+	waitTime := time.Duration(a.config.WaitTime) * time.Millisecond
+	maxWaitTime := time.Duration(a.config.MaxWaitTime) * time.Millisecond
+	if err := a.connect(); err != nil {
+		return err
+	}
+
+	if err := a.startStatus(); err != nil {
+		a.disconnect()
+		return err
+	}
+	if faults := a.config.Faults.String(); faults != "" {
+		log.Println("fault injection enabled:", faults)
+	}
+	a.loadPlugins()
+	a.agent = a.config.agentInfo()
+	a.agent.CustomOperations = a.pluginOperations
+	a.config.workerLimits()
+
+	tasks := make(chan job, a.config.MaxComputingPower)
+	results := make(chan taskResult, a.config.MaxComputingPower)
+	// Горутина, получившая значение из retire, завершается
+	retire := make(chan struct{}, a.config.MaxComputingPower)
+	var idle atomic.Int32
+	// Сигнал циклу запросов, что освободилась горутина
+	freed := make(chan struct{}, 1)
+	release := func() {
+		idle.Add(1)
+		select {
+		case freed <- struct{}{}:
+		default:
+		}
+	}
+
+	var workers sync.WaitGroup
+	worker := func() {
+		defer workers.Done()
+		for {
+			var j job
+			select {
+			case next, ok := <-tasks:
+				if !ok {
+					return
+				}
+				j = next
+			case <-retire:
+				return
+			}
+			task := j.task
+			// После начала остановки полученные задачи не начинаются, а возвращаются оркестратору
+			if a.stopping() {
+				a.release(context.Background(), j.source, []int64{task.Id})
+				release()
+				continue
+			}
+			ctx := a.start(j.source, task)
+			a.injectCrash(task)
+			result := compute(ctx, task)
+			a.finish(j.source, task.Id)
+			a.metrics.record(task.Operation, result)
+			// Результат отмененной задачи оркестратору не нужен
+			if result != nil {
+				for _, result := range a.injectFaults(task, result) {
+					results <- taskResult{source: j.source, result: result}
+				}
+			}
+			release()
+		}
+	}
+	// Горутины добавляет и останавливает только цикл запросов задач, поэтому idle
+	// в остальных горутинах может только увеличиваться
+	scale := func(n int) {
+		if n > 0 {
+			workers.Add(n)
+			idle.Add(int32(n))
+			for i := 0; i < n; i++ {
+				go worker()
+			}
+		} else {
+			// Останавливаются только свободные горутины
+			n = -min(-n, int(idle.Load()))
+			idle.Add(int32(n))
+			for i := 0; i < -n; i++ {
+				retire <- struct{}{}
+			}
+		}
+		a.workers.Add(int32(n))
+	}
+	scale(a.config.ComputingPower)
+	s := &scaler{min: a.config.MinComputingPower, max: a.config.MaxComputingPower, maxCPU: a.config.MaxCPU}
+	autoscale := func(queued int) {
+		if s.min == s.max {
+			return
+		}
+		n := int(a.workers.Load())
+		cpu := a.cpu.Usage()
+		target := s.target(n, n-int(idle.Load()), queued, cpu, time.Now())
+		if target == n {
+			return
+		}
+		scale(target - n)
+		if current := int(a.workers.Load()); current != n {
+			log.Printf("workers: %d -> %d (queued tasks: %d, cpu: %.0f%%)", n, current, queued, cpu)
+		}
 	}
 
-	client := pb.NewTaskServiceClient(conn)
+	sent := make(chan struct{})
+	go func() {
+		a.sendResults(results)
+		close(sent)
+	}()
+	go func() {
+		workers.Wait()
+		close(results)
+		<-sent
+		close(a.done)
+	}()
 
-	for i := 0; i < a.config.ComputingPower; i++ {
-		go func() {
-			for {
-				taskResponse, err := client.GetTask(context.Background(), &pb.GetTaskRequest{})
-				if err == nil {
-					compute(client, taskResponse.Task)
+	for _, o := range a.orchestrators {
+		go a.watchConnection(o)
+		go a.checkHealth(o)
+	}
+	go a.heartbeat()
+	go func() {
+		b := newBackoff(waitTime, maxWaitTime)
+		queued := 0 // Готовые задачи, оставшиеся в очередях после последнего запроса
+		for round := 0; ; round++ {
+			autoscale(queued)
+			// Запрашиваем столько задач, сколько сейчас свободных горутин.
+			// Если свободных нет, ждем, пока какая-нибудь освободится
+			var wait <-chan time.Time
+			var workerFreed <-chan struct{}
+			if idle.Load() > 0 {
+				received := false
+				queued = 0
+				for _, o := range a.pullTargets(round) {
+					n := idle.Load()
+					if n == 0 {
+						break
+					}
+					started := time.Now()
+					tasksResponse, err := o.client.GetTasks(context.Background(), &pb.GetTasksRequest{MaxN: n, Agent: a.agent})
+					a.metrics.poll(time.Since(started))
+					switch {
+					case err == nil:
+						a.recovered(o)
+						received = true
+						queued += int(tasksResponse.QueueDepth)
+						idle.Add(-int32(len(tasksResponse.Tasks)))
+						for _, task := range tasksResponse.Tasks {
+							tasks <- job{source: o, task: task}
+						}
+					case status.Code(err) == codes.NotFound:
+						// Очередь пуста: опрашиваем ее все реже, пока не появятся задачи
+						a.recovered(o)
+					case status.Code(err) == codes.FailedPrecondition:
+						// Оркестратор поместил агента в карантин: задачи снова начнут выдаваться после его окончания
+						if !o.failing {
+							log.Println(status.Convert(err).Message())
+							o.failing = true
+						}
+					default:
+						if !o.failing {
+							log.Printf("failed to get tasks from orchestrator %s: %v", o.addr, err)
+							o.failing = true
+						}
+						// Недоступный оркестратор заменяется следующим, не дожидаясь проверки здоровья
+						if status.Code(err) == codes.Unavailable {
+							a.setHealthy(o, false)
+						}
+					}
 				}
-				time.Sleep(time.Duration(a.config.WaitTime) * time.Millisecond)
+				if received {
+					b.reset()
+					// После успешного запроса сразу запрашиваем задачи снова
+					if !a.stopping() {
+						continue
+					}
+				} else {
+					wait = time.After(b.next())
+				}
+			} else {
+				workerFreed = freed
+			}
+			select {
+			case <-a.stop:
+				close(tasks)
+				return
+			case <-wait:
+			case <-workerFreed:
+			case <-a.ready:
+				// Соединение восстановлено, ждать окончания задержки незачем
+				b.reset()
 			}
-		}()
+		}
+	}()
+	return nil
+}
+
+// Workers возвращает текущее количество горутин, вычисляющих задачи
+func (a *Application) Workers() int {
+	return int(a.workers.Load())
+}
+
+// Shutdown останавливает агента: он перестает запрашивать задачи, довычисляет уже начатые
+// и отправляет их результаты. Если ctx завершится раньше, незавершенные задачи прерываются
+// и возвращаются оркестратору, чтобы их не пришлось ждать до истечения аренды
+func (a *Application) Shutdown(ctx context.Context) error {
+	close(a.stop)
+	if len(a.orchestrators) == 0 {
+		return nil
 	}
+	defer a.disconnect()
+	defer a.closePlugins()
+	if a.status != nil {
+		defer a.status.Close()
+	}
+
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	a.mu.Lock()
+	for _, running := range a.running {
+		running.cancel()
+	}
+	a.mu.Unlock()
+
+	releaseCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for source, ids := range a.runningBySource() {
+		a.release(releaseCtx, source, ids)
+	}
+	return ctx.Err()
 }
 
-func sendResult(client pb.TaskServiceClient, taskID int64, result float32) error {
-	data := &pb.PostResultRequest{
-		Id:     int64(taskID),
-		Result: float32(result),
+// stopping проверяет, началась ли остановка агента
+func (a *Application) stopping() bool {
+	select {
+	case <-a.stop:
+		return true
+	default:
+		return false
 	}
-	_, err := client.PostResult(context.Background(), data)
+}
+
+// release возвращает задачи выдавшему их оркестратору невычисленными
+func (a *Application) release(ctx context.Context, source *orchestrator, ids []int64) {
+	if len(ids) == 0 {
+		return
+	}
+	_, err := source.client.ReleaseTasks(ctx, &pb.ReleaseTasksRequest{Agent: a.agent, TaskIds: ids})
 	if err != nil {
-		return err
+		log.Printf("failed to release %d tasks to orchestrator %s: %v", len(ids), source.addr, err)
 	}
-	return nil
 }
 
-func sendError(client pb.TaskServiceClient, taskID int64) error {
-	data := &pb.PostResultRequest{
-		Id:    int64(taskID),
-		Error: "division by zero",
+// runningTask - задача, которую агент сейчас вычисляет
+type runningTask struct {
+	source  *orchestrator // Оркестратор, выдавший задачу
+	task    *pb.Task
+	started time.Time
+	cancel  context.CancelFunc
+}
+
+// start регистрирует задачу как вычисляемую и возвращает контекст, отменяемый по сигналу оркестратора
+func (a *Application) start(source *orchestrator, task *pb.Task) context.Context {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	a.running[taskKey{source, task.Id}] = &runningTask{source: source, task: task, started: time.Now(), cancel: cancel}
+	return ctx
+}
+
+// finish снимает задачу с учета
+func (a *Application) finish(source *orchestrator, id int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := taskKey{source, id}
+	if running, ok := a.running[key]; ok {
+		running.cancel()
+		delete(a.running, key)
 	}
-	_, err := client.PostResult(context.Background(), data)
-	if err != nil {
-		return err
+}
+
+// runningBySource возвращает номера вычисляемых задач по выдавшим их оркестраторам
+func (a *Application) runningBySource() map[*orchestrator][]int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ids := make(map[*orchestrator][]int64)
+	for key := range a.running {
+		ids[key.source] = append(ids[key.source], key.id)
 	}
-	return nil
+	return ids
 }
 
-func compute(client pb.TaskServiceClient, task *pb.Task) {
-	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
-	var result float32
-	switch task.Operation {
-	case "+":
-		result = task.Arg1 + task.Arg2
-	case "-":
-		result = task.Arg1 - task.Arg2
-	case "*":
-		result = task.Arg1 * task.Arg2
-	case "/":
-		if task.Arg2 != 0 {
-			result = task.Arg1 / task.Arg2
-		} else {
-			sendError(client, task.Id)
+// heartbeat периодически сообщает оркестратору о вычисляемых задачах и прерывает отмененные
+func (a *Application) heartbeat() {
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-time.After(time.Duration(a.config.WaitTime) * time.Millisecond):
+		}
+
+		for source, ids := range a.runningBySource() {
+			response, err := source.client.Heartbeat(context.Background(), &pb.HeartbeatRequest{Agent: a.agent, TaskIds: ids})
+			if err != nil {
+				continue
+			}
+			a.mu.Lock()
+			for _, id := range response.CancelledIds {
+				if running, ok := a.running[taskKey{source, id}]; ok {
+					running.cancel()
+				}
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// sendResults отправляет накопившиеся результаты выдавшим задачи оркестраторам пакетами до MaxComputingPower
+// штук. Результат отправляется только выдавшему задачу оркестратору, даже если агент уже переключился
+// на другой. При ошибке отправка повторяется с растущей задержкой; если результаты так и не доставлены,
+// оркестратор вернет задачи в очередь по истечении аренды
+func (a *Application) sendResults(results <-chan taskResult) {
+	for result := range results {
+		batches := map[*orchestrator][]*pb.PostResultRequest{result.source: {result.result}}
+	collect:
+		for n := 1; n < a.config.MaxComputingPower; n++ {
+			select {
+			case result, ok := <-results:
+				// Канал закрывается при остановке агента
+				if !ok {
+					break collect
+				}
+				batches[result.source] = append(batches[result.source], result.result)
+			default:
+				break collect
+			}
+		}
+		for source, batch := range batches {
+			a.send(source, batch)
+		}
+	}
+}
+
+// send отправляет пакет результатов оркестратору, повторяя отправку при ошибках
+func (a *Application) send(source *orchestrator, batch []*pb.PostResultRequest) {
+	b := newBackoff(time.Duration(a.config.WaitTime)*time.Millisecond, time.Duration(a.config.MaxWaitTime)*time.Millisecond)
+	var err error
+	for attempt := 0; attempt < a.config.SendAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(b.next())
+		}
+		if _, err = source.client.PostResults(context.Background(), &pb.PostResultsRequest{Results: batch, Agent: a.agent}); err == nil {
 			return
 		}
 	}
-	sendResult(client, task.Id, result)
+	log.Printf("failed to send %d results to orchestrator %s: %v", len(batch), source.addr, err)
+}
+
+// compute вычисляет задачу и возвращает результат для оркестратора или nil, если задача была отменена
+func compute(ctx context.Context, task *pb.Task) *pb.PostResultRequest {
+	if task.Expression != "" {
+		return computeTree(ctx, task)
+	}
+	select {
+	case <-time.After(time.Duration(task.OperationTime) * time.Millisecond):
+	case <-ctx.Done():
+		return nil
+	}
+	args := []float64{float64(task.Arg1), float64(task.Arg2)}
+	if op, ok := operation.Lookup(task.Operation); ok && op.Arity < len(args) {
+		args = args[:op.Arity]
+	}
+	result, err := operation.Apply(task.Operation, args...)
+	return resultMessage(task.Id, result, err)
+}
+
+// computeTree вычисляет подвыражение задачи, выдерживая перед каждой операцией ее время
+func computeTree(ctx context.Context, task *pb.Task) *pb.PostResultRequest {
+	var tree calculation.Node
+	if err := json.Unmarshal([]byte(task.Expression), &tree); err != nil {
+		return computeError(task.Id, pb.ErrorCode_ERROR_CODE_UNSPECIFIED, "invalid subexpression: "+err.Error())
+	}
+	result, err := calculation.EvalWith(&tree, func(name string, args ...float64) (float64, error) {
+		select {
+		case <-time.After(time.Duration(task.OperationTimes[name]) * time.Millisecond):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		return operation.Apply(name, args...)
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return resultMessage(task.Id, result, err)
+}
+
+// resultMessage возвращает результат или ошибку вычисления задачи для оркестратора
+func resultMessage(id int64, result float64, err error) *pb.PostResultRequest {
+	if err == nil {
+		// Результат передается как float32 и может не поместиться в него
+		err = operation.CheckFinite(float64(float32(result)))
+	}
+	if err != nil {
+		// Временную ошибку, например перезапуск плагина, оркестратор повторит
+		if errors.Is(err, operation.ErrTemporary) {
+			return &pb.PostResultRequest{Id: id, Error: err.Error(), Retryable: true}
+		}
+		var opErr *operation.Error
+		if errors.As(err, &opErr) {
+			return computeError(id, errorCode(opErr.Code), opErr.Message)
+		}
+		return computeError(id, pb.ErrorCode_ERROR_CODE_UNSPECIFIED, err.Error())
+	}
+	return &pb.PostResultRequest{Id: id, Result: float32(result)}
+}
+
+// computeError возвращает сообщение об ошибке вычисления задачи
+func computeError(id int64, code pb.ErrorCode, text string) *pb.PostResultRequest {
+	return &pb.PostResultRequest{Id: id, Error: text, ErrorCode: code}
+}
+
+// errorCode переводит тип ошибки реестра операций в тип ошибки протокола: division_by_zero - ERROR_CODE_DIVISION_BY_ZERO
+func errorCode(code operation.Code) pb.ErrorCode {
+	return pb.ErrorCode(pb.ErrorCode_value["ERROR_CODE_"+strings.ToUpper(string(code))])
 }
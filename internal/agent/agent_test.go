@@ -0,0 +1,369 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeServer - оркестратор, выдающий задачи из очереди и запоминающий результаты
+type fakeServer struct {
+	pb.UnimplementedTaskServiceServer
+
+	mu       sync.Mutex
+	queue    []*pb.Task
+	results  map[int64]float32
+	released []int64      // Задачи, возвращенные агентом невычисленными
+	polls    atomic.Int32 // Количество запросов задач
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{results: make(map[int64]float32)}
+}
+
+func (f *fakeServer) add(task *pb.Task) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queue = append(f.queue, task)
+}
+
+func (f *fakeServer) result(id int64) (float32, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	result, ok := f.results[id]
+	return result, ok
+}
+
+func (f *fakeServer) GetTasks(ctx context.Context, in *pb.GetTasksRequest) (*pb.GetTasksResponse, error) {
+	f.polls.Add(1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := min(int(in.MaxN), len(f.queue))
+	if n == 0 {
+		return nil, status.Error(codes.NotFound, "no tasks available")
+	}
+	tasks := f.queue[:n]
+	f.queue = f.queue[n:]
+	return &pb.GetTasksResponse{Tasks: tasks, QueueDepth: int32(len(f.queue))}, nil
+}
+
+func (f *fakeServer) PostResults(ctx context.Context, in *pb.PostResultsRequest) (*pb.PostResultsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	statuses := make([]*pb.PostResultResponse, len(in.Results))
+	for i, result := range in.Results {
+		f.results[result.Id] = result.Result
+		statuses[i] = &pb.PostResultResponse{Status: "ok"}
+	}
+	return &pb.PostResultsResponse{Statuses: statuses}, nil
+}
+
+func (f *fakeServer) Heartbeat(ctx context.Context, in *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	return &pb.HeartbeatResponse{}, nil
+}
+
+func (f *fakeServer) ReleaseTasks(ctx context.Context, in *pb.ReleaseTasksRequest) (*pb.ReleaseTasksResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.released = append(f.released, in.TaskIds...)
+	return &pb.ReleaseTasksResponse{Status: "ok"}, nil
+}
+
+// serve запускает gRPC сервер на addr и возвращает его вместе с фактическим адресом
+func serve(t *testing.T, addr string, f *fakeServer) (*grpc.Server, string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterTaskServiceServer(s, f)
+	go s.Serve(lis)
+	return s, lis.Addr().String()
+}
+
+// waitResult ждет, пока агент пришлет результат задачи
+func waitResult(t *testing.T, f *fakeServer, id int64, timeout time.Duration) float32 {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if result, ok := f.result(id); ok {
+			return result
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected result of task %d within %v, but got none", id, timeout)
+	return 0
+}
+
+func newTestAgent(addr string) *Application {
+	config := ConfigFromEnv()
+	config.OrchestratorAddr = addr
+	config.ComputingPower = 2
+	config.WaitTime = 10
+	config.MaxWaitTime = 200
+	return NewWithConfig(config)
+}
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(100*time.Millisecond, time.Second)
+	limits := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second, // Задержка ограничена сверху
+		time.Second,
+	}
+	for i, limit := range limits {
+		// Случайный разброс не уменьшает задержку больше чем вдвое
+		if d := b.next(); d < limit/2 || d > limit {
+			t.Errorf("Attempt %d: expected backoff between %v and %v, but got %v", i+1, limit/2, limit, d)
+		}
+	}
+
+	b.reset()
+	if d := b.next(); d > 100*time.Millisecond {
+		t.Errorf("Expected backoff to be reset, but got %v", d)
+	}
+
+	// Агенты, потерявшие оркестратор одновременно, получают разные задержки
+	delays := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		b := newBackoff(100*time.Millisecond, time.Second)
+		delays[b.next()] = true
+	}
+	if len(delays) < 2 {
+		t.Errorf("Expected jittered backoff, but got the same delay 20 times: %v", delays)
+	}
+
+	// Предельная задержка не бывает меньше начальной
+	b = newBackoff(time.Second, 100*time.Millisecond)
+	if d := b.next(); d < 500*time.Millisecond || d > time.Second {
+		t.Errorf("Expected backoff between 500ms and 1s when max is below base, but got %v", d)
+	}
+	// Без задержки опрос не ждет
+	if d := newBackoff(0, 0).next(); d != 0 {
+		t.Errorf("Expected zero backoff, but got %v", d)
+	}
+}
+
+func TestEmptyQueueBackoff(t *testing.T) {
+	f := newFakeServer()
+	s, addr := serve(t, "127.0.0.1:0", f)
+	defer s.Stop()
+
+	a := newTestAgent(addr)
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Shutdown(context.Background())
+
+	// При опросе с постоянной паузой WaitTime за это время было бы около 50 запросов
+	time.Sleep(500 * time.Millisecond)
+	if polls := f.polls.Load(); polls > 15 {
+		t.Fatalf("Expected agent to back off on empty queue, but got %d polls", polls)
+	}
+
+	// После появления задачи агент забирает ее не позже предельной паузы
+	f.add(&pb.Task{Id: 1, Arg1: 4, Arg2: 2, Operation: "/"})
+	if result := waitResult(t, f, 1, time.Second); result != 2 {
+		t.Fatalf("Expected 2, but got %v", result)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	f := newFakeServer()
+	s, addr := serve(t, "127.0.0.1:0", f)
+
+	a := newTestAgent(addr)
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Shutdown(context.Background())
+
+	f.add(&pb.Task{Id: 1, Arg1: 1, Arg2: 2, Operation: "+"})
+	if result := waitResult(t, f, 1, 2*time.Second); result != 3 {
+		t.Fatalf("Expected 3, but got %v", result)
+	}
+
+	// Оркестратор пропадает
+	s.Stop()
+	time.Sleep(300 * time.Millisecond)
+
+	// и возвращается с новыми задачами на том же адресе
+	f2 := newFakeServer()
+	f2.add(&pb.Task{Id: 2, Arg1: 2, Arg2: 3, Operation: "*"})
+	s, _ = serve(t, addr, f2)
+	defer s.Stop()
+
+	if result := waitResult(t, f2, 2, 5*time.Second); result != 6 {
+		t.Fatalf("Expected 6, but got %v", result)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	f := newFakeServer()
+	s, addr := serve(t, "127.0.0.1:0", f)
+	defer s.Stop()
+
+	// waitRunning ждет, пока агент заберет все задачи из очереди
+	var a *Application
+	waitRunning := func() {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			f.mu.Lock()
+			n := len(f.queue)
+			f.mu.Unlock()
+			if n == 0 && a.Workers() > 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("Expected agent to take tasks from the queue")
+	}
+
+	// Начатая задача довычисляется, и ее результат отправляется до остановки
+	a = newTestAgent(addr)
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	f.add(&pb.Task{Id: 1, Arg1: 1, Arg2: 2, Operation: "+", OperationTime: 200})
+	waitRunning()
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if result, ok := f.result(1); !ok || result != 3 {
+		t.Errorf("Expected result 3 to be posted before shutdown, but got %v %v", result, ok)
+	}
+	// После остановки агент больше не запрашивает задачи
+	polls := f.polls.Load()
+	time.Sleep(100 * time.Millisecond)
+	if f.polls.Load() != polls {
+		t.Error("Expected stopped agent not to poll")
+	}
+
+	// Задача, не успевшая вычислиться до конца ожидания, возвращается оркестратору
+	a = newTestAgent(addr)
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	f.add(&pb.Task{Id: 2, Arg1: 1, Arg2: 2, Operation: "*", OperationTime: 60000})
+	waitRunning()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := a.Shutdown(ctx); err == nil {
+		t.Error("Expected shutdown to report that in-flight tasks were interrupted")
+	}
+	if _, ok := f.result(2); ok {
+		t.Error("Expected interrupted task to have no result")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.released) != 1 || f.released[0] != 2 {
+		t.Errorf("Expected task 2 to be released, but got %v", f.released)
+	}
+}
+
+func TestScaler(t *testing.T) {
+	s := &scaler{min: 1, max: 8, maxCPU: 80}
+	now := time.Now()
+
+	// Большой пакет задач: горутины добавляются сразу, но не больше max
+	if n := s.target(2, 2, 3, 10, now); n != 5 {
+		t.Errorf("Expected 5 workers, but got %d", n)
+	}
+	if n := s.target(2, 2, 100, 10, now); n != 8 {
+		t.Errorf("Expected 8 workers, but got %d", n)
+	}
+
+	// Лишние горутины останавливаются только после scaleDownDelay
+	if n := s.target(8, 0, 0, 10, now); n != 8 {
+		t.Errorf("Expected 8 workers before delay, but got %d", n)
+	}
+	if n := s.target(8, 0, 0, 10, now.Add(scaleDownDelay)); n != 1 {
+		t.Errorf("Expected 1 worker after delay, but got %d", n)
+	}
+
+	// При высокой загрузке процессора горутины не добавляются и постепенно убираются
+	s = &scaler{min: 1, max: 8, maxCPU: 80}
+	if n := s.target(4, 4, 100, 95, now); n != 4 {
+		t.Errorf("Expected 4 workers under high cpu, but got %d", n)
+	}
+	if n := s.target(4, 4, 100, 95, now.Add(scaleDownDelay)); n != 3 {
+		t.Errorf("Expected 3 workers under high cpu after delay, but got %d", n)
+	}
+	if n := s.target(1, 1, 100, 95, now.Add(2*scaleDownDelay)); n != 1 {
+		t.Errorf("Expected at least min workers, but got %d", n)
+	}
+}
+
+func TestAutoscale(t *testing.T) {
+	f := newFakeServer()
+	s, addr := serve(t, "127.0.0.1:0", f)
+	defer s.Stop()
+	for i := int64(1); i <= 12; i++ {
+		f.add(&pb.Task{Id: i, Arg1: float32(i), Arg2: 1, Operation: "+", OperationTime: 200})
+	}
+
+	a := newTestAgent(addr)
+	a.config.ComputingPower = 1
+	a.config.MinComputingPower = 1
+	a.config.MaxComputingPower = 4
+	a.config.MaxCPU = 0
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Shutdown(context.Background())
+
+	deadline := time.Now().Add(time.Second)
+	for a.Workers() < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := a.Workers(); n != 4 {
+		t.Fatalf("Expected agent to scale up to 4 workers, but got %d", n)
+	}
+	for i := int64(1); i <= 12; i++ {
+		if result := waitResult(t, f, i, 2*time.Second); result != float32(i+1) {
+			t.Fatalf("Task %d: expected %v, but got %v", i, i+1, result)
+		}
+	}
+}
+
+func TestComputeTree(t *testing.T) {
+	task := &pb.Task{
+		Id:             1,
+		Operation:      "subtree",
+		Expression:     `{"op":"-","args":[{"op":"*","args":[{"value":3},{"value":7}]},{"op":"/","args":[{"value":10},{"value":5}]}]}`,
+		OperationTimes: map[string]int64{"*": 30, "/": 30, "-": 30},
+	}
+	started := time.Now()
+	result := compute(context.Background(), task)
+	if result == nil || result.Error != "" || result.Result != 19 {
+		t.Fatalf("Expected 19, but got %v", result)
+	}
+	// Время каждой операции выдерживается отдельно
+	if elapsed := time.Since(started); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected subexpression to take at least 90ms, but took %v", elapsed)
+	}
+
+	task.Expression = `{"op":"/","args":[{"value":1},{"op":"-","args":[{"value":2},{"value":2}]}]}`
+	if result := compute(context.Background(), task); result == nil || result.ErrorCode != pb.ErrorCode_ERROR_CODE_DIVISION_BY_ZERO {
+		t.Errorf("Expected division by zero, but got %v", result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if result := compute(ctx, task); result != nil {
+		t.Errorf("Expected cancelled task to have no result, but got %v", result)
+	}
+}
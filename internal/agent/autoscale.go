@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Время, в течение которого горутин должно быть больше нужного, прежде чем агент их остановит.
+// Без задержки агент сокращал бы горутины в каждой паузе между пакетами задач
+const scaleDownDelay = 5 * time.Second
+
+// Минимальный интервал между замерами загрузки процессора
+const cpuSampleInterval = time.Second
+
+// scaler выбирает количество горутин между min и max: столько, сколько нужно для уже полученных
+// задач и задач, оставшихся в очереди. Если загрузка процессора выше maxCPU, горутины не добавляются,
+// а лишние постепенно останавливаются, чтобы не мешать другим процессам на той же машине
+type scaler struct {
+	min, max int
+	maxCPU   float64   // Предельная загрузка процессора в процентах, 0 - не учитывается
+	excess   time.Time // Начало периода, в котором горутин больше нужного, нулевое - если нет
+}
+
+// target возвращает количество горутин, которое должно быть у агента сейчас
+func (s *scaler) target(workers, busy, queued int, cpu float64, now time.Time) int {
+	want := min(max(busy+queued, s.min), s.max)
+	if s.maxCPU > 0 && cpu >= s.maxCPU {
+		want = max(min(want, workers-1), s.min)
+	}
+	if want >= workers {
+		s.excess = time.Time{}
+		return want
+	}
+	// Горутины останавливаются, только если они не нужны дольше scaleDownDelay
+	if s.excess.IsZero() {
+		s.excess = now
+	}
+	if now.Sub(s.excess) < scaleDownDelay {
+		return workers
+	}
+	s.excess = now
+	return want
+}
+
+// cpuSampler измеряет загрузку процессора машины по /proc/stat между соседними замерами
+type cpuSampler struct {
+	mu         sync.Mutex
+	sampled    time.Time
+	idle, busy uint64
+	usage      float64
+}
+
+// Usage возвращает загрузку процессора в процентах с последнего замера. Замеры выполняются
+// не чаще cpuSampleInterval; если загрузку узнать нельзя, возвращается 0
+func (c *cpuSampler) Usage() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.sampled) < cpuSampleInterval {
+		return c.usage
+	}
+	idle, busy, err := readCPUTimes()
+	if err != nil {
+		return 0
+	}
+	if !c.sampled.IsZero() && idle+busy > c.idle+c.busy {
+		c.usage = 100 * float64(busy-c.busy) / float64(idle+busy-c.idle-c.busy)
+	}
+	c.sampled, c.idle, c.busy = now, idle, busy
+	return c.usage
+}
+
+// readCPUTimes возвращает суммарное время простоя и работы всех процессоров из /proc/stat
+func readCPUTimes() (idle, busy uint64, err error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		fields := strings.Fields(lines.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		// user nice system idle iowait irq softirq steal ...
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			if i == 3 || i == 4 {
+				idle += value
+			} else if i < 8 {
+				busy += value
+			}
+		}
+		return idle, busy, nil
+	}
+	return 0, 0, errors.New("no cpu line in /proc/stat")
+}
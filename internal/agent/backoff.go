@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff вычисляет экспоненциально растущие задержки со случайным разбросом,
+// чтобы агенты, потерявшие оркестратор одновременно, не обращались к нему синхронно
+type backoff struct {
+	base    time.Duration // Задержка после первой неудачи
+	max     time.Duration // Предельная задержка
+	attempt int           // Количество неудач подряд
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	if max < base {
+		max = base
+	}
+	return &backoff{base: base, max: max}
+}
+
+// next возвращает задержку перед следующей попыткой: половина удвоенной на каждой неудаче
+// задержки соблюдается всегда, вторая половина выбирается случайно
+func (b *backoff) next() time.Duration {
+	d := b.base
+	for i := 0; i < b.attempt && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	b.attempt++
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// reset сбрасывает задержку после успешной попытки
+func (b *backoff) reset() {
+	b.attempt = 0
+}
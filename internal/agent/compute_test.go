@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name         string
+		task         *pb.Task
+		expected     float32
+		expectedCode pb.ErrorCode
+	}{
+		{"addition", &pb.Task{Arg1: 1, Arg2: 2, Operation: "+"}, 3, pb.ErrorCode_ERROR_CODE_UNSPECIFIED},
+		{"division", &pb.Task{Arg1: 6, Arg2: 3, Operation: "/"}, 2, pb.ErrorCode_ERROR_CODE_UNSPECIFIED},
+		{"division by zero", &pb.Task{Arg1: 1, Arg2: 0, Operation: "/"}, 0, pb.ErrorCode_ERROR_CODE_DIVISION_BY_ZERO},
+		{"unsupported operation", &pb.Task{Arg1: 1, Arg2: 2, Operation: "%"}, 0, pb.ErrorCode_ERROR_CODE_UNSUPPORTED_OPERATION},
+		{"overflow", &pb.Task{Arg1: math.MaxFloat32, Arg2: 2, Operation: "*"}, 0, pb.ErrorCode_ERROR_CODE_OVERFLOW},
+		{"negative overflow", &pb.Task{Arg1: -math.MaxFloat32, Arg2: math.MaxFloat32, Operation: "-"}, 0, pb.ErrorCode_ERROR_CODE_OVERFLOW},
+		{"nan", &pb.Task{Arg1: float32(math.Inf(1)), Arg2: float32(math.Inf(1)), Operation: "-"}, 0, pb.ErrorCode_ERROR_CODE_NAN},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := compute(context.Background(), test.task)
+			if result.ErrorCode != test.expectedCode {
+				t.Fatalf("Expected error code %v, but got %v (%q)", test.expectedCode, result.ErrorCode, result.Error)
+			}
+			if test.expectedCode != pb.ErrorCode_ERROR_CODE_UNSPECIFIED && result.Error == "" {
+				t.Fatalf("Expected error message for code %v", test.expectedCode)
+			}
+			if result.Result != test.expected {
+				t.Fatalf("Expected %v, but got %v", test.expected, result.Result)
+			}
+		})
+	}
+}
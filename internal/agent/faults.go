@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+// Faults - вероятности сбоев, которые агент вносит в свою работу, чтобы проверить устойчивость
+// оркестратора. Каждая вероятность проверяется для каждой вычисленной задачи
+type Faults struct {
+	Drop      float64 // Результат не отправляется
+	Delay     float64 // Результат отправляется через FaultDelay, когда аренда задачи уже истекла
+	Wrong     float64 // Вместо результата отправляется неверное значение
+	Crash     float64 // Агент завершается посреди вычисления задачи, не отправив результат
+	Duplicate float64 // Результат отправляется дважды
+}
+
+// ParseFaults разбирает вероятности сбоев, заданные в виде drop=0.1,delay=0.05 через запятую
+func ParseFaults(s string) (Faults, error) {
+	var faults Faults
+	if s == "" {
+		return faults, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		name, strValue, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return Faults{}, fmt.Errorf("invalid fault %q, expected name=probability", pair)
+		}
+		value, err := strconv.ParseFloat(strValue, 64)
+		if err != nil || value < 0 || value > 1 {
+			return Faults{}, fmt.Errorf("invalid probability of fault %q: %q", name, strValue)
+		}
+		switch name {
+		case "drop":
+			faults.Drop = value
+		case "delay":
+			faults.Delay = value
+		case "wrong":
+			faults.Wrong = value
+		case "crash":
+			faults.Crash = value
+		case "duplicate":
+			faults.Duplicate = value
+		default:
+			return Faults{}, fmt.Errorf("unknown fault %q", name)
+		}
+	}
+	return faults, nil
+}
+
+// String возвращает вероятности сбоев в том же виде, в котором их принимает ParseFaults
+func (f Faults) String() string {
+	var pairs []string
+	for _, fault := range []struct {
+		name  string
+		value float64
+	}{{"drop", f.Drop}, {"delay", f.Delay}, {"wrong", f.Wrong}, {"crash", f.Crash}, {"duplicate", f.Duplicate}} {
+		if fault.value > 0 {
+			pairs = append(pairs, fault.name+"="+strconv.FormatFloat(fault.value, 'g', -1, 64))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set позволяет задавать сбои флагом командной строки
+func (f *Faults) Set(s string) error {
+	faults, err := ParseFaults(s)
+	if err != nil {
+		return err
+	}
+	*f = faults
+	return nil
+}
+
+// happens проверяет, произошел ли сбой с вероятностью p
+func happens(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+// injectCrash с вероятностью Crash завершает агента посреди вычисления задачи: задача уже получена
+// и продлевается агентом, а результат еще не вычислен и не поставлен в очередь на отправку.
+// Завершается весь процесс, поэтому в процессе с другими компонентами Run такой сбой не допускает
+func (a *Application) injectCrash(task *pb.Task) {
+	if a.config.Shared || !happens(a.config.Faults.Crash) {
+		return
+	}
+	// Агент успевает выполнить случайную часть операции
+	if task.OperationTime > 0 {
+		time.Sleep(time.Duration(rand.Int64N(task.OperationTime)) * time.Millisecond)
+	}
+	log.Printf("fault injection: crashing while computing task %d", task.Id)
+	os.Exit(3)
+}
+
+// injectFaults вносит сбои в отправку результата задачи и возвращает результаты, которые нужно
+// отправить оркестратору: ни одного, один или два одинаковых
+func (a *Application) injectFaults(task *pb.Task, result *pb.PostResultRequest) []*pb.PostResultRequest {
+	faults := a.config.Faults
+	if happens(faults.Drop) {
+		log.Printf("fault injection: dropping result of task %d", task.Id)
+		a.metrics.fault("drop")
+		return nil
+	}
+	if happens(faults.Wrong) && result.Error == "" {
+		log.Printf("fault injection: sending wrong result of task %d", task.Id)
+		a.metrics.fault("wrong")
+		// Случайное значение, чтобы неверные результаты разных агентов не совпадали
+		result.Result += float32(1 + rand.IntN(1000))
+	}
+	if happens(faults.Delay) {
+		// Задача уже снята с учета, поэтому ее аренда не продлевается
+		delay := time.Duration(a.config.FaultDelay) * time.Millisecond
+		log.Printf("fault injection: delaying result of task %d by %v", task.Id, delay)
+		a.metrics.fault("delay")
+		time.Sleep(delay)
+	}
+	if happens(faults.Duplicate) {
+		log.Printf("fault injection: sending result of task %d twice", task.Id)
+		a.metrics.fault("duplicate")
+		return []*pb.PostResultRequest{result, result}
+	}
+	return []*pb.PostResultRequest{result}
+}
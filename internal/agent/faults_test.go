@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"testing"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestParseFaults(t *testing.T) {
+	faults, err := ParseFaults("drop=0.1, delay=0.05,duplicate=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Faults{Drop: 0.1, Delay: 0.05, Duplicate: 1}); faults != want {
+		t.Fatalf("Expected %+v, but got %+v", want, faults)
+	}
+	if s := faults.String(); s != "drop=0.1,delay=0.05,duplicate=1" {
+		t.Errorf("Expected faults to be formatted back, but got %q", s)
+	}
+
+	for _, s := range []string{"drop", "drop=2", "drop=-0.1", "explode=0.1", "wrong=x"} {
+		if _, err := ParseFaults(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestInjectFaults(t *testing.T) {
+	a := NewWithConfig(&Config{})
+	task := &pb.Task{Id: 1}
+
+	a.config.Faults = Faults{Drop: 1}
+	if results := a.injectFaults(task, &pb.PostResultRequest{Id: 1, Result: 3}); len(results) != 0 {
+		t.Errorf("Expected result to be dropped, but got %v", results)
+	}
+
+	a.config.Faults = Faults{Wrong: 1, Duplicate: 1}
+	results := a.injectFaults(task, &pb.PostResultRequest{Id: 1, Result: 3})
+	if len(results) != 2 || results[0].Result == 3 || results[1].Result != results[0].Result {
+		t.Errorf("Expected wrong result sent twice, but got %v", results)
+	}
+
+	// Ошибка вычисления не подменяется значением
+	a.config.Faults = Faults{Wrong: 1}
+	results = a.injectFaults(task, &pb.PostResultRequest{Id: 1, Error: "division by zero"})
+	if len(results) != 1 || results[0].Result != 0 {
+		t.Errorf("Expected error to be sent unchanged, but got %v", results)
+	}
+}
+
+func TestCrashFaultInSharedProcess(t *testing.T) {
+	a := newTestAgent("127.0.0.1:1")
+	a.config.Shared = true
+	a.config.Faults = Faults{Crash: 0.5}
+	if err := a.Run(); err == nil {
+		t.Fatal("Expected crash fault to be rejected in a shared process")
+	}
+}
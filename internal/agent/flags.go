@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"flag"
+	"strings"
+)
+
+// RegisterFlags регистрирует флаги командной строки, повторяющие переменные окружения.
+// Значения по умолчанию берутся из текущей конфигурации, поэтому флаги переопределяют окружение
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ID, "agent-id", c.ID, "идентификатор агента (AGENT_ID)")
+	fs.StringVar(&c.OrchestratorAddr, "orchestrator-addr", c.OrchestratorAddr, "адреса gRPC серверов оркестраторов через запятую в порядке приоритета (ORCHESTRATOR_ADDR)")
+	fs.BoolVar(&c.PullAll, "pull-all", c.PullAll, "запрашивать задачи у всех доступных оркестраторов, а не только у первого (PULL_ALL_ORCHESTRATORS)")
+	fs.IntVar(&c.HealthCheckInterval, "health-check-interval", c.HealthCheckInterval, "пауза между проверками здоровья оркестраторов в миллисекундах (HEALTH_CHECK_MS)")
+	fs.IntVar(&c.ComputingPower, "computing-power", c.ComputingPower, "количество одновременно вычисляемых задач (COMPUTING_POWER)")
+	fs.IntVar(&c.MinComputingPower, "min-computing-power", c.MinComputingPower, "минимальное количество горутин при автомасштабировании, 0 - равно computing-power (MIN_COMPUTING_POWER)")
+	fs.IntVar(&c.MaxComputingPower, "max-computing-power", c.MaxComputingPower, "максимальное количество горутин при автомасштабировании, 0 - равно computing-power (MAX_COMPUTING_POWER)")
+	fs.Float64Var(&c.MaxCPU, "max-cpu-percent", c.MaxCPU, "загрузка процессора в процентах, выше которой агент не добавляет горутины, 0 - не учитывать (MAX_CPU_PERCENT)")
+	fs.IntVar(&c.WaitTime, "wait-time", c.WaitTime, "пауза между запросами задач в миллисекундах (WAIT_TIME)")
+	fs.IntVar(&c.MaxWaitTime, "max-wait-time", c.MaxWaitTime, "предельная пауза между запросами при ошибках и пустой очереди в миллисекундах (MAX_WAIT_TIME)")
+	fs.Func("operations", "поддерживаемые операции через запятую, по умолчанию "+strings.Join(c.Operations, ",")+" (OPERATIONS)", func(value string) error {
+		c.Operations = strings.Split(value, ",")
+		return nil
+	})
+	fs.Float64Var(&c.MaxOperand, "max-operand", c.MaxOperand, "максимальный модуль аргумента, 0 - без ограничений (MAX_OPERAND)")
+	fs.Var(&c.Labels, "labels", "метки агента, например pool=premium,region=lab2 (LABELS)")
+	fs.Func("plugins", "исполняемые файлы плагинов через запятую (PLUGINS)", func(value string) error {
+		c.Plugins = strings.Split(value, ",")
+		return nil
+	})
+	fs.IntVar(&c.PluginTimeout, "plugin-timeout", c.PluginTimeout, "время на ответ плагина в миллисекундах (PLUGIN_TIMEOUT_MS)")
+	fs.StringVar(&c.StatusAddr, "status-addr", c.StatusAddr, "адрес HTTP сервера с /healthz, /metrics и /debug/tasks, например :9090 (STATUS_ADDR)")
+	fs.Var(&c.Faults, "faults", "вероятности сбоев для проверки оркестратора, например drop=0.1,delay=0.05,wrong=0.01,crash=0.01,duplicate=0.1 (FAULTS)")
+	fs.IntVar(&c.FaultDelay, "fault-delay", c.FaultDelay, "задержка результата при сбое delay в миллисекундах (FAULT_DELAY_MS)")
+	fs.IntVar(&c.SendAttempts, "send-attempts", c.SendAttempts, "количество попыток отправки результатов (SEND_ATTEMPTS)")
+}
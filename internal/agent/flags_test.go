@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestFlags(t *testing.T) {
+	t.Setenv("AGENT_ID", "env-agent")
+	t.Setenv("COMPUTING_POWER", "4")
+	t.Setenv("OPERATIONS", "+,-")
+	t.Setenv("LABELS", "pool=premium")
+
+	config := ConfigFromEnv()
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-computing-power", "8", "-operations", "*,/", "-labels", "region=lab2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Флаг переопределяет переменную окружения, а без флага остается значение из окружения
+	if config.ComputingPower != 8 || !reflect.DeepEqual(config.Operations, []string{"*", "/"}) {
+		t.Errorf("Expected flags to override environment, but got %d %v", config.ComputingPower, config.Operations)
+	}
+	if !reflect.DeepEqual(config.Labels, Labels{"region": "lab2"}) {
+		t.Errorf("Expected labels from flag, but got %v", config.Labels)
+	}
+	if config.ID != "env-agent" {
+		t.Errorf("Expected agent id from environment, but got %q", config.ID)
+	}
+}
@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Labels - метки агента, по которым оркестратор выбирает для него задачи. Агент с меткой pool
+// получает только задачи выражений этого пула
+type Labels map[string]string
+
+// ParseLabels разбирает метки, заданные в виде pool=premium,region=lab2 через запятую
+func ParseLabels(s string) (Labels, error) {
+	labels := make(Labels)
+	if s == "" {
+		return labels, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// String возвращает метки в том же виде, в котором их принимает ParseLabels
+func (l Labels) String() string {
+	pairs := make([]string, 0, len(l))
+	for key, value := range l {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set позволяет задавать метки флагом командной строки
+func (l *Labels) Set(s string) error {
+	labels, err := ParseLabels(s)
+	if err != nil {
+		return err
+	}
+	*l = labels
+	return nil
+}
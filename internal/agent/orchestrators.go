@@ -0,0 +1,229 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+	"google.golang.org/grpc"
+	grpcbackoff "google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// orchestrator - соединение агента с одним из оркестраторов
+type orchestrator struct {
+	addr    string
+	conn    *grpc.ClientConn
+	client  pb.TaskServiceClient
+	health  healthpb.HealthClient
+	healthy atomic.Bool // Оркестратор прошел последнюю проверку здоровья и отвечал на запросы
+	failing bool        // Последний запрос задач завершился ошибкой, меняется только циклом запросов
+}
+
+// job - задача вместе с выдавшим ее оркестратором
+type job struct {
+	source *orchestrator
+	task   *pb.Task
+}
+
+// taskKey идентифицирует задачу агента: у разных оркестраторов номера задач могут совпадать
+type taskKey struct {
+	source *orchestrator
+	id     int64
+}
+
+// taskResult - результат задачи для выдавшего ее оркестратора
+type taskResult struct {
+	source *orchestrator
+	result *pb.PostResultRequest
+}
+
+// parseAddrs разбирает адреса оркестраторов через запятую
+func parseAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// connect создает клиенты всех оркестраторов из OrchestratorAddr. Соединения устанавливаются в фоне,
+// а до первой проверки здоровья все оркестраторы считаются доступными
+func (a *Application) connect() error {
+	addrs := parseAddrs(a.config.OrchestratorAddr)
+	if len(addrs) == 0 {
+		return fmt.Errorf("no orchestrator address")
+	}
+	waitTime := time.Duration(a.config.WaitTime) * time.Millisecond
+	maxWaitTime := time.Duration(a.config.MaxWaitTime) * time.Millisecond
+	for _, addr := range addrs {
+		conn, err := grpc.NewClient(addr,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithConnectParams(grpc.ConnectParams{
+				Backoff: grpcbackoff.Config{
+					BaseDelay:  waitTime,
+					Multiplier: 2,
+					Jitter:     0.5,
+					MaxDelay:   maxWaitTime,
+				},
+			}),
+		)
+		if err != nil {
+			a.disconnect()
+			return fmt.Errorf("failed to create client of orchestrator %s: %w", addr, err)
+		}
+		o := &orchestrator{
+			addr:   addr,
+			conn:   conn,
+			client: pb.NewTaskServiceClient(conn),
+			health: healthpb.NewHealthClient(conn),
+		}
+		o.healthy.Store(true)
+		a.orchestrators = append(a.orchestrators, o)
+	}
+	a.current.Store(a.orchestrators[0])
+	return nil
+}
+
+// disconnect закрывает соединения со всеми оркестраторами
+func (a *Application) disconnect() {
+	for _, o := range a.orchestrators {
+		o.conn.Close()
+	}
+}
+
+// active возвращает оркестратор, у которого агент запрашивает задачи, если не задан PullAll
+func (a *Application) active() *orchestrator {
+	return a.current.Load()
+}
+
+// selectActive делает текущим первый доступный оркестратор из списка, а если недоступны все - первый.
+// Поэтому после восстановления основного оркестратора агент возвращается к нему
+func (a *Application) selectActive() {
+	a.selecting.Lock()
+	defer a.selecting.Unlock()
+	next := a.orchestrators[0]
+	for _, o := range a.orchestrators {
+		if o.healthy.Load() {
+			next = o
+			break
+		}
+	}
+	if previous := a.current.Swap(next); previous != next {
+		log.Printf("switched from orchestrator %s to %s", previous.addr, next.addr)
+		a.signalReady()
+	}
+}
+
+// setHealthy запоминает доступность оркестратора и при ее изменении выбирает текущий оркестратор заново
+func (a *Application) setHealthy(o *orchestrator, healthy bool) {
+	if o.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		log.Println("orchestrator", o.addr, "is healthy")
+	} else {
+		log.Println("orchestrator", o.addr, "is unhealthy")
+	}
+	a.selectActive()
+	if healthy {
+		a.signalReady()
+	}
+}
+
+// recovered отмечает, что оркестратор снова отвечает на запросы задач. Доступным его снова
+// делает только проверка здоровья, чтобы не переключаться на останавливающийся оркестратор
+func (a *Application) recovered(o *orchestrator) {
+	if o.failing {
+		log.Println("orchestrator", o.addr, "is available again")
+		o.failing = false
+	}
+}
+
+// signalReady сообщает циклу запросов, что появился доступный оркестратор и ждать окончания задержки незачем
+func (a *Application) signalReady() {
+	select {
+	case a.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pullTargets возвращает оркестраторы, у которых запрашиваются задачи: текущий или, если задан
+// PullAll, все доступные, начиная с round-го по кругу, чтобы ни один из них не ждал остальных
+func (a *Application) pullTargets(round int) []*orchestrator {
+	if !a.config.PullAll {
+		return []*orchestrator{a.active()}
+	}
+	var targets []*orchestrator
+	n := len(a.orchestrators)
+	for i := 0; i < n; i++ {
+		if o := a.orchestrators[(round+i)%n]; o.healthy.Load() {
+			targets = append(targets, o)
+		}
+	}
+	if len(targets) == 0 {
+		targets = append(targets, a.active())
+	}
+	return targets
+}
+
+// checkHealth проверяет оркестратор по стандартному протоколу здоровья gRPC каждые HealthCheckInterval.
+// Оркестратор без сервиса здоровья считается доступным, пока отвечает
+func (a *Application) checkHealth(o *orchestrator) {
+	interval := time.Duration(a.config.HealthCheckInterval) * time.Millisecond
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-time.After(interval):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		response, err := o.health.Check(ctx, &healthpb.HealthCheckRequest{Service: pb.TaskService_ServiceDesc.ServiceName})
+		cancel()
+		switch {
+		case err == nil:
+			a.setHealthy(o, response.Status == healthpb.HealthCheckResponse_SERVING)
+		case status.Code(err) == codes.Unimplemented:
+			a.setHealthy(o, true)
+		default:
+			a.setHealthy(o, false)
+		}
+	}
+}
+
+// watchConnection следит за состоянием соединения с оркестратором и сообщает циклу запросов,
+// когда соединение снова готово к работе
+func (a *Application) watchConnection(o *orchestrator) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-a.done:
+		case <-a.stop:
+		}
+		cancel()
+	}()
+
+	state := o.conn.GetState()
+	for o.conn.WaitForStateChange(ctx, state) {
+		previous := state
+		state = o.conn.GetState()
+		switch {
+		case state == connectivity.Ready:
+			log.Println("connected to orchestrator", o.addr)
+			a.signalReady()
+		case state == connectivity.TransientFailure && previous != connectivity.TransientFailure:
+			log.Println("lost connection to orchestrator", o.addr)
+		}
+	}
+}
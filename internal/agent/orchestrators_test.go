@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// serveHealth запускает gRPC сервер с сервисом здоровья и возвращает его вместе с сервисом и адресом
+func serveHealth(t *testing.T, f *fakeServer) (*grpc.Server, *health.Server, string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterTaskServiceServer(s, f)
+	h := health.NewServer()
+	h.SetServingStatus(pb.TaskService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, h)
+	go s.Serve(lis)
+	return s, h, lis.Addr().String()
+}
+
+func TestFailover(t *testing.T) {
+	primary, standby := newFakeServer(), newFakeServer()
+	s1, h1, addr1 := serveHealth(t, primary)
+	defer s1.Stop()
+	s2, _, addr2 := serveHealth(t, standby)
+	defer s2.Stop()
+
+	a := newTestAgent(addr1 + "," + addr2)
+	a.config.HealthCheckInterval = 20
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Shutdown(context.Background())
+
+	// Пока основной оркестратор доступен, резервный задач не выдает
+	standby.add(&pb.Task{Id: 1, Arg1: 2, Arg2: 2, Operation: "+"})
+	primary.add(&pb.Task{Id: 1, Arg1: 1, Arg2: 2, Operation: "+"})
+	if result := waitResult(t, primary, 1, time.Second); result != 3 {
+		t.Fatalf("Expected 3, but got %v", result)
+	}
+	if _, ok := standby.result(1); ok {
+		t.Fatal("Expected standby orchestrator to be idle while primary is healthy")
+	}
+
+	// Основной оркестратор останавливается, и агент переходит к резервному
+	h1.Shutdown()
+	if result := waitResult(t, standby, 1, time.Second); result != 4 {
+		t.Fatalf("Expected 4, but got %v", result)
+	}
+
+	// После восстановления основного агент возвращается к нему
+	h1.Resume()
+	time.Sleep(100 * time.Millisecond)
+	primary.add(&pb.Task{Id: 2, Arg1: 3, Arg2: 3, Operation: "*"})
+	if result := waitResult(t, primary, 2, time.Second); result != 9 {
+		t.Fatalf("Expected 9, but got %v", result)
+	}
+
+	// Пропавший оркестратор заменяется резервным по ошибке запроса
+	s1.Stop()
+	standby.add(&pb.Task{Id: 2, Arg1: 8, Arg2: 2, Operation: "/"})
+	if result := waitResult(t, standby, 2, 2*time.Second); result != 4 {
+		t.Fatalf("Expected 4, but got %v", result)
+	}
+}
+
+func TestPullAll(t *testing.T) {
+	first, second := newFakeServer(), newFakeServer()
+	s1, _, addr1 := serveHealth(t, first)
+	defer s1.Stop()
+	s2, _, addr2 := serveHealth(t, second)
+	defer s2.Stop()
+
+	a := newTestAgent(addr1 + "," + addr2)
+	a.config.PullAll = true
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Shutdown(context.Background())
+
+	// Номера задач разных оркестраторов совпадают, но результаты возвращаются выдавшим их
+	first.add(&pb.Task{Id: 1, Arg1: 1, Arg2: 2, Operation: "+", OperationTime: 50})
+	second.add(&pb.Task{Id: 1, Arg1: 5, Arg2: 2, Operation: "*", OperationTime: 50})
+	if result := waitResult(t, first, 1, time.Second); result != 3 {
+		t.Errorf("Expected 3 from first orchestrator, but got %v", result)
+	}
+	if result := waitResult(t, second, 1, time.Second); result != 10 {
+		t.Errorf("Expected 10 from second orchestrator, but got %v", result)
+	}
+}
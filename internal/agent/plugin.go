@@ -0,0 +1,328 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+// Плагин - внешний исполняемый файл, вычисляющий операции, которых нет в агенте.
+// Агент и плагин обмениваются JSON-сообщениями по одному в строке:
+//
+//	плагин -> агент при запуске: {"operations": [{"name": "annuity", "arity": 2, "cost_ms": 50}]}
+//	агент -> плагин:             {"id": 1, "op": "annuity", "args": [30, 0.05]}
+//	плагин -> агент:             {"id": 1, "result": 15.37} или {"id": 1, "error": "...", "code": "domain_error"}
+//
+// Запросы с разными id могут выполняться одновременно, ответы приходят в любом порядке
+
+// Предельная задержка перед перезапуском упавшего плагина
+const maxPluginRestartDelay = 10 * time.Second
+
+// Время работы, после которого плагин считается стабильным и задержка перезапуска сбрасывается
+const pluginStableTime = time.Minute
+
+// Описание операции в рукопожатии плагина
+type pluginOperation struct {
+	Name   string `json:"name"`
+	Arity  int    `json:"arity"`
+	CostMs int64  `json:"cost_ms"`
+}
+
+type pluginHandshake struct {
+	Operations []pluginOperation `json:"operations"`
+}
+
+type pluginRequest struct {
+	ID   uint64    `json:"id"`
+	Op   string    `json:"op"`
+	Args []float64 `json:"args"`
+}
+
+type pluginResponse struct {
+	ID     uint64  `json:"id"`
+	Result float64 `json:"result"`
+	Error  string  `json:"error"`
+	Code   string  `json:"code"` // Тип ошибки из pkg/operation, например domain_error
+}
+
+// plugin управляет процессом плагина и перезапускает его, если он завершился
+type plugin struct {
+	path       string
+	timeout    time.Duration // Время на рукопожатие и на каждый вызов
+	operations []pluginOperation
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser // nil, пока процесс не запущен
+	pending map[uint64]chan pluginResponse
+	nextID  uint64
+
+	stop chan struct{}
+}
+
+// loadPlugin запускает плагин, получает список его операций и начинает следить за процессом
+func loadPlugin(path string, timeout time.Duration) (*plugin, error) {
+	p := &plugin{
+		path:    path,
+		timeout: timeout,
+		pending: make(map[uint64]chan pluginResponse),
+		stop:    make(chan struct{}),
+	}
+	exited, err := p.start()
+	if err != nil {
+		return nil, err
+	}
+	go p.supervise(exited)
+	return p, nil
+}
+
+// start запускает процесс плагина и выполняет рукопожатие. Возвращенный канал получает
+// результат процесса после его завершения
+func (p *plugin) start() (<-chan error, error) {
+	cmd := exec.Command(p.path)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	lines := bufio.NewScanner(stdout)
+
+	handshake := make(chan error, 1)
+	var hs pluginHandshake
+	go func() {
+		if !lines.Scan() {
+			handshake <- fmt.Errorf("plugin exited before handshake: %v", lines.Err())
+			return
+		}
+		handshake <- json.Unmarshal(lines.Bytes(), &hs)
+	}()
+	select {
+	case err = <-handshake:
+	case <-time.After(p.timeout):
+		err = fmt.Errorf("no handshake within %v", p.timeout)
+	}
+	if err == nil {
+		err = p.checkHandshake(hs)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("plugin %s: %w", p.path, err)
+	}
+
+	p.mu.Lock()
+	p.cmd = cmd
+	p.stdin = stdin
+	p.mu.Unlock()
+
+	exited := make(chan error, 1)
+	go func() {
+		p.read(lines)
+		exited <- cmd.Wait()
+	}()
+	return exited, nil
+}
+
+// checkHandshake проверяет операции плагина. При перезапуске плагин должен объявить те же операции,
+// что и при первом запуске: они уже зарегистрированы и известны оркестратору
+func (p *plugin) checkHandshake(hs pluginHandshake) error {
+	if len(hs.Operations) == 0 {
+		return errors.New("plugin declared no operations")
+	}
+	if p.operations == nil {
+		p.operations = hs.Operations
+		return nil
+	}
+	if len(hs.Operations) != len(p.operations) {
+		return errors.New("plugin changed its operations after restart")
+	}
+	for i, op := range hs.Operations {
+		if op.Name != p.operations[i].Name || op.Arity != p.operations[i].Arity {
+			return errors.New("plugin changed its operations after restart")
+		}
+	}
+	return nil
+}
+
+// read передает ответы плагина ожидающим их вызовам. Когда процесс закрывает вывод,
+// все ожидающие вызовы завершаются временной ошибкой
+func (p *plugin) read(lines *bufio.Scanner) {
+	for lines.Scan() {
+		var response pluginResponse
+		if err := json.Unmarshal(lines.Bytes(), &response); err != nil {
+			log.Printf("plugin %s: invalid response: %v", p.path, err)
+			continue
+		}
+		p.mu.Lock()
+		ch, ok := p.pending[response.ID]
+		delete(p.pending, response.ID)
+		p.mu.Unlock()
+		if ok {
+			ch <- response
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stdin = nil
+	for id, ch := range p.pending {
+		close(ch)
+		delete(p.pending, id)
+	}
+}
+
+// supervise перезапускает завершившийся плагин с растущей задержкой
+func (p *plugin) supervise(exited <-chan error) {
+	b := newBackoff(100*time.Millisecond, maxPluginRestartDelay)
+	started := time.Now()
+	for {
+		select {
+		case err := <-exited:
+			log.Printf("plugin %s exited: %v", p.path, err)
+		case <-p.stop:
+			return
+		}
+		if time.Since(started) >= pluginStableTime {
+			b.reset()
+		}
+		for {
+			select {
+			case <-time.After(b.next()):
+			case <-p.stop:
+				return
+			}
+			var err error
+			if exited, err = p.start(); err == nil {
+				break
+			}
+			log.Println("failed to restart plugin:", err)
+		}
+		started = time.Now()
+		log.Printf("plugin %s restarted", p.path)
+	}
+}
+
+// call вычисляет операцию плагином. Если плагин не запущен, завершился или не ответил
+// за timeout, возвращается ошибка, обернутая в operation.ErrTemporary
+func (p *plugin) call(op string, args []float64) (float64, error) {
+	p.mu.Lock()
+	if p.stdin == nil {
+		p.mu.Unlock()
+		return 0, fmt.Errorf("plugin %s is not running: %w", p.path, operation.ErrTemporary)
+	}
+	p.nextID++
+	id := p.nextID
+	ch := make(chan pluginResponse, 1)
+	p.pending[id] = ch
+	data, err := json.Marshal(pluginRequest{ID: id, Op: op, Args: args})
+	if err == nil {
+		_, err = p.stdin.Write(append(data, '\n'))
+	}
+	if err != nil {
+		delete(p.pending, id)
+		p.mu.Unlock()
+		return 0, fmt.Errorf("plugin %s: %v: %w", p.path, err, operation.ErrTemporary)
+	}
+	cmd := p.cmd
+	p.mu.Unlock()
+
+	select {
+	case response, ok := <-ch:
+		if !ok {
+			return 0, fmt.Errorf("plugin %s exited: %w", p.path, operation.ErrTemporary)
+		}
+		if response.Error != "" {
+			return 0, &operation.Error{Code: operation.Code(response.Code), Message: response.Error}
+		}
+		return response.Result, nil
+	case <-time.After(p.timeout):
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+		// Плагин, не ответивший вовремя, мог зависнуть, поэтому он перезапускается
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("plugin %s did not respond within %v: %w", p.path, p.timeout, operation.ErrTemporary)
+	case <-p.stop:
+		return 0, fmt.Errorf("plugin %s stopped: %w", p.path, operation.ErrTemporary)
+	}
+}
+
+// close останавливает плагин
+func (p *plugin) close() {
+	close(p.stop)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd != nil {
+		p.cmd.Process.Kill()
+	}
+}
+
+// loadPlugins запускает плагины из конфигурации и регистрирует их операции.
+// Операции, которые уже есть в реестре или принимают больше двух аргументов, пропускаются
+func (a *Application) loadPlugins() {
+	for _, path := range a.config.Plugins {
+		p, err := loadPlugin(path, time.Duration(a.config.PluginTimeout)*time.Millisecond)
+		if err != nil {
+			log.Println("failed to load plugin:", err)
+			continue
+		}
+		a.plugins = append(a.plugins, p)
+		for _, op := range p.operations {
+			// Операцию вызывают в выражении как функцию
+			if !calculation.IsIdentifier(op.Name) {
+				log.Printf("plugin %s: invalid operation name %q", path, op.Name)
+				continue
+			}
+			// Задача передает агенту не больше двух аргументов
+			if op.Arity < 1 || op.Arity > 2 {
+				log.Printf("plugin %s: operation %q has unsupported arity %d", path, op.Name, op.Arity)
+				continue
+			}
+			if op.CostMs < 0 {
+				log.Printf("plugin %s: operation %q has negative cost %dms", path, op.Name, op.CostMs)
+				continue
+			}
+			name := op.Name
+			err := operation.Add(operation.Operation{
+				Name:  name,
+				Arity: op.Arity,
+				Func:  func(args ...float64) (float64, error) { return p.call(name, args) },
+				Cost:  time.Duration(op.CostMs) * time.Millisecond,
+			})
+			if err != nil {
+				log.Printf("plugin %s: %v", path, err)
+				continue
+			}
+			a.pluginOperations = append(a.pluginOperations, &pb.OperationSpec{Name: name, Arity: int32(op.Arity), CostMs: op.CostMs})
+			a.config.Operations = append(a.config.Operations, name)
+		}
+	}
+}
+
+// closePlugins останавливает плагины и удаляет их операции из реестра
+func (a *Application) closePlugins() {
+	for _, op := range a.pluginOperations {
+		operation.Remove(op.Name)
+	}
+	for _, p := range a.plugins {
+		p.close()
+	}
+}
@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+// Переменная окружения, в которой тестовый бинарный файл запускается как плагин
+const testPluginEnv = "GO_CALC_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testPluginEnv) == "1" {
+		runTestPlugin()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runTestPlugin реализует протокол плагина с операциями для тестов
+func runTestPlugin() {
+	out := json.NewEncoder(os.Stdout)
+	out.Encode(pluginHandshake{Operations: []pluginOperation{
+		{Name: "hypot", Arity: 2, CostMs: 10},
+		{Name: "root", Arity: 1},
+		{Name: "hang", Arity: 1},
+		{Name: "crash", Arity: 1},
+		{Name: "triple", Arity: 3},
+		{Name: "1+1", Arity: 1},
+	}})
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		var request pluginRequest
+		if err := json.Unmarshal(in.Bytes(), &request); err != nil {
+			continue
+		}
+		response := pluginResponse{ID: request.ID}
+		switch request.Op {
+		case "hypot":
+			response.Result = math.Hypot(request.Args[0], request.Args[1])
+		case "root":
+			if request.Args[0] < 0 {
+				response.Error = "square root of a negative number"
+				response.Code = string(operation.DomainError)
+			} else {
+				response.Result = math.Sqrt(request.Args[0])
+			}
+		case "hang":
+			continue
+		case "crash":
+			os.Exit(1)
+		}
+		out.Encode(response)
+	}
+}
+
+// newTestPlugin запускает тестовый бинарный файл как плагин
+func newTestPlugin(t *testing.T, timeout time.Duration) *plugin {
+	t.Helper()
+	t.Setenv(testPluginEnv, "1")
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadPlugin(executable, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.close)
+	return p
+}
+
+// waitPlugin ждет, пока перезапущенный плагин снова начнет отвечать
+func waitPlugin(t *testing.T, p *plugin) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if result, err := p.call("hypot", []float64{3, 4}); err == nil && result == 5 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("Expected plugin to be restarted, but it does not respond")
+}
+
+func TestPluginCall(t *testing.T) {
+	p := newTestPlugin(t, time.Second)
+
+	if len(p.operations) != 6 {
+		t.Fatalf("Expected 6 operations from handshake, but got %d", len(p.operations))
+	}
+	result, err := p.call("hypot", []float64{3, 4})
+	if err != nil || result != 5 {
+		t.Fatalf("Expected 5, but got %v (%v)", result, err)
+	}
+
+	_, err = p.call("root", []float64{-1})
+	var opErr *operation.Error
+	if !errors.As(err, &opErr) || opErr.Code != operation.DomainError {
+		t.Fatalf("Expected domain error, but got %v", err)
+	}
+}
+
+func TestPluginTimeout(t *testing.T) {
+	p := newTestPlugin(t, 200*time.Millisecond)
+
+	start := time.Now()
+	_, err := p.call("hang", []float64{1})
+	if !errors.Is(err, operation.ErrTemporary) {
+		t.Fatalf("Expected temporary error on timeout, but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected call to time out after 200ms, but it took %v", elapsed)
+	}
+	// Зависший плагин перезапускается
+	waitPlugin(t, p)
+}
+
+func TestPluginRestart(t *testing.T) {
+	p := newTestPlugin(t, time.Second)
+
+	if _, err := p.call("crash", []float64{1}); !errors.Is(err, operation.ErrTemporary) {
+		t.Fatalf("Expected temporary error when plugin exits, but got %v", err)
+	}
+	waitPlugin(t, p)
+}
+
+func TestPluginOperations(t *testing.T) {
+	t.Setenv(testPluginEnv, "1")
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := ConfigFromEnv()
+	config.Plugins = []string{executable}
+	a := NewWithConfig(config)
+	a.loadPlugins()
+	defer a.closePlugins()
+
+	// Операции с тремя аргументами задача передать не может, а операцию с недопустимым именем нельзя вызвать в выражении
+	if len(a.pluginOperations) != 4 {
+		t.Fatalf("Expected 4 plugin operations to be registered, but got %d", len(a.pluginOperations))
+	}
+	if _, ok := operation.Lookup("triple"); ok {
+		t.Fatal("Operation with arity 3 must not be registered")
+	}
+	if _, ok := operation.Lookup("1+1"); ok {
+		t.Fatal("Operation with invalid name must not be registered")
+	}
+
+	tests := []struct {
+		task         *pb.Task
+		expected     float32
+		expectedCode pb.ErrorCode
+	}{
+		{&pb.Task{Arg1: 6, Arg2: 8, Operation: "hypot"}, 10, pb.ErrorCode_ERROR_CODE_UNSPECIFIED},
+		{&pb.Task{Arg1: 9, Operation: "root"}, 3, pb.ErrorCode_ERROR_CODE_UNSPECIFIED},
+		{&pb.Task{Arg1: -9, Operation: "root"}, 0, pb.ErrorCode_ERROR_CODE_DOMAIN_ERROR},
+	}
+	for _, test := range tests {
+		result := compute(context.Background(), test.task)
+		if result.Result != test.expected || result.ErrorCode != test.expectedCode {
+			t.Errorf("For %s(%v, %v) expected %v with code %v, but got %v with code %v (%q)", test.task.Operation,
+				test.task.Arg1, test.task.Arg2, test.expected, test.expectedCode, result.Result, result.ErrorCode, result.Error)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+	"google.golang.org/grpc/connectivity"
+)
+
+// metrics - счетчики агента, которые отдает /metrics
+type metrics struct {
+	mu        sync.Mutex
+	completed map[string]int64    // Успешно вычисленные задачи по операциям
+	errors    map[[2]string]int64 // Ошибки по операциям и типам ошибок
+	cancelled int64               // Задачи, отмененные оркестратором
+	polls     int64               // Запросы задач
+	pollTime  time.Duration       // Суммарное время запросов задач
+	lastPoll  time.Duration       // Время последнего запроса задач
+	faults    map[string]int64    // Внесенные сбои по типам
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		completed: make(map[string]int64),
+		errors:    make(map[[2]string]int64),
+		faults:    make(map[string]int64),
+	}
+}
+
+// record учитывает результат задачи; nil означает, что задача отменена
+func (m *metrics) record(op string, result *pb.PostResultRequest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case result == nil:
+		m.cancelled++
+	case result.Retryable:
+		m.errors[[2]string{op, "temporary"}]++
+	case result.Error != "":
+		code := strings.ToLower(strings.TrimPrefix(result.ErrorCode.String(), "ERROR_CODE_"))
+		m.errors[[2]string{op, code}]++
+	default:
+		m.completed[op]++
+	}
+}
+
+// fault учитывает внесенный сбой
+func (m *metrics) fault(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.faults[name]++
+}
+
+// poll учитывает время запроса задач
+func (m *metrics) poll(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.polls++
+	m.pollTime += d
+	m.lastPoll = d
+}
+
+// startStatus запускает HTTP сервер с состоянием агента, если задан StatusAddr
+func (a *Application) startStatus() error {
+	if a.config.StatusAddr == "" {
+		return nil
+	}
+	lis, err := net.Listen("tcp", a.config.StatusAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for status requests: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", a.healthz)
+	mux.HandleFunc("/metrics", a.metricsHandler)
+	mux.HandleFunc("/debug/tasks", a.debugTasks)
+	a.status = &http.Server{Handler: mux}
+	go func() {
+		if err := a.status.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("status server:", err)
+		}
+	}()
+	return nil
+}
+
+// connectionState возвращает состояние соединения с текущим оркестратором
+func (a *Application) connectionState() connectivity.State {
+	o := a.active()
+	if o == nil {
+		return connectivity.Idle
+	}
+	return o.conn.GetState()
+}
+
+// healthz отвечает 200, пока агент работает и не потерял оркестратор, и 503 в остальных случаях
+func (a *Application) healthz(w http.ResponseWriter, r *http.Request) {
+	state := a.connectionState()
+	status, code := "ok", http.StatusOK
+	switch {
+	case a.stopping():
+		status, code = "stopping", http.StatusServiceUnavailable
+	case state == connectivity.TransientFailure || state == connectivity.Shutdown:
+		status, code = "disconnected", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"status": status, "connection": state.String()})
+}
+
+// metricsHandler отдает метрики агента в текстовом формате Prometheus
+func (a *Application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	inFlight := len(a.running)
+	a.mu.Unlock()
+
+	m := a.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP gocalc_agent_tasks_completed_total Tasks computed successfully.")
+	fmt.Fprintln(w, "# TYPE gocalc_agent_tasks_completed_total counter")
+	ops := make([]string, 0, len(m.completed))
+	for op := range m.completed {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		fmt.Fprintf(w, "gocalc_agent_tasks_completed_total{operation=%q} %d\n", op, m.completed[op])
+	}
+
+	fmt.Fprintln(w, "# HELP gocalc_agent_task_errors_total Tasks that failed, by error code.")
+	fmt.Fprintln(w, "# TYPE gocalc_agent_task_errors_total counter")
+	keys := make([][2]string, 0, len(m.errors))
+	for key := range m.errors {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "gocalc_agent_task_errors_total{operation=%q,code=%q} %d\n", key[0], key[1], m.errors[key])
+	}
+
+	fmt.Fprintln(w, "# HELP gocalc_agent_tasks_cancelled_total Tasks cancelled by the orchestrator.")
+	fmt.Fprintln(w, "# TYPE gocalc_agent_tasks_cancelled_total counter")
+	fmt.Fprintf(w, "gocalc_agent_tasks_cancelled_total %d\n", m.cancelled)
+
+	fmt.Fprintln(w, "# HELP gocalc_agent_tasks_in_flight Tasks being computed.")
+	fmt.Fprintln(w, "# TYPE gocalc_agent_tasks_in_flight gauge")
+	fmt.Fprintf(w, "gocalc_agent_tasks_in_flight %d\n", inFlight)
+
+	fmt.Fprintln(w, "# HELP gocalc_agent_workers Worker goroutines.")
+	fmt.Fprintln(w, "# TYPE gocalc_agent_workers gauge")
+	fmt.Fprintf(w, "gocalc_agent_workers %d\n", a.Workers())
+
+	fmt.Fprintln(w, "# HELP gocalc_agent_poll_duration_seconds Time spent requesting tasks.")
+	fmt.Fprintln(w, "# TYPE gocalc_agent_poll_duration_seconds summary")
+	fmt.Fprintf(w, "gocalc_agent_poll_duration_seconds_sum %g\n", m.pollTime.Seconds())
+	fmt.Fprintf(w, "gocalc_agent_poll_duration_seconds_count %d\n", m.polls)
+	fmt.Fprintln(w, "# HELP gocalc_agent_last_poll_duration_seconds Duration of the last task request.")
+	fmt.Fprintln(w, "# TYPE gocalc_agent_last_poll_duration_seconds gauge")
+	fmt.Fprintf(w, "gocalc_agent_last_poll_duration_seconds %g\n", m.lastPoll.Seconds())
+
+	if len(m.faults) > 0 {
+		fmt.Fprintln(w, "# HELP gocalc_agent_faults_injected_total Faults injected into results, by type.")
+		fmt.Fprintln(w, "# TYPE gocalc_agent_faults_injected_total counter")
+		names := make([]string, 0, len(m.faults))
+		for name := range m.faults {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "gocalc_agent_faults_injected_total{fault=%q} %d\n", name, m.faults[name])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP gocalc_agent_orchestrator_healthy Orchestrator passed the last health check, 1 - yes.")
+	fmt.Fprintln(w, "# TYPE gocalc_agent_orchestrator_healthy gauge")
+	for _, o := range a.orchestrators {
+		value := 0
+		if o.healthy.Load() {
+			value = 1
+		}
+		fmt.Fprintf(w, "gocalc_agent_orchestrator_healthy{addr=%q} %d\n", o.addr, value)
+	}
+
+	fmt.Fprintln(w, "# HELP gocalc_agent_connection_state Connection to the current orchestrator, 1 for the current state.")
+	fmt.Fprintln(w, "# TYPE gocalc_agent_connection_state gauge")
+	current := a.connectionState()
+	for _, state := range []connectivity.State{connectivity.Idle, connectivity.Connecting, connectivity.Ready, connectivity.TransientFailure, connectivity.Shutdown} {
+		value := 0
+		if state == current {
+			value = 1
+		}
+		fmt.Fprintf(w, "gocalc_agent_connection_state{state=%q} %d\n", state.String(), value)
+	}
+}
+
+// debugTask - задача в ответе /debug/tasks
+type debugTask struct {
+	ID            int64     `json:"id"`
+	Orchestrator  string    `json:"orchestrator"` // Адрес оркестратора, выдавшего задачу
+	Operation     string    `json:"operation"`
+	Arg1          float32   `json:"arg1"`
+	Arg2          float32   `json:"arg2"`
+	OperationTime int64     `json:"operation_time"` // Время операции, назначенное оркестратором, в миллисекундах
+	StartedAt     time.Time `json:"started_at"`
+	RunningMs     int64     `json:"running_ms"`
+	Overdue       bool      `json:"overdue"` // Задача вычисляется дольше назначенного времени
+}
+
+// debugTasks возвращает задачи, которые агент вычисляет сейчас
+func (a *Application) debugTasks(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	a.mu.Lock()
+	tasks := make([]debugTask, 0, len(a.running))
+	for _, running := range a.running {
+		elapsed := now.Sub(running.started)
+		tasks = append(tasks, debugTask{
+			ID:            running.task.Id,
+			Orchestrator:  running.source.addr,
+			Operation:     running.task.Operation,
+			Arg1:          running.task.Arg1,
+			Arg2:          running.task.Arg2,
+			OperationTime: running.task.OperationTime,
+			StartedAt:     running.started,
+			RunningMs:     elapsed.Milliseconds(),
+			Overdue:       elapsed > time.Duration(running.task.OperationTime)*time.Millisecond,
+		})
+	}
+	a.mu.Unlock()
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"tasks": tasks})
+}
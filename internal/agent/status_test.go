@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestStatus(t *testing.T) {
+	f := newFakeServer()
+	s, addr := serve(t, "127.0.0.1:0", f)
+	defer s.Stop()
+
+	a := newTestAgent(addr)
+	if err := a.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// Долгая задача прерывается и возвращается оркестратору
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		a.Shutdown(ctx)
+	}()
+
+	f.add(&pb.Task{Id: 1, Arg1: 1, Arg2: 2, Operation: "+"})
+	f.add(&pb.Task{Id: 2, Arg1: 1, Arg2: 0, Operation: "/"})
+	waitResult(t, f, 1, time.Second)
+	waitResult(t, f, 2, time.Second)
+	// Задача, которая вычисляется очень долго
+	f.add(&pb.Task{Id: 3, Arg1: 1, Arg2: 2, Operation: "*", OperationTime: 60000})
+
+	deadline := time.Now().Add(time.Second)
+	var tasks struct {
+		Tasks []debugTask `json:"tasks"`
+	}
+	for len(tasks.Tasks) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		w := httptest.NewRecorder()
+		a.debugTasks(w, httptest.NewRequest(http.MethodGet, "/debug/tasks", nil))
+		if err := json.NewDecoder(w.Body).Decode(&tasks); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tasks.Tasks) != 1 || tasks.Tasks[0].ID != 3 || tasks.Tasks[0].OperationTime != 60000 || tasks.Tasks[0].Overdue {
+		t.Fatalf("Expected task 3 in /debug/tasks, but got %+v", tasks.Tasks)
+	}
+
+	w := httptest.NewRecorder()
+	a.metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`gocalc_agent_tasks_completed_total{operation="+"} 1`,
+		`gocalc_agent_task_errors_total{operation="/",code="division_by_zero"} 1`,
+		`gocalc_agent_tasks_in_flight 1`,
+		`gocalc_agent_workers 2`,
+		`gocalc_agent_connection_state{state="READY"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %q in /metrics, but got:\n%s", line, body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	a.healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to return 200, but got %d: %s", w.Code, w.Body)
+	}
+}
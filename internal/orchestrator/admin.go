@@ -0,0 +1,140 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// checkAdmin проверяет, что запрос отправлен пользователем из ADMIN_LOGINS.
+// При отказе отправляет ошибку и возвращает false
+func (a *Application) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	user, err := getUserFromToken(r.Header.Get("Authorization"))
+	if err != nil {
+		sendError(w, 401)
+		return false
+	}
+	for _, login := range a.config.AdminLogins {
+		if login == user.Login {
+			return true
+		}
+	}
+	sendError(w, 403)
+	return false
+}
+
+// GetDeadTasks возвращает задачи, исчерпавшие все попытки вычисления
+func (a *Application) GetDeadTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, 405)
+		return
+	}
+	if !a.checkAdmin(w, r) {
+		return
+	}
+
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	defer db.Close()
+
+	tasks, err := selectTasks(context.Background(), db)
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	response := struct {
+		Tasks []Task `json:"tasks"`
+	}{Tasks: []Task{}}
+	for _, task := range tasks {
+		if task.Status == statusDead {
+			response.Tasks = append(response.Tasks, task)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RequeueDeadTask возвращает задачу из dead letter в очередь со сброшенным счетчиком попыток
+func (a *Application) RequeueDeadTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, 405)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/dead-letter/")
+	idStr, ok := strings.CutSuffix(path, "/requeue")
+	if !ok {
+		sendError(w, 404)
+		return
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		sendError(w, 404)
+		return
+	}
+	if !a.checkAdmin(w, r) {
+		return
+	}
+
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	defer db.Close()
+
+	err = a.server.requeueDeadTask(context.Background(), db, id)
+	switch {
+	case err == sql.ErrNoRows:
+		sendError(w, 404)
+		return
+	case err == errNotDead:
+		sendError(w, 409)
+		return
+	case err != nil:
+		sendError(w, 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "OK"})
+}
+
+// GetMismatches возвращает результаты агентов, разошедшиеся с результатами других агентов при проверке
+func (a *Application) GetMismatches(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, 405)
+		return
+	}
+	if !a.checkAdmin(w, r) {
+		return
+	}
+
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	defer db.Close()
+
+	mismatches, err := selectMismatches(context.Background(), db)
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	if mismatches == nil {
+		mismatches = []Mismatch{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"mismatches": mismatches})
+}
@@ -0,0 +1,116 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestDeadLetter(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "alice")
+	newTestUser(t, db, "admin")
+	dead := newTestExpression(t, db, userID, 1)
+	alive := newTestExpression(t, db, userID, 1)
+
+	config := ConfigFromEnv()
+	config.AdminLogins = []string{"admin"}
+	config.MaxAttempts = 1
+	config.TaskLease = -time.Second // Аренда истекает сразу после выдачи
+	s := NewServer(config)
+	a := &Application{config: config, server: s}
+
+	tasks, err := s.claimTasks(ctx, db, &pb.AgentInfo{Id: "crashed"}, 1)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("Expected task to be claimed, but got %v (%v)", tasks, err)
+	}
+	if err := s.checkLeases(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deadID := int(tasks[0].Id)
+	if expression, err := selectExpressionByID(ctx, db, dead); err != nil || expression.Status != statusDead {
+		t.Fatalf("Expected expression with dead task to be %q, but got %q (%v)", statusDead, expression.Status, err)
+	}
+	aliveTasks, err := selectTasks(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var aliveID int
+	for _, task := range aliveTasks {
+		if task.ExpressionID == alive {
+			aliveID = task.ID
+		}
+	}
+
+	request := func(handler http.HandlerFunc, method, path, login string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, path, nil)
+		if login != "" {
+			r.Header.Set("Authorization", newTestToken(t, login))
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	requeuePath := func(id int) string {
+		return "/api/v1/admin/dead-letter/" + strconv.Itoa(id) + "/requeue"
+	}
+
+	for _, tc := range []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		path    string
+		login   string
+		code    int
+	}{
+		{"list without token", a.GetDeadTasks, http.MethodGet, "/api/v1/admin/dead-letter", "", http.StatusUnauthorized},
+		{"list by non-admin", a.GetDeadTasks, http.MethodGet, "/api/v1/admin/dead-letter", "alice", http.StatusForbidden},
+		{"requeue by non-admin", a.RequeueDeadTask, http.MethodPost, requeuePath(deadID), "alice", http.StatusForbidden},
+		{"requeue of unknown task", a.RequeueDeadTask, http.MethodPost, requeuePath(1000), "admin", http.StatusNotFound},
+		{"requeue of task that is not dead", a.RequeueDeadTask, http.MethodPost, requeuePath(aliveID), "admin", http.StatusConflict},
+	} {
+		if w := request(tc.handler, tc.method, tc.path, tc.login); w.Code != tc.code {
+			t.Errorf("%s: expected %d, but got %d", tc.name, tc.code, w.Code)
+		}
+	}
+
+	w := request(a.GetDeadTasks, http.MethodGet, "/api/v1/admin/dead-letter", "admin")
+	var response struct {
+		Tasks []Task `json:"tasks"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Tasks) != 1 || response.Tasks[0].ID != deadID {
+		t.Fatalf("Expected dead task %d to be listed, but got %+v", deadID, response.Tasks)
+	}
+
+	if w := request(a.RequeueDeadTask, http.MethodPost, requeuePath(deadID), "admin"); w.Code != http.StatusOK {
+		t.Fatalf("Expected requeue to succeed, but got %d", w.Code)
+	}
+	task, err := selectTaskByID(ctx, db, deadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != "waiting" || task.Attempts != 0 {
+		t.Errorf("Expected requeued task to be waiting with no attempts, but got %+v", task)
+	}
+	expression, err := selectExpressionByID(ctx, db, dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "waiting" {
+		t.Errorf("Expected expression to wait again after requeue, but got %q", expression.Status)
+	}
+	if w := request(a.RequeueDeadTask, http.MethodPost, requeuePath(deadID), "admin"); w.Code != http.StatusConflict {
+		t.Errorf("Expected second requeue to conflict, but got %d", w.Code)
+	}
+}
@@ -0,0 +1,201 @@
+package orchestrator
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+// Ограничения операций плагинов, о которых сообщают агенты
+const (
+	maxRemoteOperations = 64               // Операций у одного агента
+	maxRemoteArity      = 2                // Задача передает агенту не больше двух аргументов
+	maxRemoteCost       = 10 * time.Minute // Время выполнения операции
+)
+
+// Состояние агента, известное оркестратору
+type agentState struct {
+	info     *pb.AgentInfo
+	lastSeen time.Time // Время последнего обращения агента
+	health   agentHealth
+
+	durations  map[string][]time.Duration   // Последние времена вычисления задач по операциям
+	operations map[string]*pb.OperationSpec // Проверенные операции плагинов агента
+}
+
+// agentRegistry хранит сведения об агентах, обращавшихся за задачами
+type agentRegistry struct {
+	mu     sync.Mutex
+	agents map[string]*agentState
+
+	maxFailureRate float64       // Доля сбоев среди последних задач агента, после которой он попадает в карантин
+	quarantine     time.Duration // Время, на которое агент перестает получать задачи
+}
+
+func newAgentRegistry() *agentRegistry {
+	return &agentRegistry{agents: make(map[string]*agentState), maxFailureRate: 1}
+}
+
+// seen запоминает агента и время его обращения, а также операции его плагинов. Состояние агента
+// создается только здесь: исходы и времена задач агента, еще не обращавшегося к оркестратору, например
+// после перезапуска оркестратора, не учитываются. Агент без идентификатора не запоминается
+func (r *agentRegistry) seen(info *pb.AgentInfo) {
+	if info.GetId() == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[info.GetId()]
+	if !ok {
+		agent = &agentState{info: info}
+		r.agents[info.GetId()] = agent
+	}
+	// Операции проверяются при первом обращении агента и при изменении их списка
+	if agent.lastSeen.IsZero() || !sameOperationSpecs(agent.info.GetCustomOperations(), info.GetCustomOperations()) {
+		agent.operations = remoteOperations(info.GetId(), info.GetCustomOperations())
+	}
+	agent.info = info
+	agent.lastSeen = time.Now()
+}
+
+// sameOperationSpecs проверяет, совпадают ли списки операций плагинов
+func sameOperationSpecs(a, b []*pb.OperationSpec) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].GetName() != b[i].GetName() || a[i].GetArity() != b[i].GetArity() || a[i].GetCostMs() != b[i].GetCostMs() {
+			return false
+		}
+	}
+	return true
+}
+
+// remoteOperations отбирает операции плагинов агента, которые можно принять в выражениях
+func remoteOperations(agentID string, specs []*pb.OperationSpec) map[string]*pb.OperationSpec {
+	operations := make(map[string]*pb.OperationSpec)
+	for _, spec := range specs {
+		if len(operations) == maxRemoteOperations {
+			log.Printf("agent %s: ignored operations above %d", agentID, maxRemoteOperations)
+			break
+		}
+		if err := checkRemoteOperation(spec); err != nil {
+			log.Printf("agent %s: operation ignored: %v", agentID, err)
+			continue
+		}
+		operations[spec.GetName()] = spec
+	}
+	return operations
+}
+
+// checkRemoteOperation проверяет, что операцию плагина можно вызвать в выражении как функцию
+// и она не подменяет операцию из реестра оркестратора
+func checkRemoteOperation(spec *pb.OperationSpec) error {
+	name := spec.GetName()
+	if !calculation.IsIdentifier(name) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	if _, ok := operation.Lookup(name); ok {
+		return fmt.Errorf("operation %q is already registered", name)
+	}
+	if spec.GetArity() < 1 || spec.GetArity() > maxRemoteArity {
+		return fmt.Errorf("operation %q has unsupported arity %d", name, spec.GetArity())
+	}
+	if spec.GetCostMs() < 0 || spec.GetCostMs() > maxRemoteCost.Milliseconds() {
+		return fmt.Errorf("operation %q has cost %dms out of range", name, spec.GetCostMs())
+	}
+	return nil
+}
+
+// remoteOperation возвращает операцию плагина, о которой сообщает агент, обращавшийся
+// не позднее timeout назад. Если таких агентов несколько, берется описание последнего из них
+func (r *agentRegistry) remoteOperation(name string, timeout time.Duration) (*pb.OperationSpec, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var spec *pb.OperationSpec
+	var lastSeen time.Time
+	for _, agent := range r.agents {
+		if now.Sub(agent.lastSeen) > timeout || !agent.lastSeen.After(lastSeen) {
+			continue
+		}
+		if s, ok := agent.operations[name]; ok {
+			spec, lastSeen = s, agent.lastSeen
+		}
+	}
+	return spec, spec != nil
+}
+
+// operationTime возвращает время выполнения операции, а для операции плагина - время,
+// о котором сообщил агент
+func (s *Server) operationTime(name string) time.Duration {
+	if !isRemoteOperation(name) {
+		return s.config.operationTime(name)
+	}
+	if spec, ok := s.agents.remoteOperation(name, s.config.AgentTimeout); ok {
+		return time.Duration(spec.GetCostMs()) * time.Millisecond
+	}
+	return 0
+}
+
+// isRemoteOperation проверяет, вычисляется ли операция узла только агентами с плагинами.
+// Такие операции не добавляются в реестр, поэтому это любая операция, которой в нем нет
+func isRemoteOperation(name string) bool {
+	_, ok := operation.Lookup(name)
+	return !ok
+}
+
+// available возвращает агентов, которые обращались к оркестратору не позднее timeout назад
+// и не находятся в карантине
+func (r *agentRegistry) available(timeout time.Duration) []*pb.AgentInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var infos []*pb.AgentInfo
+	for _, agent := range r.agents {
+		if now.Sub(agent.lastSeen) <= timeout && !agent.health.quarantined(now) {
+			infos = append(infos, agent.info)
+		}
+	}
+	return infos
+}
+
+// supportsOperation проверяет, объявил ли агент поддержку операции.
+// Агент без списка операций считается поддерживающим все операции
+func supportsOperation(agent *pb.AgentInfo, operation string) bool {
+	operations := agent.GetOperations()
+	if len(operations) == 0 {
+		return true
+	}
+	for _, op := range operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsOperands проверяет, укладываются ли аргументы в ограничение агента
+func acceptsOperands(agent *pb.AgentInfo, args ...float64) bool {
+	limit := agent.GetMaxOperand()
+	if limit <= 0 {
+		return true
+	}
+	for _, arg := range args {
+		if math.Abs(arg) > limit {
+			return false
+		}
+	}
+	return true
+}
+
+// capable проверяет, может ли агент выполнить операцию с данными аргументами
+func capable(agent *pb.AgentInfo, operation string, args ...float64) bool {
+	return supportsOperation(agent, operation) && acceptsOperands(agent, args...)
+}
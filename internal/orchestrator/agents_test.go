@@ -0,0 +1,119 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestRemoteOperations(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+	s := NewServer(ConfigFromEnv())
+	if err := defineTestFunction(t, s, db, userID, "rate(x) = x / 100"); err != nil {
+		t.Fatal(err)
+	}
+
+	// До появления агента функция неизвестна
+	if _, err := s.parseExpression(ctx, db, userID, "annuity(30, 0.05)"); err == nil {
+		t.Fatal("Expected unknown function before agent with plugin connects")
+	}
+
+	s.agents.seen(&pb.AgentInfo{
+		Id:         "actuarial",
+		Operations: []string{"annuity", "rate"},
+		CustomOperations: []*pb.OperationSpec{
+			{Name: "annuity", Arity: 2, CostMs: 50},
+			{Name: "rate", Arity: 1},
+			{Name: "+", Arity: 1},                 // Операция из реестра
+			{Name: "a b", Arity: 1},               // Не имя функции
+			{Name: "wide", Arity: 3},              // Задача передает не больше двух аргументов
+			{Name: "slow", Arity: 1, CostMs: 1e9}, // Слишком долгая операция
+			{Name: "early", Arity: 1, CostMs: -1}, // Отрицательное время
+		},
+	})
+
+	node, err := s.parseExpression(ctx, db, userID, "annuity(30, 0.05) * 2")
+	if err != nil || node.Args[0].Op != "annuity" {
+		t.Fatalf("Expected operation of agent plugin to be accepted, but got %v (%v)", node, err)
+	}
+	if _, ok := operation.Lookup("annuity"); ok {
+		t.Fatal("Expected operation of agent plugin not to be added to the registry")
+	}
+	if d := s.operationTime("annuity"); d.Milliseconds() != 50 {
+		t.Fatalf("Expected operation time 50ms from agent, but got %v", d)
+	}
+	if op, _ := operation.Lookup("+"); op.Arity != 2 {
+		t.Fatalf("Expected built-in + to keep arity 2, but got %d", op.Arity)
+	}
+	for _, expression := range []string{"annuity(30)", "wide(1, 2, 3)", "slow(1)", "early(1)"} {
+		if _, err := s.parseExpression(ctx, db, userID, expression); err == nil {
+			t.Errorf("Expected %q to be rejected", expression)
+		}
+	}
+
+	// Функция пользователя не подменяется операцией плагина, а новая функция не может занять ее имя
+	node, err = s.parseExpression(ctx, db, userID, "rate(50)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := calculation.Eval(node); err != nil || result != 0.5 {
+		t.Errorf("Expected user function rate to give 0.5, but got %v (%v)", result, err)
+	}
+	if err := defineTestFunction(t, s, db, userID, "annuity(x, y) = x * y"); err == nil {
+		t.Error("Expected user function with the name of agent operation to be rejected")
+	}
+
+	// Агент отключился, и операция больше не принимается
+	s.agents.mu.Lock()
+	s.agents.agents["actuarial"].lastSeen = time.Now().Add(-2 * s.config.AgentTimeout)
+	s.agents.mu.Unlock()
+	if _, err := s.parseExpression(ctx, db, userID, "annuity(30, 0.05)"); err == nil {
+		t.Error("Expected operation to be unknown after agent is gone")
+	}
+	if err := defineTestFunction(t, s, db, userID, "annuity(x, y) = x * y"); err != nil {
+		t.Errorf("Expected name to be free after agent is gone, but got %v", err)
+	}
+}
+
+func TestCapableAgents(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+	s := NewServer(ConfigFromEnv())
+
+	submitTestExpression(t, db, userID, "2*3")
+	submitTestExpression(t, db, userID, "1000+1")
+
+	// Агент без умножения получает только задачу сложения, но ее аргумент больше его ограничения
+	adder := &pb.AgentInfo{Id: "adder", Operations: []string{"+"}, MaxOperand: 100}
+	tasks, err := s.claimTasks(ctx, db, adder, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 0 {
+		t.Fatalf("Expected no tasks for agent without * and with max operand 100, but got %v", tasks)
+	}
+
+	adder.MaxOperand = 1000
+	tasks, err = s.claimTasks(ctx, db, adder, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Operation != "+" {
+		t.Fatalf("Expected only the + task, but got %v", tasks)
+	}
+
+	tasks, err = s.claimTasks(ctx, db, &pb.AgentInfo{Id: "multiplier", Operations: []string{"*"}}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].Operation != "*" {
+		t.Fatalf("Expected only the * task, but got %v", tasks)
+	}
+}
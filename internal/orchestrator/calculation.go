@@ -2,185 +2,145 @@ package orchestrator
 
 import (
 	"context"
-	"errors"
-	"regexp"
+	"encoding/json"
+	"fmt"
 	"strconv"
-	"strings"
-
-	"database/sql"
 
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func contains(ids []int, id int) bool {
-	for _, existingId := range ids {
-		if existingId == id {
-			return true
-		}
+// insertTasks создает задачи для операций дерева выражения, начиная с листьев.
+// Аргумент задачи - число или ссылка id<N> на задачу, результат которой нужен
+func insertTasks(ctx context.Context, db querier, expressionID int, node *calculation.Node) (string, error) {
+	if node.IsNumber() {
+		return strconv.FormatFloat(node.Value, 'f', -1, 64), nil
 	}
-	return false
-}
-
-// indexOf ищет индекс первого вхождения символов из values в срезе строк slice
-func indexOf(slice []string, values string) int {
-	for i, v := range slice {
-		if strings.Contains(values, v) {
-			return i
+	if len(node.Args) > 2 {
+		return "", fmt.Errorf("operation %q with %d arguments is not supported", node.Op, len(node.Args))
+	}
+	// Неиспользуемый второй аргумент операции с одним аргументом
+	args := []string{"0", "0"}
+	for i, arg := range node.Args {
+		ref, err := insertTasks(ctx, db, expressionID, arg)
+		if err != nil {
+			return "", err
 		}
+		args[i] = ref
 	}
-	return -1 // Возвращает -1, если символы не найдены.
+	id, err := insertTask(ctx, db, Task{ExpressionID: expressionID, Arg1: args[0], Arg2: args[1], Operation: node.Op, Status: "waiting", Result: 0.0})
+	if err != nil {
+		return "", err
+	}
+	return "id" + strconv.Itoa(id), nil
 }
 
-// isValidInNumber проверяет, является ли символ допустимым для числа num
-func isValidInNumber(num string, c rune) bool {
-	validChars := "0123456789" // Допустимые символы для чисел.
-	if c == 'i' {
-		return len(num) == 0
-	}
-	if c == 'd' {
-		return num[0] == 'i' && len(num) == 1
-	}
-	if c == '.' {
-		// Точка может быть в числе только один раз и не в начале
-		return !strings.Contains(num, ".") && len(num) != 0
-	}
-	if c == '-' {
-		// Минус может быть только в начале
-		return len(num) == 0
+// insertTaskChunks разбивает дерево выражения на задачи, каждая из которых вычисляет до granularity
+// операций. Подвыражение из нескольких операций записывается в задачу целиком, а ссылки на результаты
+// других задач заменяются в нем параметрами id<N>. При granularity не больше 1 работает как insertTasks
+func insertTaskChunks(ctx context.Context, db querier, expressionID int, node *calculation.Node, granularity int) (string, error) {
+	if granularity <= 1 {
+		return insertTasks(ctx, db, expressionID, node)
 	}
-	if c == '+' {
-		// Плюс может быть только в начале
-		return len(num) == 0
+	c := &chunker{ctx: ctx, db: db, expressionID: expressionID, granularity: granularity}
+	tree, _, err := c.chunk(node)
+	if err != nil {
+		return "", err
 	}
-	// Проверка на допустимые цифры.
-	return strings.Contains(validChars, string(c))
+	return c.insert(tree)
 }
 
-// isValidOperation проверяет, является ли символ c допустимой операцией
-func isValidOperation(c rune) bool {
-	validChars := "+-*/" // Допустимые операции
-	return strings.Contains(validChars, string(c))
+// chunker собирает операции дерева выражения в задачи снизу вверх
+type chunker struct {
+	ctx          context.Context
+	db           querier
+	expressionID int
+	granularity  int
 }
 
-// Calc выполняет вычисление математического выражения, переданного в виде строки
-func Calc(expression string, id int) (string, error) {
-	db, err := sql.Open("sqlite3", "store.db")
-	if err != nil {
-		panic(err)
+// chunk возвращает подвыражение узла, еще не записанное в задачу, и количество операций в нем.
+// Если операций становится больше granularity, самые большие аргументы записываются отдельными задачами
+func (c *chunker) chunk(node *calculation.Node) (*calculation.Node, int, error) {
+	if node.IsNumber() {
+		return node, 0, nil
 	}
-	defer db.Close()
-
-	// Удаляем пробелы из выражения
-	expression = strings.ReplaceAll(expression, " ", "")
-
-	// Решаем все, что в скобках
-	expression, err = evaluate(expression, id)
-	if err != nil {
-		return "", err
+	if len(node.Args) > 2 {
+		return nil, 0, fmt.Errorf("operation %q with %d arguments is not supported", node.Op, len(node.Args))
 	}
-
-	// Инициализация среза для хранения чисел и операций
-	s := []string{""}
-
-	// Проходим по каждому символу в выражении
-	for _, i := range expression {
-		if isValidInNumber(s[len(s)-1], i) {
-			// Если символ допустим для числа, добавляем его к текущему числу
-			s[len(s)-1] += string(i)
-		} else if isValidOperation(i) {
-			// Если символ является операцией, проверяем заканчивается ли число на точку
-			if s[len(s)-1][len(s[len(s)-1])-1] == '.' {
-				return "", errors.New("Expression is not valid")
-			}
-			// Если нет, добавляем символ операции в срез и инициализируем новое число
-			s = append(s, string(i))
-			s = append(s, "")
-		} else {
-			return "", errors.New("Expression is not valid") // Ошибка при недопустимом символе
+	args := make([]*calculation.Node, len(node.Args))
+	sizes := make([]int, len(node.Args))
+	total := 1
+	for i, arg := range node.Args {
+		tree, size, err := c.chunk(arg)
+		if err != nil {
+			return nil, 0, err
 		}
+		args[i], sizes[i] = tree, size
+		total += size
 	}
-
-	var ind, new_id int
-
-	// Выполняем вычисления до тех пор, пока не останется одно значение
-	for len(s) != 1 {
-		if ind = indexOf(s, "*/"); ind != -1 {
-			new_id, err = insertTask(context.Background(), db, Task{ExpressionID: id, Arg1: s[ind-1], Arg2: s[ind+1], Operation: s[ind], Status: "waiting", Result: 0.0})
-		} else if ind = indexOf(s, "+-"); ind != -1 {
-			new_id, err = insertTask(context.Background(), db, Task{ExpressionID: id, Arg1: s[ind-1], Arg2: s[ind+1], Operation: s[ind], Status: "waiting", Result: 0.0})
+	// Операции плагинов вычисляют не все агенты, поэтому они всегда выдаются отдельными задачами
+	remote := isRemoteOperation(node.Op)
+	for total > c.granularity || remote && total > 1 {
+		largest := 0
+		for i := range sizes {
+			if sizes[i] > sizes[largest] {
+				largest = i
+			}
 		}
+		ref, err := c.insert(args[largest])
 		if err != nil {
-			return "", err
+			return nil, 0, err
+		}
+		args[largest] = &calculation.Node{Var: ref}
+		total -= sizes[largest]
+		sizes[largest] = 0
+	}
+	tree := &calculation.Node{Op: node.Op, Args: args}
+	if remote {
+		ref, err := c.insert(tree)
+		if err != nil {
+			return nil, 0, err
 		}
-		// Обновляем срез и убираем использованные элементы
-		s[ind+1] = "id" + strconv.Itoa(new_id)
-		s = append(s[:ind-1], s[ind+1:]...)
+		return &calculation.Node{Var: ref}, 0, nil
 	}
-	// Возвращаем результат вычисления как float64
-	return s[0], nil
+	return tree, total, nil
 }
 
-// evaluate решает выражения в скобках
-func evaluate(expression string, id int) (string, error) {
-	re := regexp.MustCompile("[+-]{3,}")
-	if re.MatchString(expression) {
-		return "", errors.New("Expression is not valid")
-	}
-	s := []string{""} // Инициализация среза для хранения частей выражения
-	var n int         // Счетчик открывающих скобок
-	var v string
-	var err error
-
-	// Проходим по каждому символу в выражении
-	for _, i := range expression {
-		if i == '(' {
-			n++ // Увеличиваем счетчик открывающих скобок
-			if n == 1 {
-				s = append(s, "") // Добавляем новый элемент в срез при первой открывающей скобке
-			} else {
-				s[len(s)-1] += string(i) // Добавляем символ к текущему элементу
-			}
-		} else if i == ')' {
-			n-- // Уменьшаем счетчик закрывающих скобок
-			if n == 0 {
-				v, err = Calc(s[len(s)-1], id) // Вычисляем выражение внутри скобок
-				if err != nil {
-					return "", err
-				}
-				s[len(s)-1] = v   // Заменяем выражение на его результат
-				s = append(s, "") // Добавляем новый элемент для следующей части выражения
-			} else if n < 0 {
-				return "", errors.New("Expression is not valid") // Ошибка при наличии лишней закрывающей скобки
-			} else {
-				s[len(s)-1] += string(i) // Добавляем символ к текущему элементу
-			}
-		} else {
-			s[len(s)-1] += string(i) // Добавляем обычный символ к текущему элементу
+// insert записывает подвыражение в задачу и возвращает ссылку на нее. Одна операция над числами
+// и ссылками записывается обычной задачей
+func (c *chunker) insert(tree *calculation.Node) (string, error) {
+	if tree.IsNumber() {
+		return strconv.FormatFloat(tree.Value, 'f', -1, 64), nil
+	}
+	if tree.Var != "" {
+		return tree.Var, nil
+	}
+	task := Task{ExpressionID: c.expressionID, Arg1: "0", Arg2: "0", Operation: tree.Op, Status: "waiting"}
+	single := true
+	for _, arg := range tree.Args {
+		if !arg.IsNumber() && arg.Var == "" {
+			single = false
 		}
 	}
-	if n == 0 {
-		evaluated := strings.Join(s, "")
-
-		// Минус на минус дает плюс
-		evaluated = strings.ReplaceAll(evaluated, "--", "+")
-
-		// Другие ситуации
-		evaluated = strings.ReplaceAll(evaluated, "+-", "-")
-		evaluated = strings.ReplaceAll(evaluated, "-+", "-")
-
-		// Создаем регулярное выражение для поиска повторяющихся плюсов
-		re := regexp.MustCompile("\\++")
-
-		// Заменяем все повторяющиеся плюсы на один "+"
-		evaluated = re.ReplaceAllString(evaluated, "+")
-
-		re = regexp.MustCompile("[\\+\\-*/]{2,}")
-		if re.MatchString(evaluated) {
-			return "", errors.New("Expression is not valid")
+	if single {
+		args := []*string{&task.Arg1, &task.Arg2}
+		for i, arg := range tree.Args {
+			*args[i] = arg.Var
+			if arg.IsNumber() {
+				*args[i] = strconv.FormatFloat(arg.Value, 'f', -1, 64)
+			}
 		}
-
-		return evaluated, nil // Возвращаем объединенное выражение без скобок
 	} else {
-		return "", errors.New("Expression is not valid") // Ошибка при наличии незакрытых скобок
+		data, err := json.Marshal(tree)
+		if err != nil {
+			return "", err
+		}
+		task.Operation = subtreeOperation
+		task.Tree = string(data)
+	}
+	id, err := insertTask(c.ctx, c.db, task)
+	if err != nil {
+		return "", err
 	}
+	return "id" + strconv.Itoa(id), nil
 }
@@ -0,0 +1,96 @@
+package orchestrator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+// newTestToken возвращает заголовок Authorization для пользователя
+func newTestToken(t *testing.T, login string) string {
+	t.Helper()
+	token, err := signToken(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+func TestCancelExpression(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+	newTestUser(t, db, "other")
+	s := NewServer(ConfigFromEnv())
+	a := &Application{config: s.config, server: s}
+
+	cancel := func(path string, login string) int {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodDelete, "/api/v1/expressions/"+path, nil)
+		if login != "" {
+			r.Header.Set("Authorization", newTestToken(t, login))
+		}
+		w := httptest.NewRecorder()
+		a.ExpressionByID(w, r)
+		return w.Code
+	}
+
+	// Задача выражения уже вычисляется агентом
+	exprID := submitTestExpression(t, db, userID, "1+2")
+	path := strconv.Itoa(exprID)
+	agent := &pb.AgentInfo{Id: "agent"}
+	tasks, err := s.claimTasks(ctx, db, agent, 1)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("Expected task to be claimed, but got %v (%v)", tasks, err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		path  string
+		login string
+		code  int
+	}{
+		{"without token", path, "", http.StatusForbidden},
+		{"by other user", path, "other", http.StatusForbidden},
+		{"unknown expression", "1000", "user", http.StatusNotFound},
+		{"invalid id", "abc", "user", http.StatusNotFound},
+	} {
+		if code := cancel(tc.path, tc.login); code != tc.code {
+			t.Errorf("Cancel %s: expected %d, but got %d", tc.name, tc.code, code)
+		}
+	}
+
+	if code := cancel(path, "user"); code != http.StatusOK {
+		t.Fatalf("Expected cancel to succeed, but got %d", code)
+	}
+	// Агент узнает об отмене, а его поздний результат не завершает выражение
+	response, err := s.Heartbeat(ctx, &pb.HeartbeatRequest{Agent: agent, TaskIds: []int64{tasks[0].Id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.CancelledIds) != 1 {
+		t.Error("Expected in-flight task to be cancelled")
+	}
+	s.applyResult(ctx, db, "agent", &pb.PostResultRequest{Id: tasks[0].Id, Result: 3})
+	expression, err := selectExpressionByID(ctx, db, exprID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "cancelled" {
+		t.Errorf("Expected expression to stay cancelled, but got %q", expression.Status)
+	}
+	if code := cancel(path, "user"); code != http.StatusConflict {
+		t.Errorf("Expected second cancel to conflict, but got %d", code)
+	}
+
+	// Вычисленное выражение отменить нельзя
+	done := submitTestExpression(t, db, userID, "2+2")
+	computeAll(t, s, db)
+	if code := cancel(strconv.Itoa(done), "user"); code != http.StatusConflict {
+		t.Errorf("Expected cancel of complete expression to conflict, but got %d", code)
+	}
+}
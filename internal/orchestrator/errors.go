@@ -0,0 +1,38 @@
+package orchestrator
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+// errorCodeName возвращает название типа ошибки для API: ERROR_CODE_DIVISION_BY_ZERO - division_by_zero.
+// Для неуказанного типа возвращается пустая строка
+func errorCodeName(code pb.ErrorCode) string {
+	if code == pb.ErrorCode_ERROR_CODE_UNSPECIFIED {
+		return ""
+	}
+	return strings.ToLower(strings.TrimPrefix(code.String(), "ERROR_CODE_"))
+}
+
+// checkFinite заменяет результат, не являющийся конечным числом, на соответствующую ошибку
+func checkFinite(in *pb.PostResultRequest) *pb.PostResultRequest {
+	result := float64(in.Result)
+	switch {
+	case math.IsNaN(result):
+		return &pb.PostResultRequest{Id: in.Id, Error: "result is not a number", ErrorCode: pb.ErrorCode_ERROR_CODE_NAN}
+	case math.IsInf(result, 0):
+		return &pb.PostResultRequest{Id: in.Id, Error: "overflow", ErrorCode: pb.ErrorCode_ERROR_CODE_OVERFLOW}
+	}
+	return in
+}
+
+// setComputationError завершает выражение с ошибкой вычисления и запоминает ее тип
+func setComputationError(ctx context.Context, db querier, id int, code string, text string) error {
+	if err := updateExpressionField(ctx, db, id, "error_code", code); err != nil {
+		return err
+	}
+	return setError(ctx, db, id, text)
+}
@@ -0,0 +1,52 @@
+package orchestrator
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestComputationError(t *testing.T) {
+	tests := []struct {
+		name           string
+		result         *pb.PostResultRequest
+		expectedStatus string
+		expectedCode   string
+	}{
+		{"division by zero", &pb.PostResultRequest{Error: "division by zero", ErrorCode: pb.ErrorCode_ERROR_CODE_DIVISION_BY_ZERO}, "error: division by zero", "division_by_zero"},
+		{"unsupported operation", &pb.PostResultRequest{Error: "unsupported operation", ErrorCode: pb.ErrorCode_ERROR_CODE_UNSUPPORTED_OPERATION}, "error: unsupported operation", "unsupported_operation"},
+		{"untyped error", &pb.PostResultRequest{Error: "something failed"}, "error: something failed", ""},
+		// Агент прислал бесконечность или NaN как обычный результат
+		{"infinity", &pb.PostResultRequest{Result: float32(math.Inf(1))}, "error: overflow", "overflow"},
+		{"nan", &pb.PostResultRequest{Result: float32(math.NaN())}, "error: result is not a number", "nan"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestDB(t)
+			ctx := context.Background()
+			expressionID := newTestExpression(t, db, newTestUser(t, db, "alice"), 1)
+			s := NewServer(ConfigFromEnv())
+
+			tasks, err := s.claimTasks(ctx, db, nil, 1)
+			if err != nil || len(tasks) != 1 {
+				t.Fatalf("Expected one task, but got %d (%v)", len(tasks), err)
+			}
+			test.result.Id = tasks[0].Id
+			if err := s.applyResult(ctx, db, "", test.result); err != nil {
+				t.Fatal(err)
+			}
+
+			expression, err := selectExpressionByID(ctx, db, expressionID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expression.Status != test.expectedStatus || expression.ErrorCode != test.expectedCode {
+				t.Fatalf("Expected status %q with code %q, but got %q with code %q",
+					test.expectedStatus, test.expectedCode, expression.Status, expression.ErrorCode)
+			}
+		})
+	}
+}
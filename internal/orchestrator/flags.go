@@ -0,0 +1,65 @@
+package orchestrator
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
+)
+
+// costFlagName возвращает имя флага для переменной окружения со временем операции:
+// TIME_ADDITION_MS - time-addition
+func costFlagName(env string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSuffix(env, "_MS")), "_", "-")
+}
+
+// RegisterFlags регистрирует флаги командной строки, повторяющие переменные окружения.
+// Значения по умолчанию берутся из текущей конфигурации, поэтому флаги переопределяют окружение
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "orchestrator-port", c.Addr, "порт HTTP сервера оркестратора (ORCHESTRATOR_PORT)")
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", c.GRPCAddr, "адрес gRPC сервера для агентов (GRPC_ADDR)")
+	for _, op := range operation.All() {
+		if op.CostEnv == "" {
+			continue
+		}
+		name := op.Name
+		usage := fmt.Sprintf("время выполнения операции %s, по умолчанию %v (%s)", name, c.operationTime(name), op.CostEnv)
+		fs.Func(costFlagName(op.CostEnv), usage, func(value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			c.OperationTimes[name] = d
+			return nil
+		})
+	}
+	fs.DurationVar(&c.AgentTimeout, "agent-timeout", c.AgentTimeout, "время без запросов, после которого агент считается отключенным (AGENT_TIMEOUT_MS)")
+	fs.DurationVar(&c.NoCapableAgentWait, "no-capable-agent-wait", c.NoCapableAgentWait, "время ожидания агента, поддерживающего операцию задачи (NO_CAPABLE_AGENT_WAIT_MS)")
+	fs.DurationVar(&c.PriorityAging, "priority-aging", c.PriorityAging, "время ожидания, повышающее приоритет задачи на единицу (PRIORITY_AGING_MS)")
+	fs.Func("user-weights", "веса пользователей в виде login=weight через запятую (USER_WEIGHTS)", func(value string) error {
+		c.UserWeights = parseUserWeights(value)
+		return nil
+	})
+	fs.Func("user-pools", "пулы агентов пользователей в виде login=pool через запятую (USER_POOLS)", func(value string) error {
+		c.UserPools = parseUserPools(value)
+		return nil
+	})
+	fs.DurationVar(&c.DefaultTimeout, "default-timeout", c.DefaultTimeout, "время на вычисление выражения по умолчанию, 0 - без ограничения (DEFAULT_TIMEOUT_MS)")
+	fs.DurationVar(&c.MaxTimeout, "max-timeout", c.MaxTimeout, "максимальное время на вычисление выражения, 0 - без ограничения (MAX_TIMEOUT_MS)")
+	fs.DurationVar(&c.TaskLease, "task-lease", c.TaskLease, "время сверх времени операции на возврат результата задачи (TASK_LEASE_MS)")
+	fs.IntVar(&c.MaxAttempts, "task-max-attempts", c.MaxAttempts, "количество попыток вычисления задачи (TASK_MAX_ATTEMPTS)")
+	fs.DurationVar(&c.RetryBackoff, "retry-backoff", c.RetryBackoff, "задержка перед первой повторной попыткой (RETRY_BACKOFF_MS)")
+	fs.Float64Var(&c.VerifyFraction, "verify-fraction", c.VerifyFraction, "доля задач, результат которых проверяется вторым агентом, от 0 до 1 (VERIFY_FRACTION)")
+	fs.IntVar(&c.TaskGranularity, "task-granularity", c.TaskGranularity, "наибольшее количество операций в одной задаче, 1 - каждая операция отдельной задачей (TASK_GRANULARITY)")
+	fs.IntVar(&c.InlineMaxOperations, "inline-max-operations", c.InlineMaxOperations, "наибольшее количество операций выражения, которое вычисляется сразу в оркестраторе, 0 - не ограничено, если задан -inline-max-time (INLINE_MAX_OPERATIONS)")
+	fs.DurationVar(&c.InlineMaxTime, "inline-max-time", c.InlineMaxTime, "наибольшее время вычисления выражения агентами, при котором оно вычисляется сразу в оркестраторе, 0 - не ограничено, если задан -inline-max-operations; если оба 0, выражения сразу не вычисляются (INLINE_MAX_TIME_MS)")
+	fs.Float64Var(&c.SpeculationFactor, "speculation-factor", c.SpeculationFactor, "во сколько раз задача должна вычисляться дольше обычного, чтобы ее копию выдали другому агенту, 0 - без копий (SPECULATION_FACTOR)")
+	fs.Float64Var(&c.MaxFailureRate, "agent-max-failure-rate", c.MaxFailureRate, "доля сбоев среди последних задач агента, после которой он попадает в карантин, 1 - без карантина (AGENT_MAX_FAILURE_RATE)")
+	fs.DurationVar(&c.Quarantine, "agent-quarantine", c.Quarantine, "время карантина агента (AGENT_QUARANTINE_MS)")
+	fs.Func("admin-logins", "логины администраторов через запятую (ADMIN_LOGINS)", func(value string) error {
+		c.AdminLogins = strings.Split(value, ",")
+		return nil
+	})
+}
@@ -0,0 +1,43 @@
+package orchestrator
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFlags(t *testing.T) {
+	t.Setenv("ORCHESTRATOR_PORT", "9090")
+	t.Setenv("GRPC_ADDR", "localhost:6000")
+	t.Setenv("AGENT_TIMEOUT_MS", "1000")
+	t.Setenv("TIME_ADDITION_MS", "50")
+	t.Setenv("TIME_SUBTRACTION_MS", "60")
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "")
+
+	config := ConfigFromEnv()
+	fs := flag.NewFlagSet("orchestrator", flag.ContinueOnError)
+	config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-grpc-addr", ":7000", "-agent-timeout", "3s", "-time-addition", "250ms"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Флаг переопределяет переменную окружения, а без флага остается значение из окружения
+	if config.GRPCAddr != ":7000" || config.AgentTimeout != 3*time.Second || config.OperationTimes["+"] != 250*time.Millisecond {
+		t.Errorf("Expected flags to override environment, but got %q %v %v", config.GRPCAddr, config.AgentTimeout, config.OperationTimes["+"])
+	}
+	if config.Addr != "9090" || config.OperationTimes["-"] != 60*time.Millisecond {
+		t.Errorf("Expected environment values without flags, but got %q %v", config.Addr, config.OperationTimes["-"])
+	}
+	// Без переменной окружения время встроенной операции, как и раньше, нулевое
+	if config.OperationTimes["*"] != 0 {
+		t.Errorf("Expected multiplication time to default to 0, but got %v", config.OperationTimes["*"])
+	}
+
+	fs = flag.NewFlagSet("orchestrator", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	ConfigFromEnv().RegisterFlags(fs)
+	if err := fs.Parse([]string{"-time-addition", "fast"}); err == nil {
+		t.Error("Expected invalid operation time to be rejected")
+	}
+}
@@ -0,0 +1,280 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+// Предельная вложенность вызовов пользовательских функций
+const maxFunctionDepth = 32
+
+// Function - пользовательская функция, сохраненная в виде определения name(x, y) = x^2 + 3*y
+type Function struct {
+	ID         int    `json:"-"`
+	UserID     int    `json:"-"`
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+func upsertFunction(ctx context.Context, db querier, function Function) error {
+	var q = `
+	INSERT INTO functions (user_id, name, definition) values ($1, $2, $3)
+	ON CONFLICT (user_id, name) DO UPDATE SET definition = excluded.definition
+	`
+	_, err := db.ExecContext(ctx, q, function.UserID, function.Name, function.Definition)
+	return err
+}
+
+func selectFunctionsByUserID(ctx context.Context, db querier, userID int) ([]Function, error) {
+	var functions []Function
+	var q = "SELECT id, user_id, name, definition FROM functions WHERE user_id = ? ORDER BY name"
+
+	rows, err := db.QueryContext(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f := Function{}
+		if err := rows.Scan(&f.ID, &f.UserID, &f.Name, &f.Definition); err != nil {
+			return nil, err
+		}
+		functions = append(functions, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return functions, nil
+}
+
+func deleteFunction(ctx context.Context, db querier, userID int, name string) (bool, error) {
+	var q = "DELETE FROM functions WHERE user_id = $1 AND name = $2"
+	result, err := db.ExecContext(ctx, q, userID, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// functionResolver возвращает функции пользователя, при необходимости заменяя одну из них новым определением.
+// Функция, которой у пользователя нет, может быть операцией плагина одного из подключенных агентов
+func (s *Server) functionResolver(ctx context.Context, db querier, userID int, override *calculation.Function) (calculation.Resolver, error) {
+	functions, err := selectFunctionsByUserID(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	definitions := make(map[string]string, len(functions))
+	for _, f := range functions {
+		definitions[f.Name] = f.Definition
+	}
+	return func(name string) (*calculation.Function, error) {
+		if override != nil && name == override.Name {
+			return override, nil
+		}
+		if definition, ok := definitions[name]; ok {
+			return calculation.ParseFunction(definition)
+		}
+		if spec, ok := s.agents.remoteOperation(name, s.config.AgentTimeout); ok {
+			return remoteFunction(spec), nil
+		}
+		return nil, fmt.Errorf("%w: unknown function %q", calculation.ErrInvalidExpression, name)
+	}, nil
+}
+
+// remoteFunction представляет операцию плагина функцией, тело которой - сама операция
+func remoteFunction(spec *pb.OperationSpec) *calculation.Function {
+	fn := &calculation.Function{Name: spec.GetName(), Body: &calculation.Node{Op: spec.GetName()}}
+	for i := 0; i < int(spec.GetArity()); i++ {
+		param := fmt.Sprintf("x%d", i+1)
+		fn.Params = append(fn.Params, param)
+		fn.Body.Args = append(fn.Body.Args, &calculation.Node{Var: param})
+	}
+	return fn
+}
+
+// parseExpression разбирает выражение пользователя и подставляет в него его функции
+func (s *Server) parseExpression(ctx context.Context, db querier, userID int, expression string) (*calculation.Node, error) {
+	node, err := calculation.Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+	resolve, err := s.functionResolver(ctx, db, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	return calculation.Inline(node, resolve, maxFunctionDepth)
+}
+
+// checkFunction проверяет, что функцию можно подставить: ее имя не занято операцией плагина,
+// все вызываемые ею функции определены, а новое определение не делает ни одну из функций
+// пользователя рекурсивной
+func (s *Server) checkFunction(ctx context.Context, db querier, userID int, fn *calculation.Function) error {
+	if _, ok := s.agents.remoteOperation(fn.Name, s.config.AgentTimeout); ok {
+		return fmt.Errorf("%w: %q is an operation of agent plugin", calculation.ErrInvalidExpression, fn.Name)
+	}
+	resolve, err := s.functionResolver(ctx, db, userID, fn)
+	if err != nil {
+		return err
+	}
+	functions, err := selectFunctionsByUserID(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	names := []string{fn.Name}
+	for _, f := range functions {
+		if f.Name != fn.Name {
+			names = append(names, f.Name)
+		}
+	}
+	for _, name := range names {
+		callee, err := resolve(name)
+		if err != nil {
+			return err
+		}
+		// Тело проверяется с параметрами вместо аргументов
+		call := &calculation.Node{Func: name}
+		for _, param := range callee.Params {
+			call.Args = append(call.Args, &calculation.Node{Var: param})
+		}
+		if _, err := calculation.Inline(call, resolve, maxFunctionDepth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetFunctions возвращает функции пользователя
+func GetFunctions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, 405)
+		return
+	}
+
+	token := r.Header.Get("Authorization")
+	user, err := getUserFromToken(token)
+	if err != nil {
+		sendError(w, 401)
+		return
+	}
+
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	defer db.Close()
+
+	functions, err := selectFunctionsByUserID(context.Background(), db, user.ID)
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	if functions == nil {
+		functions = []Function{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"functions": functions})
+}
+
+// FunctionByName направляет запрос к функции по ее имени в обработчик для метода запроса
+func (a *Application) FunctionByName(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		a.PutFunction(w, r)
+	case http.MethodDelete:
+		DeleteFunction(w, r)
+	default:
+		sendError(w, 405)
+	}
+}
+
+// PutFunction создает или заменяет функцию пользователя
+func (a *Application) PutFunction(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[len("/api/v1/functions/"):]
+
+	token := r.Header.Get("Authorization")
+	user, err := getUserFromToken(token)
+	if err != nil {
+		sendError(w, 401)
+		return
+	}
+
+	var input struct {
+		Definition string `json:"definition"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, 422)
+		return
+	}
+	fn, err := calculation.ParseFunction(input.Definition)
+	if err != nil || fn.Name != name {
+		sendError(w, 422)
+		return
+	}
+
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	defer db.Close()
+
+	if err := a.server.checkFunction(context.Background(), db, user.ID, fn); err != nil {
+		sendError(w, 422)
+		return
+	}
+	function := Function{UserID: user.ID, Name: fn.Name, Definition: input.Definition}
+	if err := upsertFunction(context.Background(), db, function); err != nil {
+		sendError(w, 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"function": function})
+}
+
+// DeleteFunction удаляет функцию пользователя
+func DeleteFunction(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[len("/api/v1/functions/"):]
+
+	token := r.Header.Get("Authorization")
+	user, err := getUserFromToken(token)
+	if err != nil {
+		sendError(w, 401)
+		return
+	}
+
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	defer db.Close()
+
+	deleted, err := deleteFunction(context.Background(), db, user.ID, name)
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	if !deleted {
+		sendError(w, 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "OK"})
+}
@@ -0,0 +1,74 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
+)
+
+// defineTestFunction проверяет и сохраняет функцию так же, как PutFunction
+func defineTestFunction(t *testing.T, s *Server, db querier, userID int, definition string) error {
+	t.Helper()
+	ctx := context.Background()
+	fn, err := calculation.ParseFunction(definition)
+	if err != nil {
+		return err
+	}
+	if err := s.checkFunction(ctx, db, userID, fn); err != nil {
+		return err
+	}
+	return upsertFunction(ctx, db, Function{UserID: userID, Name: fn.Name, Definition: definition})
+}
+
+func TestUserFunctions(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	alice := newTestUser(t, db, "alice")
+	bob := newTestUser(t, db, "bob")
+	s := NewServer(ConfigFromEnv())
+
+	if err := defineTestFunction(t, s, db, alice, "f(x, y) = x^2 + 3*y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := defineTestFunction(t, s, db, alice, "g(x) = f(x, x) + 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := s.parseExpression(ctx, db, alice, "g(2) * 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := calculation.Eval(node); err != nil || result != 22 {
+		t.Fatalf("Expected 22, but got %v (%v)", result, err)
+	}
+	// Функции хранятся для каждого пользователя отдельно
+	if _, err := s.parseExpression(ctx, db, bob, "g(2)"); err == nil {
+		t.Fatal("Expected function of another user to be unknown")
+	}
+
+	// Переопределение f через g сделало бы обе функции рекурсивными
+	if err := defineTestFunction(t, s, db, alice, "f(x, y) = g(x) + y"); err == nil {
+		t.Fatal("Expected recursive redefinition to be rejected")
+	}
+	if err := defineTestFunction(t, s, db, alice, "h(x) = missing(x)"); err == nil {
+		t.Fatal("Expected call of undefined function to be rejected")
+	}
+
+	// Переопределение меняет уже определенные через функцию выражения
+	if err := defineTestFunction(t, s, db, alice, "f(x, y) = x + y"); err != nil {
+		t.Fatal(err)
+	}
+	node, err = s.parseExpression(ctx, db, alice, "g(2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := calculation.Eval(node); err != nil || result != 5 {
+		t.Fatalf("Expected 5, but got %v (%v)", result, err)
+	}
+
+	functions, err := selectFunctionsByUserID(ctx, db, alice)
+	if err != nil || len(functions) != 2 {
+		t.Fatalf("Expected 2 functions, but got %d (%v)", len(functions), err)
+	}
+}
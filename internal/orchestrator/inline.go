@@ -0,0 +1,53 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
+)
+
+// inlineable проверяет, вычисляется ли выражение сразу в оркестраторе. Для этого должно быть задано
+// хотя бы одно из ограничений InlineMaxOperations и InlineMaxTime и выражение должно укладываться
+// во все заданные. Операции плагинов вычисляют только агенты, поэтому такие выражения не вычисляются
+func (s *Server) inlineable(node *calculation.Node) bool {
+	c := s.config
+	if c.InlineMaxOperations <= 0 && c.InlineMaxTime <= 0 {
+		return false
+	}
+	operations, remote := 0, false
+	walkTree(node, func(node *calculation.Node) {
+		if node.Op != "" {
+			operations++
+			remote = remote || isRemoteOperation(node.Op)
+		}
+	})
+	if remote {
+		return false
+	}
+	if c.InlineMaxOperations > 0 && operations > c.InlineMaxOperations {
+		return false
+	}
+	return c.InlineMaxTime <= 0 || s.treeOperationTime(node) <= c.InlineMaxTime
+}
+
+// insertInlineExpression вычисляет выражение в оркестраторе без выдержки времени операций и сохраняет
+// его вычисленным или завершенным с ошибкой вычисления, как если бы ее вернул агент
+func insertInlineExpression(ctx context.Context, db querier, expression Expression, node *calculation.Node) (int, error) {
+	result, err := calculation.Eval(node)
+	if err == nil {
+		expression.Status = "complete"
+		expression.Result = result
+		return insertExpression(ctx, db, expression)
+	}
+	var opErr *operation.Error
+	if !errors.As(err, &opErr) {
+		return 0, err
+	}
+	id, err := insertExpression(ctx, db, expression)
+	if err != nil {
+		return 0, err
+	}
+	return id, setComputationError(ctx, db, id, string(opErr.Code), opErr.Message)
+}
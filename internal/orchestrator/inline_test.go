@@ -0,0 +1,94 @@
+package orchestrator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestInlineExpressions(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+	config := ConfigFromEnv()
+	config.OperationTimes["+"] = 100 * time.Millisecond
+	config.OperationTimes["*"] = 200 * time.Millisecond
+	s := NewServer(config)
+	s.agents.seen(&pb.AgentInfo{Id: "plugins", CustomOperations: []*pb.OperationSpec{{Name: "inlinehyp", Arity: 2}}})
+
+	cases := []struct {
+		expression    string
+		maxOperations int
+		maxTime       time.Duration
+		inline        bool
+	}{
+		{"2+2*2", 0, 0, false},
+		{"2+2*2", 2, 0, true},
+		{"2+2*2+1", 2, 0, false},
+		{"2+2*2", 0, 300 * time.Millisecond, true},
+		{"2*2*2", 0, 300 * time.Millisecond, false},
+		{"2+2*2", 5, 200 * time.Millisecond, false},
+		{"inlinehyp(3, 4)", 5, 0, false},
+	}
+	for _, tc := range cases {
+		config.InlineMaxOperations, config.InlineMaxTime = tc.maxOperations, tc.maxTime
+		node, err := s.parseExpression(ctx, db, userID, tc.expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inline := s.inlineable(node); inline != tc.inline {
+			t.Errorf("Expected inlineable(%q) with %d operations and %v to be %v", tc.expression, tc.maxOperations, tc.maxTime, tc.inline)
+		}
+	}
+
+	insert := func(expression string) Expression {
+		t.Helper()
+		node, err := s.parseExpression(ctx, db, userID, expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := insertInlineExpression(ctx, db, Expression{UserID: userID, Status: "waiting"}, node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := selectExpressionByID(ctx, db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+	if expression := insert("2+2*2"); expression.Status != "complete" || expression.Result != 6 {
+		t.Errorf("Expected 2+2*2 to be complete with 6, but got %q %v", expression.Status, expression.Result)
+	}
+	// Ошибка вычисления сохраняется так же, как от агента
+	if expression := insert("1/(2-2)"); expression.Status != "error: division by zero" || expression.ErrorCode != "division_by_zero" {
+		t.Errorf("Expected division by zero error, but got %q %q", expression.Status, expression.ErrorCode)
+	}
+	tasks, err := selectTasks(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 0 {
+		t.Errorf("Expected no tasks for inline expressions, but got %d", len(tasks))
+	}
+
+	s.metrics.expression(pathInline)
+	s.metrics.expression(pathInline)
+	s.metrics.expression(pathTasks)
+	a := &Application{config: config, server: s}
+	w := httptest.NewRecorder()
+	a.metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{
+		`gocalc_orchestrator_expressions_total{path="inline"} 2`,
+		`gocalc_orchestrator_expressions_total{path="tasks"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("Expected %q in /metrics, but got:\n%s", line, w.Body.String())
+		}
+	}
+}
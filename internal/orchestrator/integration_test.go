@@ -0,0 +1,255 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/f1rsov08/go_calc_2/internal/agent"
+	pb "github.com/f1rsov08/go_calc_2/proto"
+	"google.golang.org/grpc"
+)
+
+// TestMain запускает тестовый бинарник как агента, если его запустил интеграционный тест
+func TestMain(m *testing.M) {
+	if os.Getenv("GO_CALC_TEST_AGENT") == "1" {
+		if err := agent.New().Run(); err != nil {
+			os.Exit(1)
+		}
+		select {}
+	}
+	os.Exit(m.Run())
+}
+
+// testAgents запускает агентов отдельными процессами и перезапускает завершившихся,
+// пока не будет вызвана возвращенная функция остановки
+func testAgents(t *testing.T, n int, env ...string) func() {
+	t.Helper()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				cmd := exec.Command(os.Args[0])
+				cmd.Env = append(os.Environ(), append([]string{"GO_CALC_TEST_AGENT=1"}, env...)...)
+				if err := cmd.Start(); err != nil {
+					t.Error(err)
+					return
+				}
+				exited := make(chan struct{})
+				go func() {
+					cmd.Wait()
+					close(exited)
+				}()
+				select {
+				case <-exited:
+					// Агент упал, например из-за сбоя crash, и запускается снова
+				case <-done:
+					cmd.Process.Kill()
+					<-exited
+					return
+				}
+			}
+		}()
+	}
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// startTestOrchestrator запускает gRPC сервер оркестратора с быстрыми операциями и короткой арендой
+// и возвращает его адрес
+func startTestOrchestrator(t *testing.T, config *Config) string {
+	t.Helper()
+	config.TaskLease = 300 * time.Millisecond
+	config.MaxAttempts = 1000
+	config.RetryBackoff = 10 * time.Millisecond
+	for name := range config.OperationTimes {
+		config.OperationTimes[name] = 10 * time.Millisecond
+	}
+	s := NewServer(config)
+	grpcServer := grpc.NewServer()
+	pb.RegisterTaskServiceServer(grpcServer, s)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve(lis)
+	go s.watch()
+	t.Cleanup(func() {
+		grpcServer.Stop()
+		close(s.stop)
+	})
+	return lis.Addr().String()
+}
+
+// Выражения интеграционных тестов и их значения
+var integrationExpressions = map[string]float64{
+	"(1+2)*(3+4)-10/5":          19,
+	"2*2*2*2*2*2-1":             63,
+	"(8-3)*(8+3)+(6/3)*(5-1)":   63,
+	"1+2+3+4+5+6+7+8+9+10":      55,
+	"(100-1)/(10-1)*(2+3)-4*5":  35,
+	"((1+1)*(2+2)+(3+3))*(4-2)": 28,
+}
+
+// checkIntegrationExpressions добавляет выражения с задачами до granularity операций
+// и ждет, пока все они вычислятся верно
+func checkIntegrationExpressions(t *testing.T, db *sql.DB, granularity int, timeout time.Duration) {
+	t.Helper()
+	userID := newTestUser(t, db, "user")
+	ids := make(map[int]float64)
+	for expression, want := range integrationExpressions {
+		ids[submitTestChunks(t, db, userID, expression, granularity)] = want
+	}
+
+	ctx := context.Background()
+	deadline := time.Now().Add(timeout)
+	for len(ids) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		for id, want := range ids {
+			expression, err := selectExpressionByID(ctx, db, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch expression.Status {
+			case "waiting", statusNoCapableAgent:
+				continue
+			case "complete":
+				if expression.Result != want {
+					t.Errorf("Expression %d: expected %v, but got %v", id, want, expression.Result)
+				}
+			default:
+				t.Errorf("Expression %d: expected to complete, but got status %q", id, expression.Status)
+			}
+			delete(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		t.Fatalf("Expected all expressions to complete, but %d are still waiting", len(ids))
+	}
+}
+
+// TestFaultInjection проверяет, что выражения вычисляются верно, когда агенты теряют,
+// задерживают и дублируют результаты и падают посреди вычисления
+func TestFaultInjection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	tests := []struct {
+		name   string
+		verify float64
+		faults []string // Сбои каждого из агентов
+	}{
+		{"lost and repeated results", 0, []string{
+			"drop=0.1,delay=0.1,duplicate=0.3,crash=0.05",
+			"drop=0.1,delay=0.1,duplicate=0.3,crash=0.05",
+			"drop=0.1,delay=0.1,duplicate=0.3,crash=0.05",
+		}},
+		// Неверные результаты одного из агентов отбрасываются проверкой вторым агентом
+		{"wrong results", 1, []string{
+			"drop=0.1,crash=0.05",
+			"drop=0.1,crash=0.05",
+			"wrong=0.3,drop=0.1,crash=0.05",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestDB(t)
+			config := ConfigFromEnv()
+			// Сбои вносятся намеренно, и агенты не должны попадать из-за них в карантин
+			config.MaxFailureRate = 1
+			config.VerifyFraction = test.verify
+			addr := startTestOrchestrator(t, config)
+
+			for _, faults := range test.faults {
+				stop := testAgents(t, 1,
+					"ORCHESTRATOR_ADDR="+addr,
+					"COMPUTING_POWER=2",
+					"WAIT_TIME=20",
+					"MAX_WAIT_TIME=100",
+					"FAULTS="+faults,
+					"FAULT_DELAY_MS=1500",
+				)
+				defer stop()
+			}
+
+			checkIntegrationExpressions(t, db, 1, time.Minute)
+			if test.verify == 0 {
+				return
+			}
+			mismatches, err := selectMismatches(context.Background(), db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(mismatches) == 0 {
+				t.Error("Expected wrong results to be caught by verification")
+			}
+		})
+	}
+}
+
+// TestSubtreeOffloading проверяет, что подвыражения, выданные агентам целиком, вычисляются верно,
+// в том числе когда агенты теряют результаты и падают
+func TestSubtreeOffloading(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	db := newTestDB(t)
+	config := ConfigFromEnv()
+	config.MaxFailureRate = 1
+	addr := startTestOrchestrator(t, config)
+
+	stop := testAgents(t, 2,
+		"ORCHESTRATOR_ADDR="+addr,
+		"COMPUTING_POWER=2",
+		"WAIT_TIME=20",
+		"MAX_WAIT_TIME=100",
+		"FAULTS=drop=0.1,crash=0.05",
+	)
+	defer stop()
+
+	checkIntegrationExpressions(t, db, 4, time.Minute)
+}
+
+// TestVerification проверяет, что при проверке всех задач вторым агентом неверные результаты
+// агента не попадают в выражения, а сам агент отмечается
+func TestVerification(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	db := newTestDB(t)
+	config := ConfigFromEnv()
+	config.VerifyFraction = 1
+	addr := startTestOrchestrator(t, config)
+
+	env := []string{"ORCHESTRATOR_ADDR=" + addr, "COMPUTING_POWER=2", "WAIT_TIME=20", "MAX_WAIT_TIME=100"}
+	// Два исправных агента и один, возвращающий неверный результат каждой второй задачи
+	stop := testAgents(t, 2, env...)
+	defer stop()
+	stopFaulty := testAgents(t, 1, append(env, "FAULTS=wrong=0.5,duplicate=0.2")...)
+	defer stopFaulty()
+
+	checkIntegrationExpressions(t, db, 1, time.Minute)
+	mismatches, err := selectMismatches(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) == 0 {
+		t.Fatal("Expected faulty agent to be flagged")
+	}
+	for _, m := range mismatches {
+		if m.AgentID != mismatches[0].AgentID {
+			t.Errorf("Expected only faulty agent to be flagged, but got %+v", mismatches)
+			break
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package orchestrator
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+// Метка пула агентов. Агент пула получает только задачи выражений этого пула
+const poolLabel = "pool"
+
+var (
+	errInvalidLabels = errors.New("invalid labels")
+	errPoolForbidden = errors.New("pool is reserved for other users")
+)
+
+// formatLabels записывает метки в виде key=value через запятую в порядке ключей
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// parseLabels разбирает метки, записанные formatLabels
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	if s == "" {
+		return labels
+	}
+	for _, pair := range strings.Split(s, ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok && key != "" {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// matchesLabels проверяет, есть ли у агента все метки, которых требует выражение.
+// Агент пула, кроме того, не получает задачи выражений других пулов и выражений без пула
+func matchesLabels(agent *pb.AgentInfo, required string) bool {
+	want := parseLabels(required)
+	labels := agent.GetLabels()
+	for key, value := range want {
+		if labels[key] != value {
+			return false
+		}
+	}
+	if pool := labels[poolLabel]; pool != "" && want[poolLabel] != pool {
+		return false
+	}
+	return true
+}
+
+// expressionLabels возвращает метки, которых требует выражение пользователя. Пользователю с пулом
+// из USER_POOLS выражения всегда направляются в его пул, а чужие пулы остальным пользователям недоступны
+func (c *Config) expressionLabels(login string, requested map[string]string) (string, error) {
+	labels := make(map[string]string, len(requested)+1)
+	for key, value := range requested {
+		if key == "" || strings.ContainsAny(key, ",=") || strings.Contains(value, ",") {
+			return "", errInvalidLabels
+		}
+		if value != "" {
+			labels[key] = value
+		}
+	}
+	if pool, ok := c.UserPools[login]; ok {
+		if labels[poolLabel] != "" && labels[poolLabel] != pool {
+			return "", errPoolForbidden
+		}
+		labels[poolLabel] = pool
+	} else if labels[poolLabel] != "" {
+		for _, reserved := range c.UserPools {
+			if reserved == labels[poolLabel] {
+				return "", errPoolForbidden
+			}
+		}
+	}
+	return formatLabels(labels), nil
+}
+
+// parseUserPools разбирает пулы пользователей, заданные в виде login=pool через запятую
+func parseUserPools(s string) map[string]string {
+	pools := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		login, pool, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || login == "" || pool == "" {
+			continue
+		}
+		pools[login] = pool
+	}
+	return pools
+}
@@ -0,0 +1,73 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestExpressionLabels(t *testing.T) {
+	config := &Config{UserPools: map[string]string{"alice": "premium"}}
+	cases := []struct {
+		login     string
+		requested map[string]string
+		want      string
+		err       error
+	}{
+		{"bob", nil, "", nil},
+		{"bob", map[string]string{"region": "lab2", "pool": "free"}, "pool=free,region=lab2", nil},
+		{"bob", map[string]string{"pool": "premium"}, "", errPoolForbidden},
+		{"bob", map[string]string{"a,b": "c"}, "", errInvalidLabels},
+		{"alice", nil, "pool=premium", nil},
+		{"alice", map[string]string{"region": "lab2"}, "pool=premium,region=lab2", nil},
+		{"alice", map[string]string{"pool": "free"}, "", errPoolForbidden},
+	}
+	for _, c := range cases {
+		got, err := config.expressionLabels(c.login, c.requested)
+		if got != c.want || err != c.err {
+			t.Errorf("expressionLabels(%q, %v) = %q, %v, expected %q, %v", c.login, c.requested, got, err, c.want, c.err)
+		}
+	}
+}
+
+func TestPoolRouting(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+	s := NewServer(ConfigFromEnv())
+
+	submit := func(expression string, labels string) {
+		t.Helper()
+		id := submitTestExpression(t, db, userID, expression)
+		if err := updateExpressionField(ctx, db, id, "labels", labels); err != nil {
+			t.Fatal(err)
+		}
+	}
+	submit("1+1", "")
+	submit("2+2", "pool=premium")
+	submit("3+3", "pool=premium,region=lab2")
+
+	claim := func(labels map[string]string) []float32 {
+		t.Helper()
+		tasks, err := s.claimTasks(ctx, db, &pb.AgentInfo{Id: "agent", Labels: labels}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var args []float32
+		for _, task := range tasks {
+			args = append(args, task.Arg1)
+		}
+		return args
+	}
+
+	if args := claim(map[string]string{"pool": "premium"}); len(args) != 1 || args[0] != 2 {
+		t.Errorf("Expected premium agent to get only the premium task without region, but got %v", args)
+	}
+	if args := claim(map[string]string{"region": "lab2"}); len(args) != 1 || args[0] != 1 {
+		t.Errorf("Expected agent without pool to get only the task without pool, but got %v", args)
+	}
+	if args := claim(map[string]string{"pool": "premium", "region": "lab2"}); len(args) != 1 || args[0] != 3 {
+		t.Errorf("Expected premium agent in lab2 to get the remaining task, but got %v", args)
+	}
+}
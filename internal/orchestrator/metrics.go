@@ -0,0 +1,48 @@
+package orchestrator
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Способы вычисления принятых выражений
+const (
+	pathInline = "inline" // Вычислено сразу в оркестраторе
+	pathTasks  = "tasks"  // Разбито на задачи для агентов
+)
+
+// metrics - счетчики оркестратора, которые отдает /metrics
+type metrics struct {
+	mu          sync.Mutex
+	expressions map[string]int64 // Принятые выражения по способу вычисления
+}
+
+func newMetrics() *metrics {
+	return &metrics{expressions: make(map[string]int64)}
+}
+
+// expression учитывает принятое выражение
+func (m *metrics) expression(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expressions[path]++
+}
+
+// metricsHandler отдает метрики оркестратора в текстовом формате Prometheus
+func (a *Application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, 405)
+		return
+	}
+	m := a.server.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP gocalc_orchestrator_expressions_total Expressions accepted, by the way they are computed.")
+	fmt.Fprintln(w, "# TYPE gocalc_orchestrator_expressions_total counter")
+	for _, path := range []string{pathInline, pathTasks} {
+		fmt.Fprintf(w, "gocalc_orchestrator_expressions_total{path=%q} %d\n", path, m.expressions[path])
+	}
+}
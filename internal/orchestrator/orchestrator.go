@@ -2,11 +2,15 @@ package orchestrator
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"context"
@@ -19,9 +23,12 @@ import (
 
 	"net"
 
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
 	pb "github.com/f1rsov08/go_calc_2/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 )
 
@@ -33,14 +40,32 @@ type Task struct {
 	Operation    string  `json:"operation"`
 	Status       string  `json:"status"`
 	Result       float64 `json:"result"`
+	Priority     int     `json:"priority"`
+	CreatedAt    int64   `json:"created_at"`  // Время создания в миллисекундах
+	UserID       int     `json:"user_id"`     // Владелец выражения, заполняется только в selectTasks
+	Labels       string  `json:"labels"`      // Метки, которых выражение требует от агентов, заполняется только в selectTasks
+	Attempts     int     `json:"attempts"`    // Количество неудачных попыток вычисления
+	AgentID      string  `json:"agent_id"`    // Агент, которому выдана задача
+	LeaseUntil   int64   `json:"lease_until"` // Время в миллисекундах, до которого агент должен вернуть результат
+	NotBefore    int64   `json:"not_before"`  // Время в миллисекундах, раньше которого задачу нельзя выдавать повторно
+	LastError    string  `json:"last_error"`  // Последняя временная ошибка
+	StartedAt    int64   `json:"started_at"`  // Время выдачи задачи агенту в миллисекундах
+	Tree         string  `json:"tree"`        // Подвыражение задачи subtree в виде JSON, ссылки на задачи записаны параметрами id<N>
+
+	SpeculativeAgentID   string `json:"speculative_agent_id"`   // Агент, вычисляющий копию задачи, которая долго не вычисляется
+	SpeculativeStartedAt int64  `json:"speculative_started_at"` // Время выдачи копии задачи в миллисекундах
 }
 
 type Expression struct {
-	ID     int     `json:"id"`
-	UserID int     `json:"user_id"`
-	Status string  `json:"status"`
-	Answer int     `json:"tasks"`
-	Result float64 `json:"result"`
+	ID        int     `json:"id"`
+	UserID    int     `json:"user_id"`
+	Status    string  `json:"status"`
+	Answer    int     `json:"tasks"`
+	Result    float64 `json:"result"`
+	Priority  int     `json:"priority"`
+	Deadline  int64   `json:"deadline"`   // Крайний срок вычисления в миллисекундах, 0 - без ограничения
+	ErrorCode string  `json:"error_code"` // Тип ошибки вычисления, пустой для остальных статусов
+	Labels    string  `json:"labels"`     // Метки агентов, которым выдаются задачи выражения, в виде key=value через запятую
 }
 
 type User struct {
@@ -51,7 +76,27 @@ type User struct {
 }
 
 type Config struct {
-	Addr string // Порт, на котором будет запущен сервер
+	Addr                string                   // Порт, на котором будет запущен сервер
+	GRPCAddr            string                   // Адрес, на котором gRPC сервер ждет агентов
+	OperationTimes      map[string]time.Duration // Время выполнения операций
+	AgentTimeout        time.Duration            // Время без обращений, после которого агент считается отключенным
+	NoCapableAgentWait  time.Duration            // Время ожидания агента, способного выполнить задачу
+	PriorityAging       time.Duration            // Время ожидания, повышающее приоритет задачи на единицу
+	UserWeights         map[string]float64       // Веса пользователей при распределении задач, по умолчанию 1
+	UserPools           map[string]string        // Пулы агентов, в которые направляются выражения пользователей
+	DefaultTimeout      time.Duration            // Время на вычисление выражения, если оно не указано в запросе, 0 - без ограничения
+	MaxTimeout          time.Duration            // Максимальное время на вычисление выражения, 0 - без ограничения
+	TaskLease           time.Duration            // Время сверх времени операции, за которое агент должен вернуть результат или продлить аренду
+	MaxAttempts         int                      // Количество попыток вычисления задачи до перевода в dead letter
+	RetryBackoff        time.Duration            // Задержка перед первой повторной попыткой, далее удваивается
+	AdminLogins         []string                 // Логины пользователей с доступом к административным эндпоинтам
+	VerifyFraction      float64                  // Доля задач, результат которых проверяется вторым агентом
+	TaskGranularity     int                      // Наибольшее количество операций в одной задаче
+	InlineMaxOperations int                      // Наибольшее количество операций выражения, которое вычисляется сразу в оркестраторе, 0 - не ограничено, если задано InlineMaxTime
+	InlineMaxTime       time.Duration            // Наибольшее время вычисления выражения агентами, при котором оно вычисляется сразу в оркестраторе, 0 - не ограничено, если задано InlineMaxOperations. Если оба 0, выражения сразу не вычисляются
+	SpeculationFactor   float64                  // Во сколько раз задача должна вычисляться дольше обычного, чтобы ее копию выдали другому агенту, 0 - без копий
+	MaxFailureRate      float64                  // Доля сбоев среди последних задач агента, после которой он попадает в карантин
+	Quarantine          time.Duration            // Время карантина агента
 }
 
 // Функция для создания конфигурации из переменных окружения
@@ -61,27 +106,179 @@ func ConfigFromEnv() *Config {
 	if config.Addr == "" {
 		config.Addr = "8080"
 	}
+
+	config.GRPCAddr = os.Getenv("GRPC_ADDR")
+	if config.GRPCAddr == "" {
+		config.GRPCAddr = "localhost:50042"
+	}
+
+	// Время выполнения операции задается ее переменной окружения, а без нее берется из реестра
+	config.OperationTimes = make(map[string]time.Duration)
+	for _, op := range operation.All() {
+		config.OperationTimes[op.Name] = op.Cost
+		if op.CostEnv == "" {
+			continue
+		}
+		if ms, err := strconv.Atoi(os.Getenv(op.CostEnv)); err == nil {
+			config.OperationTimes[op.Name] = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	agentTimeout, err := strconv.Atoi(os.Getenv("AGENT_TIMEOUT_MS"))
+	if err != nil {
+		agentTimeout = 5000
+	}
+	config.AgentTimeout = time.Duration(agentTimeout) * time.Millisecond
+
+	noCapableAgentWait, err := strconv.Atoi(os.Getenv("NO_CAPABLE_AGENT_WAIT_MS"))
+	if err != nil {
+		noCapableAgentWait = 10000
+	}
+	config.NoCapableAgentWait = time.Duration(noCapableAgentWait) * time.Millisecond
+
+	priorityAging, err := strconv.Atoi(os.Getenv("PRIORITY_AGING_MS"))
+	if err != nil {
+		priorityAging = 10000
+	}
+	config.PriorityAging = time.Duration(priorityAging) * time.Millisecond
+
+	config.UserWeights = parseUserWeights(os.Getenv("USER_WEIGHTS"))
+	config.UserPools = parseUserPools(os.Getenv("USER_POOLS"))
+
+	config.DefaultTimeout = time.Duration(getEnvAsInt("DEFAULT_TIMEOUT_MS")) * time.Millisecond
+	config.MaxTimeout = time.Duration(getEnvAsInt("MAX_TIMEOUT_MS")) * time.Millisecond
+
+	taskLease, err := strconv.Atoi(os.Getenv("TASK_LEASE_MS"))
+	if err != nil {
+		taskLease = 10000
+	}
+	config.TaskLease = time.Duration(taskLease) * time.Millisecond
+
+	config.MaxAttempts, err = strconv.Atoi(os.Getenv("TASK_MAX_ATTEMPTS"))
+	if err != nil || config.MaxAttempts < 1 {
+		config.MaxAttempts = 3
+	}
+
+	retryBackoff, err := strconv.Atoi(os.Getenv("RETRY_BACKOFF_MS"))
+	if err != nil {
+		retryBackoff = 1000
+	}
+	config.RetryBackoff = time.Duration(retryBackoff) * time.Millisecond
+
+	verifyFraction, err := strconv.ParseFloat(os.Getenv("VERIFY_FRACTION"), 64)
+	if err != nil || verifyFraction < 0 {
+		verifyFraction = 0
+	}
+	config.VerifyFraction = min(verifyFraction, 1)
+
+	taskGranularity, err := strconv.Atoi(os.Getenv("TASK_GRANULARITY"))
+	if err != nil || taskGranularity < 1 {
+		taskGranularity = 1
+	}
+	config.TaskGranularity = taskGranularity
+
+	config.InlineMaxOperations = max(getEnvAsInt("INLINE_MAX_OPERATIONS"), 0)
+	config.InlineMaxTime = time.Duration(max(getEnvAsInt("INLINE_MAX_TIME_MS"), 0)) * time.Millisecond
+
+	speculationFactor, err := strconv.ParseFloat(os.Getenv("SPECULATION_FACTOR"), 64)
+	if err != nil || speculationFactor < 0 {
+		speculationFactor = 0
+	}
+	config.SpeculationFactor = speculationFactor
+
+	maxFailureRate, err := strconv.ParseFloat(os.Getenv("AGENT_MAX_FAILURE_RATE"), 64)
+	if err != nil || maxFailureRate <= 0 {
+		maxFailureRate = 0.5
+	}
+	config.MaxFailureRate = maxFailureRate
+
+	quarantine, err := strconv.Atoi(os.Getenv("AGENT_QUARANTINE_MS"))
+	if err != nil {
+		quarantine = 60000
+	}
+	config.Quarantine = time.Duration(quarantine) * time.Millisecond
+
+	if admins := os.Getenv("ADMIN_LOGINS"); admins != "" {
+		config.AdminLogins = strings.Split(admins, ",")
+	}
 	return config
 }
 
+// operationTime возвращает время выполнения операции. Для операций, зарегистрированных
+// после создания конфигурации, используется время из реестра
+func (c *Config) operationTime(name string) time.Duration {
+	if d, ok := c.OperationTimes[name]; ok {
+		return d
+	}
+	if op, ok := operation.Lookup(name); ok {
+		return op.Cost
+	}
+	return 0
+}
+
+// parseUserWeights разбирает веса пользователей, заданные в виде login=weight через запятую
+func parseUserWeights(s string) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		login, strWeight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		weight, err := strconv.ParseFloat(strWeight, 64)
+		if err != nil || weight <= 0 {
+			continue
+		}
+		weights[login] = weight
+	}
+	return weights
+}
+
 // Структура приложения, содержащая конфигурацию
 type Application struct {
-	config *Config
+	config     *Config
+	server     *Server
+	httpServer *http.Server
+	grpcServer *grpc.Server
+	health     *health.Server // Сервис здоровья, по которому агенты выбирают оркестратор
 }
 
 // Функция для создания нового экземпляра приложения
 func New() *Application {
+	return NewWithConfig(ConfigFromEnv())
+}
+
+// Функция для создания нового экземпляра приложения с заданной конфигурацией
+func NewWithConfig(config *Config) *Application {
 	return &Application{
-		config: ConfigFromEnv(),
+		config: config,
 	}
 }
 
 type Server struct {
 	pb.TaskServiceServer // сервис из сгенерированного пакета
+
+	config     *Config
+	mu         sync.Mutex        // Защищает выдачу задач и прием результатов
+	agents     *agentRegistry    // Агенты, обращавшиеся за задачами
+	fair       *fairQueue        // Состояние справедливого распределения задач между пользователями
+	unroutable map[int]time.Time // Время, с которого готовую задачу не может выполнить ни один агент
+	draining   atomic.Bool       // Сервер останавливается и не выдает новых задач
+	stop       chan struct{}     // Закрывается при остановке фоновых проверок
+	metrics    *metrics          // Счетчики для /metrics
 }
 
-func NewServer() *Server {
-	return &Server{}
+func NewServer(config *Config) *Server {
+	agents := newAgentRegistry()
+	agents.maxFailureRate = config.MaxFailureRate
+	agents.quarantine = config.Quarantine
+	return &Server{
+		config:     config,
+		agents:     agents,
+		fair:       newFairQueue(),
+		unroutable: make(map[int]time.Time),
+		stop:       make(chan struct{}),
+		metrics:    newMetrics(),
+	}
 }
 
 // Метод для запуска HTTP-сервера
@@ -95,33 +292,113 @@ func (a *Application) RunServer() error {
 		panic(err)
 	}
 	createTables(context.Background(), db)
+	summary, err := recoverState(context.Background(), db)
 	db.Close()
-	http.HandleFunc("/api/v1/calculate", AddExpressions)
+	if err != nil {
+		return err
+	}
+	log.Println("recovery:", summary)
+	a.server = NewServer(a.config)
+
+	http.HandleFunc("/api/v1/calculate", a.AddExpressions)
 	http.HandleFunc("/api/v1/expressions", GetExpressions)
-	http.HandleFunc("/api/v1/expressions/", GetExpressionByID)
+	http.HandleFunc("/api/v1/expressions/", a.ExpressionByID)
+	http.HandleFunc("/api/v1/functions", GetFunctions)
+	http.HandleFunc("/api/v1/functions/", a.FunctionByName)
 	http.HandleFunc("/api/v1/register", Register)
 	http.HandleFunc("/api/v1/login", Login)
+	http.HandleFunc("/api/v1/admin/dead-letter", a.GetDeadTasks)
+	http.HandleFunc("/api/v1/admin/dead-letter/", a.RequeueDeadTask)
+	http.HandleFunc("/api/v1/admin/mismatches", a.GetMismatches)
+	http.HandleFunc("/api/v1/agents", a.GetAgents)
+	http.HandleFunc("/api/v1/admin/agents/", a.ReleaseAgent)
+	http.HandleFunc("/metrics", a.metricsHandler)
+	a.httpServer = &http.Server{Addr: ":" + a.config.Addr}
 	go func() {
-		if err := http.ListenAndServe(":"+a.config.Addr, nil); err != nil {
+		if err := a.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			panic(err)
 		}
 	}()
 
-	grpcServer := grpc.NewServer()
-	taskServiceServer := NewServer()
-	pb.RegisterTaskServiceServer(grpcServer, taskServiceServer)
-	lis, err := net.Listen("tcp", "localhost:50042")
+	a.grpcServer = grpc.NewServer()
+	pb.RegisterTaskServiceServer(a.grpcServer, a.server)
+	a.health = health.NewServer()
+	a.health.SetServingStatus(pb.TaskService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(a.grpcServer, a.health)
+	lis, err := net.Listen("tcp", a.config.GRPCAddr)
 	if err != nil {
 		return err
 	}
 	go func() {
-		if err := grpcServer.Serve(lis); err != nil {
+		if err := a.grpcServer.Serve(lis); err != nil {
 			panic(err)
 		}
 	}()
+	go a.server.watch()
 	return nil
 }
 
+// Shutdown останавливает оркестратор: перестает принимать выражения и выдавать задачи,
+// ждет результатов уже выданных задач, после чего останавливает gRPC и HTTP серверы.
+// Если ctx завершится раньше, соединения закрываются принудительно
+func (a *Application) Shutdown(ctx context.Context) error {
+	a.server.draining.Store(true)
+	// Агенты с резервным оркестратором переключаются на него, не дожидаясь остановки
+	a.health.Shutdown()
+
+	// Фоновые проверки продолжают работать, чтобы задачи упавших агентов не задерживали остановку
+	if err := waitCalculating(ctx); err != nil {
+		log.Println("shutdown: stopped waiting for in-flight tasks:", err)
+	}
+	close(a.server.stop)
+
+	stopped := make(chan struct{})
+	go func() {
+		a.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		a.grpcServer.Stop()
+	}
+
+	return a.httpServer.Shutdown(ctx)
+}
+
+// waitCalculating ждет, пока агенты не вернут результаты всех выданных задач
+func waitCalculating(ctx context.Context) error {
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n, err := countTasksByStatus(ctx, db, "calculating")
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// querier - общий интерфейс для *sql.DB и *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 func createTables(ctx context.Context, db *sql.DB) error {
 	const (
 		usersTable = `
@@ -138,6 +415,9 @@ func createTables(ctx context.Context, db *sql.DB) error {
   		status TEXT,
   		answer INTEGER,
   		result REAL,
+  		priority INTEGER DEFAULT 1,
+  		deadline INTEGER DEFAULT 0,
+  		error_code TEXT DEFAULT '',
   		FOREIGN KEY (user_id) REFERENCES users(id)
  	);`
 
@@ -150,8 +430,48 @@ func createTables(ctx context.Context, db *sql.DB) error {
   		operation TEXT,
   		status TEXT,
   		result REAL,
+  		priority INTEGER DEFAULT 1,
+  		created_at INTEGER DEFAULT 0,
+  		attempts INTEGER DEFAULT 0,
+  		agent_id TEXT DEFAULT '',
+  		lease_until INTEGER DEFAULT 0,
+  		not_before INTEGER DEFAULT 0,
+  		last_error TEXT DEFAULT '',
+  		started_at INTEGER DEFAULT 0,
+  		speculative_agent_id TEXT DEFAULT '',
+  		speculative_started_at INTEGER DEFAULT 0,
+  		tree TEXT DEFAULT '',
   		FOREIGN KEY (expression_id) REFERENCES expressions(id)
  	);`
+
+		functionsTable = `
+	CREATE TABLE IF NOT EXISTS functions(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER,
+		name TEXT,
+		definition TEXT,
+		UNIQUE (user_id, name),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);`
+
+		taskVotesTable = `
+	CREATE TABLE IF NOT EXISTS task_votes(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER,
+		agent_id TEXT,
+		result REAL,
+		FOREIGN KEY (task_id) REFERENCES tasks(id)
+	);`
+
+		agentMismatchesTable = `
+	CREATE TABLE IF NOT EXISTS agent_mismatches(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		agent_id TEXT,
+		task_id INTEGER,
+		result REAL,
+		accepted REAL,
+		created_at INTEGER
+	);`
 	)
 
 	if _, err := db.ExecContext(ctx, usersTable); err != nil {
@@ -166,10 +486,59 @@ func createTables(ctx context.Context, db *sql.DB) error {
 		return err
 	}
 
+	if _, err := db.ExecContext(ctx, functionsTable); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, taskVotesTable); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, agentMismatchesTable); err != nil {
+		return err
+	}
+
+	// Столбцы, появившиеся после первой версии схемы, добавляются в уже существующую базу
+	columns := []struct {
+		table  string
+		column string
+	}{
+		{"expressions", "priority INTEGER DEFAULT 1"},
+		{"expressions", "deadline INTEGER DEFAULT 0"},
+		{"expressions", "error_code TEXT DEFAULT ''"},
+		{"expressions", "labels TEXT DEFAULT ''"},
+		{"tasks", "priority INTEGER DEFAULT 1"},
+		{"tasks", "created_at INTEGER DEFAULT 0"},
+		{"tasks", "attempts INTEGER DEFAULT 0"},
+		{"tasks", "agent_id TEXT DEFAULT ''"},
+		{"tasks", "lease_until INTEGER DEFAULT 0"},
+		{"tasks", "not_before INTEGER DEFAULT 0"},
+		{"tasks", "last_error TEXT DEFAULT ''"},
+		{"tasks", "started_at INTEGER DEFAULT 0"},
+		{"tasks", "speculative_agent_id TEXT DEFAULT ''"},
+		{"tasks", "speculative_started_at INTEGER DEFAULT 0"},
+		{"tasks", "tree TEXT DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := addColumn(ctx, db, c.table, c.column); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func insertUser(ctx context.Context, db *sql.DB, user User) (int64, error) {
+// addColumn добавляет столбец в таблицу, если его еще нет
+func addColumn(ctx context.Context, db *sql.DB, table string, column string) error {
+	q := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)
+	_, err := db.ExecContext(ctx, q)
+	if err != nil && strings.Contains(err.Error(), "duplicate column name") {
+		return nil
+	}
+	return err
+}
+
+func insertUser(ctx context.Context, db querier, user User) (int64, error) {
 	var q = `
 	INSERT INTO users (login, password) values ($1, $2)
 	`
@@ -185,11 +554,11 @@ func insertUser(ctx context.Context, db *sql.DB, user User) (int64, error) {
 	return id, nil
 }
 
-func insertExpression(ctx context.Context, db *sql.DB, expression Expression) (int, error) {
+func insertExpression(ctx context.Context, db querier, expression Expression) (int, error) {
 	var q = `
-	INSERT INTO expressions (user_id, status, answer, result) values ($1, $2, $3, $4)
+	INSERT INTO expressions (user_id, status, answer, result, priority, deadline, labels) values ($1, $2, $3, $4, $5, $6, $7)
 	`
-	result, err := db.ExecContext(ctx, q, expression.UserID, expression.Status, expression.Answer, expression.Result)
+	result, err := db.ExecContext(ctx, q, expression.UserID, expression.Status, expression.Answer, expression.Result, expression.Priority, expression.Deadline, expression.Labels)
 	if err != nil {
 		return 0, err
 	}
@@ -200,11 +569,12 @@ func insertExpression(ctx context.Context, db *sql.DB, expression Expression) (i
 	return int(id), nil
 }
 
-func insertTask(ctx context.Context, db *sql.DB, task Task) (int, error) {
+func insertTask(ctx context.Context, db querier, task Task) (int, error) {
 	var q = `
-	INSERT INTO tasks (expression_id, arg1, arg2, operation, status, result) values ($1, $2, $3, $4, $5, $6)
+	INSERT INTO tasks (expression_id, arg1, arg2, operation, status, result, priority, created_at, tree)
+	values ($1, $2, $3, $4, $5, $6, (SELECT priority FROM expressions WHERE id = $1), $7, $8)
 	`
-	result, err := db.ExecContext(ctx, q, task.ExpressionID, task.Arg1, task.Arg2, task.Operation, task.Status, task.Result)
+	result, err := db.ExecContext(ctx, q, task.ExpressionID, task.Arg1, task.Arg2, task.Operation, task.Status, task.Result, time.Now().UnixMilli(), task.Tree)
 	if err != nil {
 		return 0, err
 	}
@@ -215,9 +585,9 @@ func insertTask(ctx context.Context, db *sql.DB, task Task) (int, error) {
 	return int(id), nil
 }
 
-func selectExpressionsByUserID(ctx context.Context, db *sql.DB, userID int) ([]Expression, error) {
+func selectExpressionsByUserID(ctx context.Context, db querier, userID int) ([]Expression, error) {
 	var expressions []Expression
-	var q = "SELECT id, user_id, status, answer, result FROM expressions WHERE user_id = ?"
+	var q = "SELECT id, user_id, status, answer, result, priority, deadline, error_code, labels FROM expressions WHERE user_id = ?"
 
 	rows, err := db.QueryContext(ctx, q, userID)
 	if err != nil {
@@ -227,7 +597,7 @@ func selectExpressionsByUserID(ctx context.Context, db *sql.DB, userID int) ([]E
 
 	for rows.Next() {
 		e := Expression{}
-		err := rows.Scan(&e.ID, &e.UserID, &e.Status, &e.Answer, &e.Result)
+		err := rows.Scan(&e.ID, &e.UserID, &e.Status, &e.Answer, &e.Result, &e.Priority, &e.Deadline, &e.ErrorCode, &e.Labels)
 		if err != nil {
 			return nil, err
 		}
@@ -241,9 +611,9 @@ func selectExpressionsByUserID(ctx context.Context, db *sql.DB, userID int) ([]E
 	return expressions, nil
 }
 
-func selectExpressionsByAnswer(ctx context.Context, db *sql.DB, answer int) ([]Expression, error) {
+func selectExpressionsByAnswer(ctx context.Context, db querier, answer int) ([]Expression, error) {
 	var expressions []Expression
-	var q = "SELECT id, user_id, status, answer, result FROM expressions WHERE answer = ?"
+	var q = "SELECT id, user_id, status, answer, result, priority, deadline, error_code, labels FROM expressions WHERE answer = ?"
 
 	rows, err := db.QueryContext(ctx, q, answer)
 	if err != nil {
@@ -253,7 +623,7 @@ func selectExpressionsByAnswer(ctx context.Context, db *sql.DB, answer int) ([]E
 
 	for rows.Next() {
 		e := Expression{}
-		err := rows.Scan(&e.ID, &e.UserID, &e.Status, &e.Answer, &e.Result)
+		err := rows.Scan(&e.ID, &e.UserID, &e.Status, &e.Answer, &e.Result, &e.Priority, &e.Deadline, &e.ErrorCode, &e.Labels)
 		if err != nil {
 			return nil, err
 		}
@@ -267,10 +637,40 @@ func selectExpressionsByAnswer(ctx context.Context, db *sql.DB, answer int) ([]E
 	return expressions, nil
 }
 
-func selectExpressionByID(ctx context.Context, db *sql.DB, id int) (Expression, error) {
+// selectExpiredExpressions возвращает невычисленные выражения, крайний срок которых наступил до now
+func selectExpiredExpressions(ctx context.Context, db querier, now int64) ([]Expression, error) {
+	var expressions []Expression
+	var q = `
+	SELECT id, user_id, status, answer, result, priority, deadline, error_code, labels FROM expressions
+	WHERE deadline > 0 AND deadline <= ? AND status IN ('waiting', ?, ?)
+	`
+
+	rows, err := db.QueryContext(ctx, q, now, statusNoCapableAgent, statusDead)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := Expression{}
+		err := rows.Scan(&e.ID, &e.UserID, &e.Status, &e.Answer, &e.Result, &e.Priority, &e.Deadline, &e.ErrorCode, &e.Labels)
+		if err != nil {
+			return nil, err
+		}
+		expressions = append(expressions, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return expressions, nil
+}
+
+func selectExpressionByID(ctx context.Context, db querier, id int) (Expression, error) {
 	e := Expression{}
-	var q = "SELECT id, user_id, status, answer, result FROM expressions WHERE id = ?"
-	err := db.QueryRowContext(ctx, q, id).Scan(&e.ID, &e.UserID, &e.Status, &e.Answer, &e.Result)
+	var q = "SELECT id, user_id, status, answer, result, priority, deadline, error_code, labels FROM expressions WHERE id = ?"
+	err := db.QueryRowContext(ctx, q, id).Scan(&e.ID, &e.UserID, &e.Status, &e.Answer, &e.Result, &e.Priority, &e.Deadline, &e.ErrorCode, &e.Labels)
 	if err != nil {
 		return e, err
 	}
@@ -278,9 +678,13 @@ func selectExpressionByID(ctx context.Context, db *sql.DB, id int) (Expression,
 	return e, nil
 }
 
-func selectTasks(ctx context.Context, db *sql.DB) ([]Task, error) {
+func selectTasks(ctx context.Context, db querier) ([]Task, error) {
 	var tasks []Task
-	var q = "SELECT id, expression_id, arg1, arg2, operation, status, result FROM tasks"
+	var q = `
+	SELECT t.id, t.expression_id, t.arg1, t.arg2, t.operation, t.status, t.result, t.priority, t.created_at, COALESCE(e.user_id, 0), COALESCE(e.labels, ''),
+		t.attempts, t.agent_id, t.lease_until, t.not_before, t.last_error, t.started_at, t.speculative_agent_id, t.speculative_started_at, t.tree
+	FROM tasks t LEFT JOIN expressions e ON e.id = t.expression_id
+	`
 
 	rows, err := db.QueryContext(ctx, q)
 	if err != nil {
@@ -290,7 +694,8 @@ func selectTasks(ctx context.Context, db *sql.DB) ([]Task, error) {
 
 	for rows.Next() {
 		t := Task{}
-		err := rows.Scan(&t.ID, &t.ExpressionID, &t.Arg1, &t.Arg2, &t.Operation, &t.Status, &t.Result)
+		err := rows.Scan(&t.ID, &t.ExpressionID, &t.Arg1, &t.Arg2, &t.Operation, &t.Status, &t.Result, &t.Priority, &t.CreatedAt, &t.UserID, &t.Labels,
+			&t.Attempts, &t.AgentID, &t.LeaseUntil, &t.NotBefore, &t.LastError, &t.StartedAt, &t.SpeculativeAgentID, &t.SpeculativeStartedAt, &t.Tree)
 		if err != nil {
 			return nil, err
 		}
@@ -300,7 +705,17 @@ func selectTasks(ctx context.Context, db *sql.DB) ([]Task, error) {
 	return tasks, nil
 }
 
-func deleteTask(ctx context.Context, db *sql.DB, taskID int) error {
+func countTasksByStatus(ctx context.Context, db querier, status string) (int, error) {
+	var n int
+	q := "SELECT COUNT(*) FROM tasks WHERE status = $1"
+	err := db.QueryRowContext(ctx, q, status).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func deleteTask(ctx context.Context, db querier, taskID int) error {
 	q := "DELETE FROM tasks WHERE id = ?"
 	_, err := db.ExecContext(ctx, q, taskID)
 	if err != nil {
@@ -309,7 +724,7 @@ func deleteTask(ctx context.Context, db *sql.DB, taskID int) error {
 	return nil
 }
 
-func updateTaskField(ctx context.Context, db *sql.DB, id int, field string, value interface{}) error {
+func updateTaskField(ctx context.Context, db querier, id int, field string, value interface{}) error {
 	q := fmt.Sprintf("UPDATE tasks SET %s = $1 WHERE id = $2", field)
 	_, err := db.ExecContext(ctx, q, value, id)
 	if err != nil {
@@ -318,17 +733,45 @@ func updateTaskField(ctx context.Context, db *sql.DB, id int, field string, valu
 	return nil
 }
 
-func selectTaskByID(ctx context.Context, db *sql.DB, id int) (Task, error) {
+func selectTaskByID(ctx context.Context, db querier, id int) (Task, error) {
 	t := Task{}
-	var q = "SELECT id, expression_id, arg1, arg2, operation, status, result FROM tasks WHERE id = $1"
-	err := db.QueryRowContext(ctx, q, id).Scan(&t.ID, &t.ExpressionID, &t.Arg1, &t.Arg2, &t.Operation, &t.Status, &t.Result)
+	var q = `
+	SELECT id, expression_id, arg1, arg2, operation, status, result, priority, created_at,
+		attempts, agent_id, lease_until, not_before, last_error, started_at, speculative_agent_id, speculative_started_at, tree
+	FROM tasks WHERE id = $1
+	`
+	err := db.QueryRowContext(ctx, q, id).Scan(&t.ID, &t.ExpressionID, &t.Arg1, &t.Arg2, &t.Operation, &t.Status, &t.Result, &t.Priority, &t.CreatedAt,
+		&t.Attempts, &t.AgentID, &t.LeaseUntil, &t.NotBefore, &t.LastError, &t.StartedAt, &t.SpeculativeAgentID, &t.SpeculativeStartedAt, &t.Tree)
 	if err != nil {
 		return t, err
 	}
 	return t, nil
 }
 
-func updateExpressionField(ctx context.Context, db *sql.DB, id int, field string, value interface{}) error {
+// markTaskCalculating выдает задачу агенту в startedAt до leaseUntil. Вычисленные аргументы подставляются в задачу,
+// чтобы ее можно было выдать повторно после удаления задач-аргументов
+func markTaskCalculating(ctx context.Context, db querier, id int, arg1 float64, arg2 float64, agentID string, startedAt int64, leaseUntil int64) error {
+	q := `UPDATE tasks SET status = 'calculating', arg1 = $1, arg2 = $2, agent_id = $3, started_at = $4, lease_until = $5,
+		speculative_agent_id = '', speculative_started_at = 0 WHERE id = $6`
+	_, err := db.ExecContext(ctx, q, strconv.FormatFloat(arg1, 'f', -1, 64), strconv.FormatFloat(arg2, 'f', -1, 64), agentID, startedAt, leaseUntil, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// updateTaskRetry сохраняет неудачную попытку вычисления задачи
+func updateTaskRetry(ctx context.Context, db querier, id int, status string, attempts int, notBefore int64, lastError string) error {
+	q := `UPDATE tasks SET status = $1, attempts = $2, not_before = $3, last_error = $4, agent_id = '', lease_until = 0,
+		started_at = 0, speculative_agent_id = '', speculative_started_at = 0 WHERE id = $5`
+	_, err := db.ExecContext(ctx, q, status, attempts, notBefore, lastError, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func updateExpressionField(ctx context.Context, db querier, id int, field string, value interface{}) error {
 	q := fmt.Sprintf("UPDATE expressions SET %s = $1 WHERE id = $2", field)
 	_, err := db.ExecContext(ctx, q, value, id)
 	if err != nil {
@@ -337,7 +780,7 @@ func updateExpressionField(ctx context.Context, db *sql.DB, id int, field string
 	return nil
 }
 
-func deleteTasksByExpressionID(ctx context.Context, db *sql.DB, expressionID int) error {
+func deleteTasksByExpressionID(ctx context.Context, db querier, expressionID int) error {
 	q := "DELETE FROM tasks WHERE expression_id = $1"
 	_, err := db.ExecContext(ctx, q, expressionID)
 	if err != nil {
@@ -346,7 +789,7 @@ func deleteTasksByExpressionID(ctx context.Context, db *sql.DB, expressionID int
 	return nil
 }
 
-func getUserByLogin(ctx context.Context, db *sql.DB, login string) (User, error) {
+func getUserByLogin(ctx context.Context, db querier, login string) (User, error) {
 	u := User{}
 	var q = "SELECT id, login, password FROM users WHERE login = $1"
 	err := db.QueryRowContext(ctx, q, login).Scan(&u.ID, &u.Login, &u.Password)
@@ -356,6 +799,16 @@ func getUserByLogin(ctx context.Context, db *sql.DB, login string) (User, error)
 	return u, nil
 }
 
+func getUserByID(ctx context.Context, db querier, id int) (User, error) {
+	u := User{}
+	var q = "SELECT id, login, password FROM users WHERE id = $1"
+	err := db.QueryRowContext(ctx, q, id).Scan(&u.ID, &u.Login, &u.Password)
+	if err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
 func sendError(w http.ResponseWriter, code int) {
 	var errorMessage string
 	switch code {
@@ -369,10 +822,14 @@ func sendError(w http.ResponseWriter, code int) {
 		errorMessage = "Not Found"
 	case http.StatusMethodNotAllowed: // 405
 		errorMessage = "Method Not Allowed"
+	case http.StatusConflict: // 409
+		errorMessage = "Conflict"
 	case http.StatusUnprocessableEntity: // 422
 		errorMessage = "Unprocessable Entity"
 	case http.StatusInternalServerError: // 500
 		errorMessage = "Internal Server Error"
+	case http.StatusServiceUnavailable: // 503
+		errorMessage = "Service Unavailable"
 	default:
 		errorMessage = "Unknown error"
 	}
@@ -382,10 +839,14 @@ func sendError(w http.ResponseWriter, code int) {
 	json.NewEncoder(w).Encode(map[string]string{"error": errorMessage})
 }
 
-func AddExpressions(w http.ResponseWriter, r *http.Request) {
+func (a *Application) AddExpressions(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Expression string `json:"expression"`
+		Expression string            `json:"expression"`
+		Priority   Priority          `json:"priority"`
+		TimeoutMs  *int64            `json:"timeout_ms"`
+		Labels     map[string]string `json:"labels"`
 	}
+	input.Priority = PriorityNormal
 	token := r.Header.Get("Authorization")
 	user, err := getUserFromToken(token)
 	if err != nil {
@@ -393,7 +854,36 @@ func AddExpressions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if a.server.draining.Load() {
+		sendError(w, 503)
+		return
+	}
+
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		if errors.Is(err, errInvalidPriority) {
+			sendError(w, 400)
+			return
+		}
+		sendError(w, 422)
+		return
+	}
+
+	timeout, err := a.config.expressionTimeout(input.TimeoutMs)
+	if err != nil {
+		sendError(w, 422)
+		return
+	}
+	var deadline int64
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout).UnixMilli()
+	}
+
+	labels, err := a.config.expressionLabels(user.Login, input.Labels)
+	if err == errPoolForbidden {
+		sendError(w, 403)
+		return
+	}
+	if err != nil {
 		sendError(w, 422)
 		return
 	}
@@ -404,14 +894,38 @@ func AddExpressions(w http.ResponseWriter, r *http.Request) {
 	}
 	defer db.Close()
 
-	id, err := insertExpression(context.Background(), db, Expression{UserID: user.ID, Status: "waiting"})
+	// Выражение разбирается до сохранения, чтобы от невалидного выражения не оставалось задач
+	node, err := a.server.parseExpression(context.Background(), db, user.ID, input.Expression)
+	if err != nil {
+		sendError(w, 422)
+		return
+	}
+
+	expression := Expression{UserID: user.ID, Status: "waiting", Priority: int(input.Priority), Deadline: deadline, Labels: labels}
+	// Небольшое выражение быстрее вычислить сразу, чем разбивать на задачи и ждать агентов
+	if a.server.inlineable(node) {
+		id, err := insertInlineExpression(context.Background(), db, expression, node)
+		if err != nil {
+			sendError(w, 500)
+			return
+		}
+		a.server.metrics.expression(pathInline)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": id,
+		})
+		return
+	}
+
+	id, err := insertExpression(context.Background(), db, expression)
 	if err != nil {
 		sendError(w, 500)
 		return
 	}
-	result, err := Calc(input.Expression, id)
+	result, err := insertTaskChunks(context.Background(), db, id, node, a.config.TaskGranularity)
 	if err != nil {
-		sendError(w, 422)
+		sendError(w, 500)
 		return
 	}
 	if strings.HasPrefix(result, "id") {
@@ -421,6 +935,7 @@ func AddExpressions(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		updateExpressionField(context.Background(), db, id, "answer", ans)
+		a.server.metrics.expression(pathTasks)
 	} else {
 		result, err := strconv.ParseFloat(result, 64)
 		if err != nil {
@@ -430,6 +945,7 @@ func AddExpressions(w http.ResponseWriter, r *http.Request) {
 		updateExpressionField(context.Background(), db, id, "status", "complete")
 		updateExpressionField(context.Background(), db, id, "answer", 0)
 		updateExpressionField(context.Background(), db, id, "result", result)
+		a.server.metrics.expression(pathInline)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -454,9 +970,10 @@ func GetExpressions(w http.ResponseWriter, r *http.Request) {
 
 	response := struct {
 		Expressions []struct {
-			ID     int     `json:"id"`
-			Status string  `json:"status"`
-			Result float64 `json:"result"`
+			ID        int     `json:"id"`
+			Status    string  `json:"status"`
+			Result    float64 `json:"result"`
+			ErrorCode string  `json:"error_code,omitempty"`
 		} `json:"expressions"`
 	}{}
 
@@ -467,13 +984,15 @@ func GetExpressions(w http.ResponseWriter, r *http.Request) {
 	}
 	for _, expr := range expressions {
 		response.Expressions = append(response.Expressions, struct {
-			ID     int     `json:"id"`
-			Status string  `json:"status"`
-			Result float64 `json:"result"`
+			ID        int     `json:"id"`
+			Status    string  `json:"status"`
+			Result    float64 `json:"result"`
+			ErrorCode string  `json:"error_code,omitempty"`
 		}{
-			ID:     expr.ID,
-			Status: expr.Status,
-			Result: expr.Result,
+			ID:        expr.ID,
+			Status:    expr.Status,
+			Result:    expr.Result,
+			ErrorCode: expr.ErrorCode,
 		})
 	}
 
@@ -482,6 +1001,18 @@ func GetExpressions(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// ExpressionByID направляет запрос к выражению по его идентификатору в обработчик для метода запроса
+func (a *Application) ExpressionByID(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		GetExpressionByID(w, r)
+	case http.MethodDelete:
+		a.CancelExpression(w, r)
+	default:
+		sendError(w, 405)
+	}
+}
+
 func GetExpressionByID(w http.ResponseWriter, r *http.Request) {
 	idStr := r.URL.Path[len("/api/v1/expressions/"):]
 	id, err := strconv.Atoi(idStr)
@@ -505,12 +1036,16 @@ func GetExpressionByID(w http.ResponseWriter, r *http.Request) {
 			sendError(w, 403)
 			return
 		}
+		expression := map[string]interface{}{
+			"id":     expr.ID,
+			"status": expr.Status,
+			"result": expr.Result,
+		}
+		if expr.ErrorCode != "" {
+			expression["error_code"] = expr.ErrorCode
+		}
 		response := map[string]interface{}{
-			"expression": map[string]interface{}{
-				"id":     expr.ID,
-				"status": expr.Status,
-				"result": expr.Result,
-			},
+			"expression": expression,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -521,6 +1056,88 @@ func GetExpressionByID(w http.ResponseWriter, r *http.Request) {
 	sendError(w, 404)
 }
 
+// CancelExpression отменяет вычисление выражения: удаляет его задачи, а агенты,
+// вычисляющие эти задачи, узнают об отмене из ответа на Heartbeat
+func (a *Application) CancelExpression(w http.ResponseWriter, r *http.Request) {
+	idStr := r.URL.Path[len("/api/v1/expressions/"):]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		sendError(w, 404)
+		return
+	}
+
+	token := r.Header.Get("Authorization")
+	user, err := getUserFromToken(token)
+	if err != nil {
+		sendError(w, 403)
+		return
+	}
+
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		sendError(w, 500)
+		return
+	}
+	defer db.Close()
+
+	switch err := a.server.cancelExpression(context.Background(), db, user.ID, id); err {
+	case nil:
+	case sql.ErrNoRows:
+		sendError(w, 404)
+		return
+	case errNotOwner:
+		sendError(w, 403)
+		return
+	case errNotPending:
+		sendError(w, 409)
+		return
+	default:
+		sendError(w, 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "OK"})
+}
+
+var (
+	errNotOwner   = errors.New("expression belongs to another user")
+	errNotPending = errors.New("expression is already finished")
+)
+
+// cancelExpression удаляет задачи выражения и помечает его отмененным. Выполняется под s.mu
+// в одной транзакции, поэтому не пересекается с выдачей задач и приемом результатов:
+// статус проверяется заново внутри транзакции, а выданные задачи агенты отменят по Heartbeat
+func (s *Server) cancelExpression(ctx context.Context, db *sql.DB, userID int, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	expression, err := selectExpressionByID(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if expression.UserID != userID {
+		return errNotOwner
+	}
+	if !pending(expression) {
+		return errNotPending
+	}
+	if err := deleteTasksByExpressionID(ctx, tx, id); err != nil {
+		return err
+	}
+	if err := updateExpressionField(ctx, tx, id, "status", "cancelled"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (s *Server) GetTask(
 	ctx context.Context,
 	in *pb.GetTaskRequest,
@@ -531,71 +1148,161 @@ func (s *Server) GetTask(
 	}
 	defer db.Close()
 
-	tasks, err := selectTasks(context.Background(), db)
+	s.agents.seen(in.Agent)
+	if s.draining.Load() {
+		return nil, status.Error(codes.Unavailable, "Service Unavailable")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.admitAgent(context.Background(), db, in.Agent.GetId(), 1); err != nil {
+		return nil, err
+	}
+	tasks, err := s.claimTasks(context.Background(), db, in.Agent, 1)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "Internal Server Error")
 	}
-	for _, task := range tasks {
-		if task.Status == "waiting" {
-			p1, arg1, err := getResult(task.Arg1)
-			if err != nil {
-				continue
-			}
-			p2, arg2, err := getResult(task.Arg2)
-			if err != nil {
-				continue
-			}
-			response := &pb.GetTaskResponse{
-				Task: &pb.Task{
-					Id:            int64(task.ID),
-					Arg1:          float32(arg1),
-					Arg2:          float32(arg2),
-					Operation:     task.Operation,
-					OperationTime: int64(getOperationTime(task.Operation)),
-				},
-			}
-			updateTaskField(context.Background(), db, task.ID, "status", "calculating")
-			if p1 >= 0 {
-				deleteTask(context.Background(), db, p1)
-			}
-			if p2 >= 0 {
-				deleteTask(context.Background(), db, p2)
-			}
-			return response, nil
-		}
+	if len(tasks) == 0 {
+		return nil, status.Error(codes.NotFound, "Not Found")
 	}
-	return nil, status.Error(codes.NotFound, "Not Found")
+	return &pb.GetTaskResponse{Task: tasks[0]}, nil
 }
 
-func getOperationTime(operation string) int {
-	switch operation {
-	case "+":
-		return getEnvAsInt("TIME_ADDITION_MS")
-	case "-":
-		return getEnvAsInt("TIME_SUBTRACTION_MS")
-	case "*":
-		return getEnvAsInt("TIME_MULTIPLICATIONS_MS")
-	case "/":
-		return getEnvAsInt("TIME_DIVISIONS_MS")
-	default:
-		return 0
+// GetTasks выдает агенту до max_n готовых задач за один запрос
+func (s *Server) GetTasks(
+	ctx context.Context,
+	in *pb.GetTasksRequest,
+) (*pb.GetTasksResponse, error) {
+	if in.MaxN <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "Invalid Argument")
 	}
-}
 
-func getResult(input string) (int, float64, error) {
 	db, err := sql.Open("sqlite3", "store.db")
 	if err != nil {
-		return -1, 0, err
+		return nil, status.Error(codes.Internal, "Internal Server Error")
 	}
 	defer db.Close()
 
+	s.agents.seen(in.Agent)
+	if s.draining.Load() {
+		return nil, status.Error(codes.Unavailable, "Service Unavailable")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Задачи захватываются в одной транзакции, чтобы пакет выдавался целиком или не выдавался вовсе
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	n, err := s.admitAgent(context.Background(), tx, in.Agent.GetId(), int(in.MaxN))
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, queued, err := s.claimReadyTasks(context.Background(), tx, in.Agent, n)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal Server Error")
+	}
+	if len(tasks) == 0 {
+		return nil, status.Error(codes.NotFound, "Not Found")
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, status.Error(codes.Internal, "Internal Server Error")
+	}
+	return &pb.GetTasksResponse{Tasks: tasks, QueueDepth: int32(queued)}, nil
+}
+
+// claimTasks находит до n задач, аргументы которых уже вычислены, а операцию может выполнить агент,
+// и помечает их как вычисляемые. Задачи выдаются в порядке приоритета с учетом времени ожидания,
+// а задачи одного приоритета делятся между пользователями пропорционально их весам
+func (s *Server) claimTasks(ctx context.Context, db querier, agent *pb.AgentInfo, n int) ([]*pb.Task, error) {
+	tasks, _, err := s.claimReadyTasks(ctx, db, agent, n)
+	return tasks, err
+}
+
+// claimReadyTasks работает как claimTasks и дополнительно возвращает количество готовых задач,
+// которые агент мог бы выполнить, но которые остались в очереди. По нему агент подбирает число горутин
+func (s *Server) claimReadyTasks(ctx context.Context, db querier, agent *pb.AgentInfo, n int) ([]*pb.Task, int, error) {
+	tasks, err := selectTasks(ctx, db)
+	if err != nil {
+		return nil, 0, err
+	}
+	voters, err := taskVoters(ctx, db)
+	if err != nil {
+		return nil, 0, err
+	}
+	now := time.Now()
+	var candidates []candidate
+	for _, task := range tasks {
+		if task.Status != "waiting" || task.NotBefore > now.UnixMilli() {
+			continue
+		}
+		c, err := resolveTask(ctx, db, task)
+		if err != nil || !c.runnableBy(agent) {
+			continue
+		}
+		// Проверяемую задачу повторно вычисляет только другой агент, даже если других подходящих агентов нет
+		if voters[task.ID][agent.GetId()] {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	weights := make(map[int]float64)
+	for _, c := range candidates {
+		if _, ok := weights[c.task.UserID]; ok {
+			continue
+		}
+		weights[c.task.UserID] = 1
+		if user, err := getUserByID(ctx, db, c.task.UserID); err == nil {
+			if weight, ok := s.config.UserWeights[user.Login]; ok {
+				weights[c.task.UserID] = weight
+			}
+		}
+	}
+
+	var claimed []*pb.Task
+	for _, c := range s.fair.pick(candidates, weights, n, now, s.config.PriorityAging) {
+		message, err := s.taskMessage(c)
+		if err != nil {
+			return nil, 0, err
+		}
+		leaseUntil := now.Add(time.Duration(message.OperationTime)*time.Millisecond + s.config.TaskLease).UnixMilli()
+		if err := markCandidateCalculating(ctx, db, c, agent.GetId(), now.UnixMilli(), leaseUntil); err != nil {
+			return nil, 0, err
+		}
+		// Результаты аргументов подставлены в задачу, поэтому задачи-аргументы больше не нужны
+		for _, dep := range c.deps {
+			if err := deleteTask(ctx, db, dep); err != nil {
+				return nil, 0, err
+			}
+		}
+		claimed = append(claimed, message)
+	}
+	queued := len(candidates) - len(claimed)
+	// Агент, которому не хватило готовых задач, вычисляет копии задач, застрявших у медленных агентов
+	if len(claimed) < n {
+		speculative, err := s.claimStragglers(ctx, db, agent, tasks, n-len(claimed), now)
+		if err != nil {
+			return nil, 0, err
+		}
+		claimed = append(claimed, speculative...)
+	}
+	return claimed, queued, nil
+}
+
+func getResult(ctx context.Context, db querier, input string) (int, float64, error) {
 	if strings.HasPrefix(input, "id") {
 		id, err := strconv.Atoi(strings.TrimPrefix(input, "id"))
 		if err != nil {
 			return -1, 0, err
 		}
 
-		task, err := selectTaskByID(context.Background(), db, id)
+		task, err := selectTaskByID(ctx, db, id)
 		if err != nil {
 			return -1, 0, err
 		}
@@ -632,41 +1339,230 @@ func (s *Server) PostResult(
 	}
 	defer db.Close()
 
-	task, err := selectTaskByID(context.Background(), db, int(in.Id))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.applyResult(context.Background(), db, "", in); err != nil {
+		return nil, err
+	}
+	return &pb.PostResultResponse{
+		Status: "OK",
+	}, nil
+}
+
+// PostResults принимает несколько результатов и применяет их в одной транзакции
+func (s *Server) PostResults(
+	ctx context.Context,
+	in *pb.PostResultsRequest,
+) (*pb.PostResultsResponse, error) {
+	db, err := sql.Open("sqlite3", "store.db")
 	if err != nil {
-		return nil, status.Error(codes.NotFound, "Not Found")
+		return nil, status.Error(codes.Internal, "Internal Server Error")
+	}
+	defer db.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal Server Error")
+	}
+	defer tx.Rollback()
+
+	response := &pb.PostResultsResponse{}
+	for _, result := range in.Results {
+		// Неизвестная задача не должна отменять прием остальных результатов пакета
+		err := s.applyResult(context.Background(), tx, in.Agent.GetId(), result)
+		if status.Code(err) == codes.Internal {
+			return nil, err
+		}
+		if err != nil {
+			response.Statuses = append(response.Statuses, &pb.PostResultResponse{Status: status.Convert(err).Message()})
+			continue
+		}
+		response.Statuses = append(response.Statuses, &pb.PostResultResponse{Status: "OK"})
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, status.Error(codes.Internal, "Internal Server Error")
+	}
+	return response, nil
+}
+
+// applyResult сохраняет результат задачи и завершает выражение, если задача была последней.
+// Временная ошибка возвращает задачу в очередь, остальные ошибки завершают выражение.
+// agentID - агент, приславший результат; пустой, если агент неизвестен
+func (s *Server) applyResult(ctx context.Context, db querier, agentID string, in *pb.PostResultRequest) error {
+	task, err := selectTaskByID(ctx, db, int(in.Id))
+	if err != nil {
+		return status.Error(codes.NotFound, "Not Found")
+	}
+	// Повторный результат уже вычисленной задачи, например после повторной отправки агентом, не меняет ее
+	if task.Status == "complete" {
+		return nil
+	}
+	// Задача в dead letter ждет ручного перезапуска, и поздний результат агента ее не завершает
+	if task.Status == statusDead {
+		return nil
+	}
+	if agentID == "" {
+		agentID = task.AgentID
+	}
+	if in.Error != "" && in.Retryable {
+		s.agents.record(agentID, outcomeError)
+		// Если у задачи есть вторая копия, ее результат еще может прийти
+		dropped, err := s.dropTaskCopy(ctx, db, task, agentID)
+		if err != nil {
+			return status.Error(codes.Internal, "Internal Server Error")
+		}
+		if dropped {
+			return nil
+		}
+		if err := s.retryTask(ctx, db, task, in.Error); err != nil {
+			return status.Error(codes.Internal, "Internal Server Error")
+		}
+		return nil
+	}
+	// Агенты, не проверяющие результат, могут прислать бесконечность или NaN как обычное значение
+	if in.Error == "" {
+		in = checkFinite(in)
 	}
 	if in.Error != "" {
-		setError(task.ExpressionID, in.Error)
-	} else {
-		updateTaskField(context.Background(), db, task.ID, "result", in.Result)
-		updateTaskField(context.Background(), db, task.ID, "status", "complete")
-		expressions, err := selectExpressionsByAnswer(context.Background(), db, int(in.Id))
+		// Ошибка в аргументах - не вина агента, а операцию, о поддержке которой он сообщил, он выполнить должен
+		if in.ErrorCode == pb.ErrorCode_ERROR_CODE_UNSUPPORTED_OPERATION {
+			s.agents.record(agentID, outcomeError)
+		}
+		if err := setComputationError(ctx, db, task.ExpressionID, errorCodeName(in.ErrorCode), in.Error); err != nil {
+			return status.Error(codes.Internal, "Internal Server Error")
+		}
+		return nil
+	}
+	current := task.Status == "calculating" && task.computedBy(agentID)
+	accepted, err := s.verifyResult(ctx, db, task, agentID, current, float64(in.Result))
+	if err != nil {
+		return status.Error(codes.Internal, "Internal Server Error")
+	}
+	if !accepted {
+		return nil
+	}
+	if current {
+		s.agents.record(agentID, outcomeSuccess)
+		s.recordTaskTime(task, agentID)
+	}
+	if err := updateTaskField(ctx, db, task.ID, "result", in.Result); err != nil {
+		return status.Error(codes.Internal, "Internal Server Error")
+	}
+	if err := updateTaskField(ctx, db, task.ID, "status", "complete"); err != nil {
+		return status.Error(codes.Internal, "Internal Server Error")
+	}
+	expressions, err := selectExpressionsByAnswer(ctx, db, int(in.Id))
+	if err != nil {
+		return status.Error(codes.NotFound, "Not Found")
+	}
+	now := time.Now().UnixMilli()
+	for _, expression := range expressions {
+		// Результат для отмененного, просроченного или завершенного с ошибкой выражения отбрасывается
+		if expression.Status != "waiting" && expression.Status != statusNoCapableAgent {
+			continue
+		}
+		// Крайний срок мог пройти до очередной проверки checkDeadlines
+		if expression.Deadline > 0 && expression.Deadline <= now {
+			if err := setError(ctx, db, expression.ID, "timeout"); err != nil {
+				return status.Error(codes.Internal, "Internal Server Error")
+			}
+			continue
+		}
+		if err := deleteTask(ctx, db, task.ID); err != nil {
+			return status.Error(codes.Internal, "Internal Server Error")
+		}
+		if err := updateExpressionField(ctx, db, expression.ID, "answer", -1); err != nil {
+			return status.Error(codes.Internal, "Internal Server Error")
+		}
+		if err := updateExpressionField(ctx, db, expression.ID, "result", in.Result); err != nil {
+			return status.Error(codes.Internal, "Internal Server Error")
+		}
+		if err := updateExpressionField(ctx, db, expression.ID, "status", "complete"); err != nil {
+			return status.Error(codes.Internal, "Internal Server Error")
+		}
+	}
+	return nil
+}
+
+// Heartbeat продлевает аренду вычисляемых агентом задач и сообщает, какие из них больше не нужны:
+// задача отменена, если ее удалили, вернули в очередь или выдали другому агенту
+func (s *Server) Heartbeat(
+	ctx context.Context,
+	in *pb.HeartbeatRequest,
+) (*pb.HeartbeatResponse, error) {
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Internal Server Error")
+	}
+	defer db.Close()
+
+	s.agents.seen(in.Agent)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	response := &pb.HeartbeatResponse{}
+	leaseUntil := time.Now().Add(s.config.TaskLease).UnixMilli()
+	for _, id := range in.TaskIds {
+		task, err := selectTaskByID(context.Background(), db, int(id))
+		if err == sql.ErrNoRows || (err == nil && (task.Status != "calculating" || !task.computedBy(in.Agent.GetId()))) {
+			response.CancelledIds = append(response.CancelledIds, id)
+			continue
+		}
 		if err != nil {
-			return nil, status.Error(codes.NotFound, "Not Found")
+			return nil, status.Error(codes.Internal, "Internal Server Error")
 		}
-		for _, expression := range expressions {
-			deleteTask(context.Background(), db, task.ID)
-			updateExpressionField(context.Background(), db, expression.ID, "answer", -1)
-			updateExpressionField(context.Background(), db, expression.ID, "result", in.Result)
-			updateExpressionField(context.Background(), db, expression.ID, "status", "complete")
+		// Агент жив и продолжает вычисление, поэтому аренда продлевается
+		if err := updateTaskField(context.Background(), db, task.ID, "lease_until", leaseUntil); err != nil {
+			return nil, status.Error(codes.Internal, "Internal Server Error")
 		}
 	}
-	return &pb.PostResultResponse{
-		Status: "OK",
-	}, nil
+	return response, nil
 }
 
-func setError(id int, error_text string) {
+// ReleaseTasks возвращает в очередь задачи, которые агент получил, но не будет вычислять.
+// Возврат не считается неудачной попыткой
+func (s *Server) ReleaseTasks(
+	ctx context.Context,
+	in *pb.ReleaseTasksRequest,
+) (*pb.ReleaseTasksResponse, error) {
 	db, err := sql.Open("sqlite3", "store.db")
 	if err != nil {
-		return
+		return nil, status.Error(codes.Internal, "Internal Server Error")
 	}
 	defer db.Close()
 
-	deleteTasksByExpressionID(context.Background(), db, id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	updateExpressionField(context.Background(), db, id, "status", "error: "+error_text)
+	for _, id := range in.TaskIds {
+		task, err := selectTaskByID(context.Background(), db, int(id))
+		if err != nil || task.Status != "calculating" || !task.computedBy(in.Agent.GetId()) {
+			continue
+		}
+		// Задачу продолжает вычислять агент с другой копией
+		if dropped, err := s.dropTaskCopy(context.Background(), db, task, in.Agent.GetId()); err != nil {
+			return nil, status.Error(codes.Internal, "Internal Server Error")
+		} else if dropped {
+			continue
+		}
+		if err := updateTaskRetry(context.Background(), db, task.ID, "waiting", task.Attempts, 0, task.LastError); err != nil {
+			return nil, status.Error(codes.Internal, "Internal Server Error")
+		}
+	}
+	return &pb.ReleaseTasksResponse{Status: "OK"}, nil
+}
+
+func setError(ctx context.Context, db querier, id int, error_text string) error {
+	if err := deleteTasksByExpressionID(ctx, db, id); err != nil {
+		return err
+	}
+
+	return updateExpressionField(ctx, db, id, "status", "error: "+error_text)
 }
 
 func generate(s string) (string, error) {
@@ -751,16 +1647,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	const hmacSampleSecret = "super_secret_signature"
-	now := time.Now()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"name": user.Login,
-		"nbf":  now.Unix(),
-		"exp":  now.Add(24 * time.Hour).Unix(),
-		"iat":  now.Unix(),
-	})
-
-	tokenString, err := token.SignedString([]byte(hmacSampleSecret))
+	tokenString, err := signToken(user.Login)
 	if err != nil {
 		sendError(w, 500)
 		return
@@ -775,6 +1662,19 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// signToken выдает пользователю токен на 24 часа
+func signToken(login string) (string, error) {
+	const hmacSampleSecret = "super_secret_signature"
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"name": login,
+		"nbf":  now.Unix(),
+		"exp":  now.Add(24 * time.Hour).Unix(),
+		"iat":  now.Unix(),
+	})
+	return token.SignedString([]byte(hmacSampleSecret))
+}
+
 func getUserFromToken(tokenString string) (User, error) {
 	db, err := sql.Open("sqlite3", "store.db")
 	if err != nil {
@@ -0,0 +1,241 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
+	pb "github.com/f1rsov08/go_calc_2/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestDB создает пустую базу во временной директории и делает эту директорию текущей,
+// так как оркестратор открывает store.db по относительному пути
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := createTables(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestUser создает пользователя и возвращает его идентификатор
+func newTestUser(t *testing.T, db *sql.DB, login string) int {
+	t.Helper()
+	id, err := insertUser(context.Background(), db, User{Login: login, Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(id)
+}
+
+// newTestExpression создает выражение пользователя с n независимыми готовыми задачами
+func newTestExpression(t *testing.T, db *sql.DB, userID int, n int) int {
+	t.Helper()
+	ctx := context.Background()
+	id, err := insertExpression(ctx, db, Expression{UserID: userID, Status: "waiting", Priority: int(PriorityNormal)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		_, err := insertTask(ctx, db, Task{ExpressionID: id, Arg1: "1", Arg2: "2", Operation: "+", Status: "waiting"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+// submitTestExpression разбивает выражение на задачи по одной операции, как AddExpressions по умолчанию
+func submitTestExpression(t *testing.T, db *sql.DB, userID int, expression string) int {
+	t.Helper()
+	return submitTestChunks(t, db, userID, expression, 1)
+}
+
+// submitTestChunks добавляет выражение, задачи которого вычисляют до granularity операций
+func submitTestChunks(t *testing.T, db *sql.DB, userID int, expression string, granularity int) int {
+	t.Helper()
+	ctx := context.Background()
+	id, err := insertExpression(ctx, db, Expression{UserID: userID, Status: "waiting", Priority: int(PriorityNormal)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := calculation.Parse(expression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := insertTaskChunks(ctx, db, id, node, granularity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ans, err := strconv.Atoi(strings.TrimPrefix(result, "id"))
+	if err != nil {
+		t.Fatalf("Expected expression %q to be split into tasks, but got %q", expression, result)
+	}
+	if err := updateExpressionField(ctx, db, id, "answer", ans); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// computeAll выдает и вычисляет задачи, пока они не закончатся, как это делал бы агент
+func computeAll(t *testing.T, s *Server, db *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+	for {
+		tasks, err := s.claimTasks(ctx, db, nil, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks) == 0 {
+			return
+		}
+		for _, task := range tasks {
+			result := &pb.PostResultRequest{Id: task.Id}
+			value, err := operation.Apply(task.Operation, float64(task.Arg1), float64(task.Arg2))
+			if task.Expression != "" {
+				var tree calculation.Node
+				if err := json.Unmarshal([]byte(task.Expression), &tree); err != nil {
+					t.Fatal(err)
+				}
+				value, err = calculation.Eval(&tree)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			result.Result = float32(value)
+			if err := s.applyResult(ctx, db, "", result); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestTaskBatches(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+	s := NewServer(ConfigFromEnv())
+	agent := &pb.AgentInfo{Id: "agent"}
+
+	// Готовых задач меньше, чем запрошено: выдаются все, что есть
+	newTestExpression(t, db, userID, 3)
+	response, err := s.GetTasks(ctx, &pb.GetTasksRequest{MaxN: 5, Agent: agent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Tasks) != 3 || response.QueueDepth != 0 {
+		t.Fatalf("Expected 3 tasks and empty queue, but got %d tasks and queue depth %d", len(response.Tasks), response.QueueDepth)
+	}
+	if _, err := s.GetTasks(ctx, &pb.GetTasksRequest{MaxN: 5, Agent: agent}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound when no tasks are ready, but got %v", err)
+	}
+	if _, err := s.GetTasks(ctx, &pb.GetTasksRequest{MaxN: 0, Agent: agent}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for max_n 0, but got %v", err)
+	}
+
+	// Неизвестная задача в пакете не мешает приему остальных результатов
+	results := []*pb.PostResultRequest{{Id: response.Tasks[0].Id, Result: 3}, {Id: 1000, Result: 1}, {Id: response.Tasks[1].Id, Result: 3}}
+	posted, err := s.PostResults(ctx, &pb.PostResultsRequest{Results: results, Agent: agent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posted.Statuses) != 3 || posted.Statuses[0].Status != "OK" || posted.Statuses[1].Status != "Not Found" || posted.Statuses[2].Status != "OK" {
+		t.Fatalf("Expected statuses OK, Not Found, OK, but got %v", posted.Statuses)
+	}
+	for _, task := range response.Tasks[:2] {
+		stored, err := selectTaskByID(ctx, db, int(task.Id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != "complete" || stored.Result != 3 {
+			t.Errorf("Expected task %d to be complete with 3, but got %q %v", task.Id, stored.Status, stored.Result)
+		}
+	}
+	stored, err := selectTaskByID(ctx, db, int(response.Tasks[2].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != "calculating" {
+		t.Errorf("Expected task without result to stay calculating, but got %q", stored.Status)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+	s := NewServer(ConfigFromEnv())
+	a := &Application{config: s.config, server: s}
+	agent := &pb.AgentInfo{Id: "agent"}
+
+	newTestExpression(t, db, userID, 2)
+	response, err := s.GetTasks(ctx, &pb.GetTasksRequest{MaxN: 2, Agent: agent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.draining.Store(true)
+
+	// Новые выражения и задачи не принимаются и не выдаются
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1+1"}`))
+	r.Header.Set("Authorization", newTestToken(t, "user"))
+	w := httptest.NewRecorder()
+	a.AddExpressions(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for new expression while draining, but got %d", w.Code)
+	}
+	if _, err := s.GetTasks(ctx, &pb.GetTasksRequest{MaxN: 1, Agent: agent}); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable while draining, but got %v", err)
+	}
+
+	// Пока выданные задачи не вернулись, остановка ждет
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := waitCalculating(waitCtx); err == nil {
+		t.Error("Expected waiting for in-flight tasks to time out")
+	}
+
+	// Агент присылает результат одной задачи и возвращает другую, не тратя попытку
+	posted, err := s.PostResults(ctx, &pb.PostResultsRequest{Results: []*pb.PostResultRequest{{Id: response.Tasks[0].Id, Result: 3}}, Agent: agent})
+	if err != nil || posted.Statuses[0].Status != "OK" {
+		t.Fatalf("Expected result to be accepted while draining, but got %v (%v)", posted, err)
+	}
+	if _, err := s.ReleaseTasks(ctx, &pb.ReleaseTasksRequest{Agent: agent, TaskIds: []int64{response.Tasks[1].Id}}); err != nil {
+		t.Fatal(err)
+	}
+	released, err := selectTaskByID(ctx, db, int(response.Tasks[1].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released.Status != "waiting" || released.Attempts != 0 {
+		t.Errorf("Expected released task to wait without attempts, but got %+v", released)
+	}
+	if err := waitCalculating(ctx); err != nil {
+		t.Errorf("Expected no in-flight tasks left, but got %v", err)
+	}
+}
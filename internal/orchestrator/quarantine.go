@@ -0,0 +1,313 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Количество последних исходов задач агента, по которым считается доля сбоев
+const agentOutcomeWindow = 20
+
+// Минимальное количество исходов, после которого агента можно поместить в карантин
+const minAgentOutcomes = 5
+
+// Исход задачи, выданной агенту
+type outcome int
+
+const (
+	outcomeSuccess      outcome = iota // Результат принят
+	outcomeError                       // Временная ошибка или операция, которую агент не смог выполнить
+	outcomeLeaseExpired                // Агент не вернул результат и не продлил аренду
+	outcomeMismatch                    // Результат агента разошелся с результатами других агентов
+)
+
+// agentHealth - статистика задач агента и состояние его карантина. Агент, доля сбоев которого
+// карантина агент получает одну задачу и, пока она вычисляется, других не получает, а первый же сбой возвращает его в карантин
+// карантина агент получает по одной задаче, и первый же сбой возвращает его в карантин
+type agentHealth struct {
+	recent           []bool    // Последние исходы, true - сбой
+	completed        int       // Принятые результаты
+	errors           int       // Временные ошибки
+	leaseExpirations int       // Истекшие аренды
+	mismatches       int       // Расхождения с другими агентами
+	quarantines      int       // Сколько раз агент попадал в карантин
+	quarantinedUntil time.Time // Конец карантина, нулевое значение - агент не в карантине
+	probation        bool      // Карантин закончился, но агент еще не вернул ни одной задачи
+}
+
+// quarantined проверяет, находится ли агент в карантине. По окончании карантина агент
+// переводится на испытательный срок
+func (h *agentHealth) quarantined(now time.Time) bool {
+	if h.quarantinedUntil.IsZero() {
+		return false
+	}
+	if now.Before(h.quarantinedUntil) {
+		return true
+	}
+	h.quarantinedUntil = time.Time{}
+	h.probation = true
+	return false
+}
+
+// failureRate возвращает долю сбоев среди последних исходов
+func (h *agentHealth) failureRate() float64 {
+	if len(h.recent) == 0 {
+		return 0
+	}
+	failures := 0
+	for _, failed := range h.recent {
+		if failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(h.recent))
+}
+
+// state возвращает состояние агента для GET /api/v1/agents
+func (h *agentHealth) state(now time.Time) string {
+	switch {
+	case h.quarantined(now):
+		return "quarantined"
+	case h.probation:
+		return "probation"
+	default:
+		return "active"
+	}
+}
+
+// record учитывает исход задачи агента и помещает агента в карантин, если сбоев стало слишком много
+func (r *agentRegistry) record(id string, o outcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[id]
+	if !ok {
+		return
+	}
+	h := &agent.health
+	switch o {
+	case outcomeSuccess:
+		h.completed++
+	case outcomeError:
+		h.errors++
+	case outcomeLeaseExpired:
+		h.leaseExpirations++
+	case outcomeMismatch:
+		h.mismatches++
+	}
+
+	now := time.Now()
+	// Исходы задач, выданных до карантина, его не продлевают
+	if h.quarantined(now) {
+		return
+	}
+	failed := o != outcomeSuccess
+	if h.probation {
+		h.probation = false
+		if failed {
+			r.startQuarantine(id, h, now, "failed on probation")
+		}
+		return
+	}
+	h.recent = append(h.recent, failed)
+	if len(h.recent) > agentOutcomeWindow {
+		h.recent = h.recent[len(h.recent)-agentOutcomeWindow:]
+	}
+	if len(h.recent) >= minAgentOutcomes && h.failureRate() > r.maxFailureRate {
+		r.startQuarantine(id, h, now, "failure rate exceeded")
+	}
+}
+
+// startQuarantine помещает агента в карантин. Вызывается под r.mu
+func (r *agentRegistry) startQuarantine(id string, h *agentHealth, now time.Time, reason string) {
+	h.quarantinedUntil = now.Add(r.quarantine)
+	h.quarantines++
+	h.recent = nil
+	log.Printf("agent %s quarantined until %s: %s", id, h.quarantinedUntil.Format(time.RFC3339), reason)
+}
+
+// admit возвращает, сколько из n запрошенных задач можно выдать агенту, который вычисляет inFlight задач:
+// ни одной в карантине, а на испытательном сроке - одну, пока агент не вычисляет ни одной.
+// Для агента в карантине возвращается также конец карантина
+func (r *agentRegistry) admit(id string, n int, inFlight int) (int, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[id]
+	if !ok {
+		return n, time.Time{}
+	}
+	h := &agent.health
+	if h.quarantined(time.Now()) {
+		return 0, h.quarantinedUntil
+	}
+	if h.probation {
+		if inFlight > 0 {
+			return 0, time.Time{}
+		}
+		return min(n, 1), time.Time{}
+	}
+	return n, time.Time{}
+}
+
+// countAgentTasks возвращает количество задач и их копий, которые агент вычисляет сейчас
+func countAgentTasks(ctx context.Context, db querier, agentID string) (int, error) {
+	var n int
+	q := "SELECT COUNT(*) FROM tasks WHERE status = 'calculating' AND (agent_id = $1 OR speculative_agent_id = $1)"
+	err := db.QueryRowContext(ctx, q, agentID).Scan(&n)
+	return n, err
+}
+
+// admitAgent возвращает, сколько из n запрошенных задач можно выдать агенту, или ошибку, если выдать
+// нельзя ни одной: агент в карантине или на испытательном сроке еще не вернул выданную задачу. Вызывается под s.mu
+func (s *Server) admitAgent(ctx context.Context, db querier, id string, n int) (int, error) {
+	inFlight, err := countAgentTasks(ctx, db, id)
+	if err != nil {
+		return 0, status.Error(codes.Internal, "Internal Server Error")
+	}
+	n, until := s.agents.admit(id, n, inFlight)
+	switch {
+	case n > 0:
+		return n, nil
+	case !until.IsZero():
+		return 0, quarantinedError(until)
+	default:
+		return 0, status.Error(codes.NotFound, "Not Found")
+	}
+}
+
+// quarantinedError возвращает ошибку запроса задач агентом в карантине
+func quarantinedError(until time.Time) error {
+	return status.Errorf(codes.FailedPrecondition, "agent is quarantined until %s", until.Format(time.RFC3339))
+}
+
+// release досрочно выводит агента из карантина. Возвращает, известен ли агент
+// и был ли он в карантине
+func (r *agentRegistry) release(id string) (found bool, released bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[id]
+	if !ok {
+		return false, false
+	}
+	if !agent.health.quarantined(time.Now()) {
+		return true, false
+	}
+	agent.health.quarantinedUntil = time.Time{}
+	agent.health.probation = false
+	log.Printf("agent %s released from quarantine", id)
+	return true, true
+}
+
+// AgentStatus - сведения об агенте для GET /api/v1/agents
+type AgentStatus struct {
+	ID               string            `json:"id"`
+	Operations       []string          `json:"operations"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Alive            bool              `json:"alive"`
+	LastSeen         int64             `json:"last_seen"`                   // Время последнего обращения в миллисекундах
+	State            string            `json:"state"`                       // active, quarantined или probation
+	QuarantinedUntil int64             `json:"quarantined_until,omitempty"` // Конец карантина в миллисекундах
+	FailureRate      float64           `json:"failure_rate"`                // Доля сбоев среди последних задач
+	Completed        int               `json:"completed"`
+	Errors           int               `json:"errors"`
+	LeaseExpirations int               `json:"lease_expirations"`
+	Mismatches       int               `json:"mismatches"`
+	Quarantines      int               `json:"quarantines"`
+
+	MedianTimes map[string]int64 `json:"median_times,omitempty"` // Медиана последних времен вычисления по операциям в миллисекундах
+}
+
+// list возвращает сведения обо всех известных агентах
+func (r *agentRegistry) list(timeout time.Duration) []AgentStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	agents := make([]AgentStatus, 0, len(r.agents))
+	for id, agent := range r.agents {
+		h := &agent.health
+		status := AgentStatus{
+			ID:               id,
+			Operations:       agent.info.GetOperations(),
+			Labels:           agent.info.GetLabels(),
+			Alive:            now.Sub(agent.lastSeen) <= timeout,
+			State:            h.state(now),
+			FailureRate:      h.failureRate(),
+			Completed:        h.completed,
+			Errors:           h.errors,
+			LeaseExpirations: h.leaseExpirations,
+			Mismatches:       h.mismatches,
+			Quarantines:      h.quarantines,
+		}
+		if !agent.lastSeen.IsZero() {
+			status.LastSeen = agent.lastSeen.UnixMilli()
+		}
+		if !h.quarantinedUntil.IsZero() {
+			status.QuarantinedUntil = h.quarantinedUntil.UnixMilli()
+		}
+		for op, durations := range agent.durations {
+			if median, ok := medianDuration(durations); ok {
+				if status.MedianTimes == nil {
+					status.MedianTimes = make(map[string]int64)
+				}
+				status.MedianTimes[op] = median.Milliseconds()
+			}
+		}
+		agents = append(agents, status)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	return agents
+}
+
+// GetAgents возвращает агентов, известных оркестратору, с их статистикой и состоянием карантина
+func (a *Application) GetAgents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, 405)
+		return
+	}
+
+	if !a.checkAdmin(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"agents": a.server.agents.list(a.config.AgentTimeout)})
+}
+
+// ReleaseAgent досрочно выводит агента из карантина
+func (a *Application) ReleaseAgent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, 405)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/agents/")
+	id, ok := strings.CutSuffix(path, "/release")
+	if !ok || id == "" {
+		sendError(w, 404)
+		return
+	}
+	if !a.checkAdmin(w, r) {
+		return
+	}
+
+	found, released := a.server.agents.release(id)
+	if !found {
+		sendError(w, 404)
+		return
+	}
+	if !released {
+		sendError(w, 409)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "OK"})
+}
@@ -0,0 +1,143 @@
+package orchestrator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuarantine(t *testing.T) {
+	r := newAgentRegistry()
+	r.maxFailureRate = 0.5
+	r.quarantine = time.Hour
+	r.seen(&pb.AgentInfo{Id: "a"})
+
+	// Пока исходов меньше minAgentOutcomes, агент не попадает в карантин даже при одних сбоях
+	for i := 0; i < minAgentOutcomes-1; i++ {
+		r.record("a", outcomeLeaseExpired)
+	}
+	if n, _ := r.admit("a", 4, 0); n != 4 {
+		t.Fatalf("Expected agent to get 4 tasks before %d outcomes, but got %d", minAgentOutcomes, n)
+	}
+	r.record("a", outcomeMismatch)
+	n, until := r.admit("a", 4, 0)
+	if n != 0 || until.IsZero() {
+		t.Fatalf("Expected agent to be quarantined, but got %d tasks", n)
+	}
+	if len(r.available(time.Minute)) != 0 {
+		t.Error("Expected quarantined agent not to be available")
+	}
+
+	if found, released := r.release("b"); found || released {
+		t.Error("Expected unknown agent not to be released")
+	}
+	if found, released := r.release("a"); !found || !released {
+		t.Fatal("Expected agent to be released")
+	}
+	if found, released := r.release("a"); !found || released {
+		t.Error("Expected agent not in quarantine not to be released again")
+	}
+
+	// После карантина агент получает по одной задаче, и первый же сбой возвращает его в карантин
+	r.agents["a"].health.quarantinedUntil = time.Now().Add(-time.Second)
+	if n, _ := r.admit("a", 4, 0); n != 1 {
+		t.Fatalf("Expected agent on probation to get 1 task, but got %d", n)
+	}
+	// Пока задача испытательного срока вычисляется, новых задач агент не получает
+	if n, until := r.admit("a", 4, 1); n != 0 || !until.IsZero() {
+		t.Fatalf("Expected agent on probation with a task in flight to get no tasks, but got %d", n)
+	}
+	if state := r.list(time.Minute)[0].State; state != "probation" {
+		t.Errorf("Expected state probation, but got %q", state)
+	}
+	r.record("a", outcomeError)
+	if n, _ := r.admit("a", 4, 0); n != 0 {
+		t.Fatalf("Expected agent failed on probation to be quarantined, but got %d tasks", n)
+	}
+
+	// Успех на испытательном сроке возвращает агенту полную выдачу
+	r.agents["a"].health.quarantinedUntil = time.Now().Add(-time.Second)
+	r.admit("a", 4, 0)
+	r.record("a", outcomeSuccess)
+	if n, _ := r.admit("a", 4, 0); n != 4 {
+		t.Fatalf("Expected agent to get 4 tasks after probation, but got %d", n)
+	}
+	status := r.list(time.Minute)[0]
+	if status.State != "active" || status.Quarantines != 2 || status.Completed != 1 || status.LeaseExpirations != minAgentOutcomes-1 {
+		t.Errorf("Unexpected agent status %+v", status)
+	}
+
+	// Состояние создается только для обращавшихся агентов с идентификатором
+	r.seen(&pb.AgentInfo{})
+	r.record("unknown", outcomeError)
+	r.recordDuration("unknown", "+", time.Second)
+	if n, _ := r.admit("", 4, 0); n != 4 {
+		t.Errorf("Expected agent without id to get 4 tasks, but got %d", n)
+	}
+	if len(r.agents) != 1 {
+		t.Errorf("Expected only agent a to be known, but got %d agents", len(r.agents))
+	}
+}
+
+func TestGetAgents(t *testing.T) {
+	db := newTestDB(t)
+	newTestUser(t, db, "user")
+	newTestUser(t, db, "admin")
+	config := ConfigFromEnv()
+	config.AdminLogins = []string{"admin"}
+	s := NewServer(config)
+	s.agents.seen(&pb.AgentInfo{Id: "a"})
+	a := &Application{config: config, server: s}
+
+	for _, tc := range []struct {
+		login string
+		code  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"user", http.StatusForbidden},
+		{"admin", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/agents", nil)
+		if tc.login != "" {
+			r.Header.Set("Authorization", newTestToken(t, tc.login))
+		}
+		w := httptest.NewRecorder()
+		a.GetAgents(w, r)
+		if w.Code != tc.code {
+			t.Errorf("Expected %d for %q, but got %d", tc.code, tc.login, w.Code)
+		}
+	}
+}
+
+func TestProbationInFlight(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+	submitTestExpression(t, db, userID, "1+2")
+	submitTestExpression(t, db, userID, "3+4")
+	s := NewServer(ConfigFromEnv())
+	agent := &pb.AgentInfo{Id: "a"}
+	s.agents.seen(agent)
+	s.agents.agents["a"].health.probation = true
+
+	response, err := s.GetTasks(ctx, &pb.GetTasksRequest{Agent: agent, MaxN: 4})
+	if err != nil || len(response.Tasks) != 1 {
+		t.Fatalf("Expected agent on probation to get 1 task, but got %v (%v)", response, err)
+	}
+	// Следующий запрос до результата первой задачи ничего не выдает
+	if _, err := s.GetTasks(ctx, &pb.GetTasksRequest{Agent: agent, MaxN: 4}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected no tasks while probation task is in flight, but got %v", err)
+	}
+	if err := s.applyResult(ctx, db, "a", &pb.PostResultRequest{Id: response.Tasks[0].Id, Result: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if response, err := s.GetTasks(ctx, &pb.GetTasksRequest{Agent: agent, MaxN: 4}); err != nil || len(response.Tasks) != 1 {
+		t.Fatalf("Expected agent to get the remaining task after probation, but got %v (%v)", response, err)
+	}
+}
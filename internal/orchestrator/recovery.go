@@ -0,0 +1,134 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Итоги восстановления состояния после перезапуска оркестратора
+type recoverySummary struct {
+	Requeued int // Задачи, которые вычислялись в момент остановки и возвращены в очередь
+	Failed   int // Выражения, которые невозможно довычислить
+	Orphaned int // Удаленные задачи без невычисленного выражения
+}
+
+func (r recoverySummary) String() string {
+	return fmt.Sprintf("requeued %d tasks, failed %d expressions, removed %d orphaned tasks", r.Requeued, r.Failed, r.Orphaned)
+}
+
+// recoverState приводит базу в согласованное состояние после остановки оркестратора:
+// возвращает в очередь задачи, результаты которых уже не придут, удаляет задачи без выражения
+// и завершает с ошибкой выражения, граф задач которых поврежден
+func recoverState(ctx context.Context, db *sql.DB) (recoverySummary, error) {
+	var summary recoverySummary
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return summary, err
+	}
+	defer tx.Rollback()
+
+	tasks, err := selectTasks(ctx, tx)
+	if err != nil {
+		return summary, err
+	}
+	byExpression := make(map[int]map[int]Task)
+	for _, task := range tasks {
+		if byExpression[task.ExpressionID] == nil {
+			byExpression[task.ExpressionID] = make(map[int]Task)
+		}
+		byExpression[task.ExpressionID][task.ID] = task
+	}
+
+	for expressionID, expressionTasks := range byExpression {
+		expression, err := selectExpressionByID(ctx, tx, expressionID)
+		if err != nil && err != sql.ErrNoRows {
+			return summary, err
+		}
+		if err == sql.ErrNoRows || !pending(expression) {
+			// Выражение удалено или уже завершено, его задачи больше никому не нужны
+			if err := deleteTasksByExpressionID(ctx, tx, expressionID); err != nil {
+				return summary, err
+			}
+			summary.Orphaned += len(expressionTasks)
+			continue
+		}
+
+		if reason := checkTaskGraph(expression, expressionTasks); reason != "" {
+			if err := setError(ctx, tx, expression.ID, "recovery: "+reason); err != nil {
+				return summary, err
+			}
+			summary.Failed++
+			continue
+		}
+
+		for _, task := range expressionTasks {
+			if task.Status != "calculating" {
+				continue
+			}
+			// Перезапуск оркестратора не считается неудачной попыткой вычисления
+			if err := updateTaskRetry(ctx, tx, task.ID, "waiting", task.Attempts, 0, task.LastError); err != nil {
+				return summary, err
+			}
+			summary.Requeued++
+		}
+	}
+
+	// Невычисленные выражения без задач не завершатся никогда
+	var q = "SELECT id FROM expressions WHERE status IN ('waiting', ?) AND id NOT IN (SELECT expression_id FROM tasks)"
+	rows, err := tx.QueryContext(ctx, q, statusNoCapableAgent)
+	if err != nil {
+		return summary, err
+	}
+	var stale []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return summary, err
+		}
+		stale = append(stale, id)
+	}
+	rows.Close()
+	for _, id := range stale {
+		if err := setError(ctx, tx, id, "recovery: no tasks left"); err != nil {
+			return summary, err
+		}
+		summary.Failed++
+	}
+
+	return summary, tx.Commit()
+}
+
+// pending проверяет, ожидает ли выражение вычисления. Выражение в статусе dead ожидает ручного
+// перезапуска задачи, поэтому его задачи сохраняются, а само оно может быть отменено
+func pending(expression Expression) bool {
+	return expression.Status == "waiting" || expression.Status == statusNoCapableAgent || expression.Status == statusDead
+}
+
+// checkTaskGraph проверяет, что задачи выражения можно довычислить, и возвращает причину, если нельзя
+func checkTaskGraph(expression Expression, tasks map[int]Task) string {
+	if _, ok := tasks[expression.Answer]; !ok {
+		return fmt.Sprintf("answer task %d is missing", expression.Answer)
+	}
+	for _, task := range tasks {
+		if task.Status != "waiting" {
+			continue
+		}
+		refs, err := taskRefs(task)
+		if err != nil {
+			return err.Error()
+		}
+		for _, arg := range refs {
+			id, err := refID(arg)
+			if err != nil {
+				return fmt.Sprintf("task %d has invalid argument %q", task.ID, arg)
+			}
+			if _, ok := tasks[id]; !ok {
+				return fmt.Sprintf("task %d depends on missing task %d", task.ID, id)
+			}
+		}
+	}
+	return ""
+}
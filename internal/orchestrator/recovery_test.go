@@ -0,0 +1,126 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryAfterCrash(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	user := newTestUser(t, db, "alice")
+	id := submitTestExpression(t, db, user, "(1+2)*(3+4)-10/5")
+
+	// Оркестратор выдал агентам все готовые задачи и упал, не дождавшись результатов
+	crashed := NewServer(ConfigFromEnv())
+	inFlight, err := crashed.claimTasks(ctx, db, nil, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inFlight) == 0 {
+		t.Fatal("Expected some tasks to be in flight")
+	}
+
+	summary, err := recoverState(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Requeued != len(inFlight) || summary.Failed != 0 || summary.Orphaned != 0 {
+		t.Fatalf("Expected %d requeued tasks, but got %v", len(inFlight), summary)
+	}
+
+	restarted := NewServer(ConfigFromEnv())
+	computeAll(t, restarted, db)
+
+	expression, err := selectExpressionByID(ctx, db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "complete" || expression.Result != 19 {
+		t.Fatalf("Expected complete expression with result 19, but got %q with %v", expression.Status, expression.Result)
+	}
+}
+
+func TestRecoveryAfterCrashMidResult(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	user := newTestUser(t, db, "alice")
+	id := submitTestExpression(t, db, user, "1+2+3+4")
+
+	// Оркестратор упал, когда задача ответа уже была удалена, а статус выражения еще не обновлен
+	crashed := NewServer(ConfigFromEnv())
+	computeAll(t, crashed, db)
+	if err := updateExpressionField(ctx, db, id, "status", "waiting"); err != nil {
+		t.Fatal(err)
+	}
+	other := submitTestExpression(t, db, user, "5*6-7")
+	if _, err := crashed.claimTasks(ctx, db, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := recoverState(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// У первого выражения задача ответа удалена вместе с результатом, довычислить его нельзя
+	if summary.Failed != 1 || summary.Requeued != 1 {
+		t.Fatalf("Expected one failed expression and one requeued task, but got %v", summary)
+	}
+	expression, err := selectExpressionByID(ctx, db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "error: recovery: no tasks left" {
+		t.Fatalf("Expected recovery error, but got %q", expression.Status)
+	}
+
+	computeAll(t, NewServer(ConfigFromEnv()), db)
+	expression, err = selectExpressionByID(ctx, db, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "complete" || expression.Result != 23 {
+		t.Fatalf("Expected complete expression with result 23, but got %q with %v", expression.Status, expression.Result)
+	}
+}
+
+func TestRecoveryBrokenGraph(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	user := newTestUser(t, db, "alice")
+	broken := submitTestExpression(t, db, user, "(1+2)*3")
+	finished := submitTestExpression(t, db, user, "4+5")
+
+	// Задача-аргумент потеряна, а у завершенного выражения остались лишние задачи
+	tasks, err := selectTasks(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range tasks {
+		if task.ExpressionID == broken && task.Operation == "+" {
+			deleteTask(ctx, db, task.ID)
+		}
+	}
+	if err := updateExpressionField(ctx, db, finished, "status", "complete"); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := recoverState(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Failed != 1 || summary.Orphaned != 1 {
+		t.Fatalf("Expected one failed expression and one orphaned task, but got %v", summary)
+	}
+	expression, err := selectExpressionByID(ctx, db, broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(expression.Status, "error: recovery") {
+		t.Fatalf("Expected recovery error, but got %q", expression.Status)
+	}
+	if tasks, _ := selectTasks(ctx, db); len(tasks) != 0 {
+		t.Fatalf("Expected no tasks left, but got %d", len(tasks))
+	}
+}
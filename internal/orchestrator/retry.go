@@ -0,0 +1,131 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// Статус задачи, исчерпавшей все попытки вычисления, и ожидающего ее выражения
+const statusDead = "dead"
+
+var errNotDead = errors.New("task is not dead")
+
+// Максимальная задержка перед повторной выдачей задачи
+const maxRetryBackoff = time.Minute
+
+// retryBackoff возвращает задержку перед попыткой номер attempts+1: база удваивается с каждой неудачей
+func retryBackoff(base time.Duration, attempts int) time.Duration {
+	backoff := base
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff
+}
+
+// retryTask обрабатывает временную ошибку задачи: возвращает ее в очередь с задержкой
+// или, если попытки закончились, переводит в статус dead до ручного перезапуска
+func (s *Server) retryTask(ctx context.Context, db querier, task Task, reason string) error {
+	attempts := task.Attempts + 1
+	if attempts >= s.config.MaxAttempts {
+		log.Printf("task %d moved to dead letter after %d attempts: %s", task.ID, attempts, reason)
+		return markDead(ctx, db, task, attempts, reason)
+	}
+	notBefore := time.Now().Add(retryBackoff(s.config.RetryBackoff, attempts)).UnixMilli()
+	return updateTaskRetry(ctx, db, task.ID, "waiting", attempts, notBefore, reason)
+}
+
+// markDead переводит задачу в статус dead, а ее невычисленное выражение - в статус dead,
+// чтобы пользователь увидел, что выражение не завершится без ручного перезапуска задачи
+func markDead(ctx context.Context, db querier, task Task, attempts int, reason string) error {
+	if err := updateTaskRetry(ctx, db, task.ID, statusDead, attempts, 0, reason); err != nil {
+		return err
+	}
+	expression, err := selectExpressionByID(ctx, db, task.ExpressionID)
+	if err == sql.ErrNoRows || (err == nil && !pending(expression)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return updateExpressionField(ctx, db, expression.ID, "status", statusDead)
+}
+
+// requeueDeadTask возвращает задачу из dead letter в очередь со сброшенным счетчиком попыток.
+// Выражение снова ожидает вычисления, когда у него не остается задач в статусе dead
+func (s *Server) requeueDeadTask(ctx context.Context, db *sql.DB, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	task, err := selectTaskByID(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if task.Status != statusDead {
+		return errNotDead
+	}
+	if err := updateTaskRetry(ctx, tx, task.ID, "waiting", 0, 0, task.LastError); err != nil {
+		return err
+	}
+	// Проверка результата начинается заново
+	if err := deleteTaskVotes(ctx, tx, task.ID); err != nil {
+		return err
+	}
+
+	var dead int
+	q := "SELECT COUNT(*) FROM tasks WHERE expression_id = $1 AND status = $2"
+	if err := tx.QueryRowContext(ctx, q, task.ExpressionID, statusDead).Scan(&dead); err != nil {
+		return err
+	}
+	expression, err := selectExpressionByID(ctx, tx, task.ExpressionID)
+	if err != nil {
+		return err
+	}
+	if dead == 0 && expression.Status == statusDead {
+		if err := updateExpressionField(ctx, tx, expression.ID, "status", "waiting"); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// checkLeases возвращает в очередь задачи, агенты которых не прислали результат и не продлили аренду,
+// например из-за падения агента
+func (s *Server) checkLeases(ctx context.Context) error {
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks, err := selectTasks(ctx, db)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	for _, task := range tasks {
+		if task.Status != "calculating" || task.LeaseUntil == 0 || task.LeaseUntil > now {
+			continue
+		}
+		s.agents.record(task.AgentID, outcomeLeaseExpired)
+		s.agents.record(task.SpeculativeAgentID, outcomeLeaseExpired)
+		if err := s.retryTask(ctx, db, task, "lease expired"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{10, maxRetryBackoff}, // Задержка ограничена сверху
+	}
+
+	for _, test := range tests {
+		if backoff := retryBackoff(time.Second, test.attempts); backoff != test.expected {
+			t.Errorf("For %d attempts expected backoff %v, but got %v", test.attempts, test.expected, backoff)
+		}
+	}
+}
+
+func TestRetryUntilDead(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	expressionID := newTestExpression(t, db, newTestUser(t, db, "alice"), 1)
+
+	config := ConfigFromEnv()
+	config.MaxAttempts = 2
+	config.RetryBackoff = 0
+	config.TaskLease = -time.Second // Аренда истекает сразу после выдачи
+	s := NewServer(config)
+
+	for attempt := 1; attempt <= config.MaxAttempts; attempt++ {
+		tasks, err := s.claimTasks(ctx, db, &pb.AgentInfo{Id: "crashed"}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks) != 1 {
+			t.Fatalf("Attempt %d: expected task to be claimed, but got %d tasks", attempt, len(tasks))
+		}
+		// Агент упал и не прислал результат
+		if err := s.checkLeases(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tasks, err := selectTasks(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tasks[0].Status != statusDead || tasks[0].Attempts != config.MaxAttempts || tasks[0].LastError != "lease expired" {
+		t.Fatalf("Expected dead task after %d attempts, but got %+v", config.MaxAttempts, tasks[0])
+	}
+	if claimed, _ := s.claimTasks(ctx, db, nil, 1); len(claimed) != 0 {
+		t.Fatal("Dead task must not be claimed")
+	}
+	// Пользователь видит, что выражение не завершится само
+	expression, err := selectExpressionByID(ctx, db, expressionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != statusDead {
+		t.Errorf("Expected expression with dead task to be %q, but got %q", statusDead, expression.Status)
+	}
+
+	// Поздний результат упавшего агента не завершает задачу, и ее можно перезапустить
+	if err := s.applyResult(ctx, db, "crashed", &pb.PostResultRequest{Id: int64(tasks[0].ID), Result: 1}); err != nil {
+		t.Fatal(err)
+	}
+	task, err := selectTaskByID(ctx, db, tasks[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != statusDead {
+		t.Errorf("Expected late result not to change dead task, but got %q", task.Status)
+	}
+	if err := s.requeueDeadTask(ctx, db, task.ID); err != nil {
+		t.Errorf("Expected dead task to be requeued after late result, but got %v", err)
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	expressionID := newTestExpression(t, db, newTestUser(t, db, "alice"), 1)
+	s := NewServer(ConfigFromEnv())
+
+	tasks, err := s.claimTasks(ctx, db, nil, 1)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("Expected one task, but got %d (%v)", len(tasks), err)
+	}
+	err = s.applyResult(ctx, db, "", &pb.PostResultRequest{Id: tasks[0].Id, Error: "agent crashed", Retryable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := selectTaskByID(ctx, db, int(tasks[0].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != "waiting" || task.Attempts != 1 || task.NotBefore <= time.Now().UnixMilli() {
+		t.Fatalf("Expected task to be requeued with backoff, but got %+v", task)
+	}
+	expression, err := selectExpressionByID(ctx, db, expressionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "waiting" {
+		t.Fatalf("Retryable error must not fail expression, but got status %q", expression.Status)
+	}
+}
@@ -0,0 +1,188 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
+)
+
+// Priority - приоритет выражения. В запросе задается строкой low, normal, high или целым числом от 0 до 2
+type Priority int
+
+const (
+	PriorityLow    Priority = 0
+	PriorityNormal Priority = 1
+	PriorityHigh   Priority = 2
+)
+
+// errInvalidPriority возвращается для неизвестного названия приоритета и числа вне диапазона.
+// Иначе один пользователь мог бы задать огромный приоритет, который не перекрыть ожиданием
+var errInvalidPriority = errors.New("invalid priority")
+
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		switch name {
+		case "low":
+			*p = PriorityLow
+		case "normal":
+			*p = PriorityNormal
+		case "high":
+			*p = PriorityHigh
+		default:
+			return fmt.Errorf("%w: unknown name %q", errInvalidPriority, name)
+		}
+		return nil
+	}
+	var value int
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value < int(PriorityLow) || value > int(PriorityHigh) {
+		return fmt.Errorf("%w: %d is out of range", errInvalidPriority, value)
+	}
+	*p = Priority(value)
+	return nil
+}
+
+// Задача, готовая к выдаче агенту, с уже вычисленными аргументами
+type candidate struct {
+	task       Task
+	deps       []int // Задачи-аргументы, которые удаляются после выдачи задачи
+	arg1, arg2 float64
+	tree       *calculation.Node // Подвыражение с подставленными аргументами для задачи subtree
+}
+
+// effectivePriority возвращает приоритет задачи с учетом ожидания:
+// каждые aging времени ожидания повышают приоритет на единицу, поэтому задачи с низким приоритетом не голодают
+func effectivePriority(task Task, now time.Time, aging time.Duration) float64 {
+	priority := float64(task.Priority)
+	if aging <= 0 {
+		return priority
+	}
+	waited := now.Sub(time.UnixMilli(task.CreatedAt))
+	return priority + float64(waited)/float64(aging)
+}
+
+// orderCandidates сортирует задачи по убыванию приоритета с учетом ожидания, при равенстве - по порядку создания
+func orderCandidates(candidates []candidate, now time.Time, aging time.Duration) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		pi := effectivePriority(candidates[i].task, now, aging)
+		pj := effectivePriority(candidates[j].task, now, aging)
+		if pi != pj {
+			return pi > pj
+		}
+		return candidates[i].task.ID < candidates[j].task.ID
+	})
+}
+
+// fairQueue делит задачи между пользователями по алгоритму deficit round robin:
+// при каждом обходе пользователь получает кредит, равный его весу, и забирает по задаче за единицу кредита
+type fairQueue struct {
+	ring    []int           // Пользователи, у которых есть готовые задачи, в порядке обхода
+	pos     int             // Текущий пользователь в ring
+	visited bool            // Получил ли текущий пользователь кредит в этом обходе
+	deficit map[int]float64 // Неизрасходованный кредит пользователей
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{deficit: make(map[int]float64)}
+}
+
+// pick выбирает до n задач: сначала задачи с наибольшим приоритетом с учетом ожидания,
+// а между пользователями с задачами этого приоритета - по очереди согласно весам
+func (q *fairQueue) pick(candidates []candidate, weights map[int]float64, n int, now time.Time, aging time.Duration) []candidate {
+	orderCandidates(candidates, now, aging)
+	q.retain(candidates)
+
+	var picked []candidate
+	for len(picked) < n && len(candidates) > 0 {
+		level := math.Floor(effectivePriority(candidates[0].task, now, aging))
+		present := make(map[int]bool)
+		for _, c := range candidates {
+			if math.Floor(effectivePriority(c.task, now, aging)) < level {
+				break
+			}
+			present[c.task.UserID] = true
+		}
+		user := q.next(present, weights)
+		for i, c := range candidates {
+			if c.task.UserID == user {
+				picked = append(picked, c)
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				break
+			}
+		}
+	}
+	return picked
+}
+
+// retain оставляет в очереди только пользователей с готовыми задачами и добавляет новых в конец
+func (q *fairQueue) retain(candidates []candidate) {
+	active := make(map[int]bool)
+	var users []int
+	for _, c := range candidates {
+		if !active[c.task.UserID] {
+			active[c.task.UserID] = true
+			users = append(users, c.task.UserID)
+		}
+	}
+
+	var ring []int
+	pos := 0
+	for i, user := range q.ring {
+		if !active[user] {
+			// У пользователя без задач кредит не копится
+			delete(q.deficit, user)
+			if i == q.pos {
+				q.visited = false
+			}
+			continue
+		}
+		if i < q.pos {
+			pos++
+		}
+		ring = append(ring, user)
+		delete(active, user)
+	}
+	sort.Ints(users)
+	for _, user := range users {
+		if active[user] {
+			ring = append(ring, user)
+		}
+	}
+	q.ring = ring
+	q.pos = pos
+	if q.pos >= len(q.ring) {
+		q.pos = 0
+		q.visited = false
+	}
+}
+
+// next возвращает пользователя, чья задача должна быть выдана следующей
+func (q *fairQueue) next(present map[int]bool, weights map[int]float64) int {
+	for {
+		user := q.ring[q.pos]
+		if present[user] {
+			if !q.visited {
+				weight := weights[user]
+				if weight <= 0 {
+					weight = 1
+				}
+				q.deficit[user] += weight
+				q.visited = true
+			}
+			if q.deficit[user] >= 1 {
+				q.deficit[user]--
+				return user
+			}
+		}
+		q.pos = (q.pos + 1) % len(q.ring)
+		q.visited = false
+	}
+}
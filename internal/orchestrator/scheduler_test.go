@@ -0,0 +1,97 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPriorityUnmarshal(t *testing.T) {
+	tests := []struct {
+		input      string
+		expected   Priority
+		shouldFail bool
+	}{
+		{`"low"`, PriorityLow, false},
+		{`"normal"`, PriorityNormal, false},
+		{`"high"`, PriorityHigh, false},
+		{`2`, PriorityHigh, false}, // Целое число
+		{`"urgent"`, 0, true},      // Неизвестное название
+		{`1.5`, 0, true},           // Дробное число
+		{`1000000000`, 0, true},    // Число больше PriorityHigh
+		{`-1`, 0, true},            // Число меньше PriorityLow
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			var p Priority
+			err := json.Unmarshal([]byte(test.input), &p)
+			if test.shouldFail {
+				if err == nil {
+					t.Errorf("Expected error for priority: %s, but got none", test.input)
+				}
+			} else if err != nil {
+				t.Errorf("Did not expect error for priority: %s, but got: %v", test.input, err)
+			} else if p != test.expected {
+				t.Errorf("For priority: %s, expected: %d, but got: %d", test.input, test.expected, p)
+			}
+		})
+	}
+}
+
+func TestOrderCandidates(t *testing.T) {
+	now := time.Now()
+	aging := 10 * time.Second
+	candidates := []candidate{
+		{task: Task{ID: 1, Priority: int(PriorityLow), CreatedAt: now.UnixMilli()}},
+		{task: Task{ID: 2, Priority: int(PriorityNormal), CreatedAt: now.UnixMilli()}},
+		{task: Task{ID: 3, Priority: int(PriorityHigh), CreatedAt: now.UnixMilli()}},
+		// Задача с низким приоритетом, ожидающая дольше 2*aging, обгоняет задачу с высоким
+		{task: Task{ID: 4, Priority: int(PriorityLow), CreatedAt: now.Add(-25 * time.Second).UnixMilli()}},
+		{task: Task{ID: 5, Priority: int(PriorityHigh), CreatedAt: now.UnixMilli()}},
+	}
+	orderCandidates(candidates, now, aging)
+
+	expected := []int{4, 3, 5, 2, 1}
+	for i, c := range candidates {
+		if c.task.ID != expected[i] {
+			t.Fatalf("Expected order %v, but got task %d at position %d", expected, c.task.ID, i)
+		}
+	}
+}
+
+func TestFairShare(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	alice := newTestUser(t, db, "alice")
+	bob := newTestUser(t, db, "bob")
+	// Первым отправлено большое выражение alice, но bob не должен ждать его завершения
+	aliceExpression := newTestExpression(t, db, alice, 60)
+	bobExpression := newTestExpression(t, db, bob, 60)
+
+	config := ConfigFromEnv()
+	config.UserWeights = map[string]float64{"alice": 2, "bob": 1}
+	s := NewServer(config)
+
+	claimed := make(map[int]int)
+	for round := 1; round <= 10; round++ {
+		tasks, err := s.claimTasks(ctx, db, nil, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range tasks {
+			full, err := selectTaskByID(ctx, db, int(task.Id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			claimed[full.ExpressionID]++
+		}
+		// Пользователи с весами 2 и 1 получают задачи в отношении 2:1 на каждом шаге
+		if claimed[aliceExpression] != 2*round || claimed[bobExpression] != round {
+			t.Fatalf("After %d rounds expected %d and %d tasks, but got %d and %d",
+				round, 2*round, round, claimed[aliceExpression], claimed[bobExpression])
+		}
+	}
+}
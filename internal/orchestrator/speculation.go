@@ -0,0 +1,180 @@
+package orchestrator
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+// Количество последних времен вычисления операции агентом, по которым считается медиана
+const agentTimingWindow = 20
+
+// recordDuration запоминает, сколько агент вычислял задачу с операцией
+func (r *agentRegistry) recordDuration(id string, operation string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[id]
+	if !ok {
+		return
+	}
+	if agent.durations == nil {
+		agent.durations = make(map[string][]time.Duration)
+	}
+	durations := append(agent.durations[operation], d)
+	if len(durations) > agentTimingWindow {
+		durations = durations[len(durations)-agentTimingWindow:]
+	}
+	agent.durations[operation] = durations
+}
+
+// median возвращает медиану времен вычисления операции агентом
+func (r *agentRegistry) median(id string, operation string) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[id]
+	if !ok {
+		return 0, false
+	}
+	return medianDuration(agent.durations[operation])
+}
+
+// operationMedian возвращает медиану последних времен вычисления операции всеми агентами
+func (r *agentRegistry) operationMedian(operation string) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var all []time.Duration
+	for _, agent := range r.agents {
+		all = append(all, agent.durations[operation]...)
+	}
+	return medianDuration(all)
+}
+
+func medianDuration(durations []time.Duration) (time.Duration, bool) {
+	if len(durations) == 0 {
+		return 0, false
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	return sorted[len(sorted)/2], true
+}
+
+// computedBy проверяет, вычисляет ли агент задачу или ее копию
+func (t Task) computedBy(agentID string) bool {
+	return agentID != "" && (t.AgentID == agentID || t.SpeculativeAgentID == agentID)
+}
+
+// recordTaskTime запоминает время вычисления принятого результата агентом. Время подвыражений
+// не запоминается: оно зависит от их размера, и медиана по подвыражениям разного размера бессмысленна
+func (s *Server) recordTaskTime(task Task, agentID string) {
+	if task.Operation == subtreeOperation {
+		return
+	}
+	startedAt := task.StartedAt
+	if agentID == task.SpeculativeAgentID {
+		startedAt = task.SpeculativeStartedAt
+		log.Printf("speculative copy of task %d on agent %s finished before agent %s", task.ID, agentID, task.AgentID)
+	}
+	if startedAt == 0 {
+		return
+	}
+	s.agents.recordDuration(agentID, task.Operation, time.Since(time.UnixMilli(startedAt)))
+}
+
+// expectedDuration возвращает обычное время вычисления задачи: медиану по всем агентам для ее операции
+// или, пока ее нет, назначенное время операции. Подвыражения разного размера между собой не сравниваются
+func (s *Server) expectedDuration(task Task) time.Duration {
+	if task.Operation != subtreeOperation {
+		if median, ok := s.agents.operationMedian(task.Operation); ok {
+			return median
+		}
+	}
+	tree, err := parseTree(task)
+	if err != nil {
+		return s.operationTime(task.Operation)
+	}
+	return s.treeOperationTime(tree)
+}
+
+// claimStragglers выдает агенту до n копий задач, которые вычисляются в SpeculationFactor раз дольше
+// обычного. Принимается результат, пришедший первым, а второй агент узнает об отмене из Heartbeat.
+// Копия не выдается агенту, который сам вычисляет операцию так же медленно
+func (s *Server) claimStragglers(ctx context.Context, db querier, agent *pb.AgentInfo, tasks []Task, n int, now time.Time) ([]*pb.Task, error) {
+	factor := s.config.SpeculationFactor
+	if factor <= 0 {
+		return nil, nil
+	}
+	var stragglers []Task
+	for _, task := range tasks {
+		if task.Status != "calculating" || task.StartedAt == 0 || task.SpeculativeAgentID != "" || task.computedBy(agent.GetId()) {
+			continue
+		}
+		threshold := time.Duration(factor * float64(s.expectedDuration(task)))
+		if now.Sub(time.UnixMilli(task.StartedAt)) < threshold {
+			continue
+		}
+		if median, ok := s.agents.median(agent.GetId(), task.Operation); ok && median >= threshold {
+			continue
+		}
+		stragglers = append(stragglers, task)
+	}
+	// Первыми копируются задачи, которые вычисляются дольше всех
+	sort.Slice(stragglers, func(i, j int) bool { return stragglers[i].StartedAt < stragglers[j].StartedAt })
+
+	var claimed []*pb.Task
+	for _, task := range stragglers {
+		if len(claimed) == n {
+			break
+		}
+		// Аргументы подставлены в задачу при первой выдаче
+		c, err := resolveTask(ctx, db, task)
+		if err != nil || !c.runnableBy(agent) {
+			continue
+		}
+		message, err := s.taskMessage(c)
+		if err != nil {
+			return nil, err
+		}
+		leaseUntil := max(task.LeaseUntil, now.Add(time.Duration(message.OperationTime)*time.Millisecond+s.config.TaskLease).UnixMilli())
+		if err := markTaskSpeculative(ctx, db, task.ID, agent.GetId(), now.UnixMilli(), leaseUntil); err != nil {
+			return nil, err
+		}
+		log.Printf("task %d is running on agent %s for %v, speculative copy sent to agent %s",
+			task.ID, task.AgentID, now.Sub(time.UnixMilli(task.StartedAt)).Round(time.Millisecond), agent.GetId())
+		claimed = append(claimed, message)
+	}
+	return claimed, nil
+}
+
+// dropTaskCopy снимает с агента одну из двух копий задачи, например после его временной ошибки,
+// и оставляет задачу агенту со второй копией. Возвращает false, если второй копии нет
+func (s *Server) dropTaskCopy(ctx context.Context, db querier, task Task, agentID string) (bool, error) {
+	if task.Status != "calculating" || task.SpeculativeAgentID == "" {
+		return false, nil
+	}
+	switch agentID {
+	case task.SpeculativeAgentID:
+		return true, markTaskSpeculative(ctx, db, task.ID, "", 0, task.LeaseUntil)
+	case task.AgentID:
+		return true, promoteSpeculative(ctx, db, task.ID)
+	}
+	return false, nil
+}
+
+// markTaskSpeculative выдает копию вычисляемой задачи агенту; пустой agentID снимает копию
+func markTaskSpeculative(ctx context.Context, db querier, id int, agentID string, startedAt int64, leaseUntil int64) error {
+	q := "UPDATE tasks SET speculative_agent_id = $1, speculative_started_at = $2, lease_until = $3 WHERE id = $4"
+	_, err := db.ExecContext(ctx, q, agentID, startedAt, leaseUntil, id)
+	return err
+}
+
+// promoteSpeculative оставляет задачу агенту, вычисляющему ее копию
+func promoteSpeculative(ctx context.Context, db querier, id int) error {
+	q := `UPDATE tasks SET agent_id = speculative_agent_id, started_at = speculative_started_at,
+		speculative_agent_id = '', speculative_started_at = 0 WHERE id = $1`
+	_, err := db.ExecContext(ctx, q, id)
+	return err
+}
@@ -0,0 +1,142 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestSpeculation(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+
+	config := ConfigFromEnv()
+	config.SpeculationFactor = 3
+	config.OperationTimes["+"] = 100 * time.Millisecond
+	s := NewServer(config)
+	slow, fast := &pb.AgentInfo{Id: "slow"}, &pb.AgentInfo{Id: "fast"}
+	s.agents.seen(slow)
+	s.agents.seen(fast)
+
+	// claim выдает задачи агенту и делает вид, что выданные агенту slow задачи вычисляются уже секунду
+	claim := func(agent *pb.AgentInfo) []*pb.Task {
+		t.Helper()
+		tasks, err := s.claimTasks(ctx, db, agent, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range tasks {
+			if agent == slow {
+				startedAt := time.Now().Add(-time.Second).UnixMilli()
+				if err := updateTaskField(ctx, db, int(task.Id), "started_at", startedAt); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return tasks
+	}
+	heartbeat := func(agent *pb.AgentInfo, id int64) bool {
+		t.Helper()
+		response, err := s.Heartbeat(ctx, &pb.HeartbeatRequest{Agent: agent, TaskIds: []int64{id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(response.CancelledIds) == 0
+	}
+
+	exprID := submitTestExpression(t, db, userID, "1+2")
+	tasks := claim(slow)
+	if len(tasks) != 1 {
+		t.Fatal("Expected task to be claimed by slow agent")
+	}
+	id := tasks[0].Id
+	speculative := claim(fast)
+	if len(speculative) != 1 || speculative[0].Id != id {
+		t.Fatalf("Expected fast agent to get a copy of task %d, but got %v", id, speculative)
+	}
+	if again := claim(&pb.AgentInfo{Id: "third"}); len(again) != 0 {
+		t.Fatal("Expected task to be copied only once")
+	}
+
+	// Принимается первый результат, а второй агент узнает об отмене
+	if err := s.applyResult(ctx, db, "fast", &pb.PostResultRequest{Id: id, Result: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if heartbeat(slow, id) {
+		t.Error("Expected slow agent's copy to be cancelled")
+	}
+	// Задачи вычисленного выражения удалены, поэтому опоздавший результат получает NotFound
+	s.applyResult(ctx, db, "slow", &pb.PostResultRequest{Id: id, Result: 4})
+	expression, err := selectExpressionByID(ctx, db, exprID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "complete" || expression.Result != 3 {
+		t.Errorf("Expected result of the first copy, but got %q %v", expression.Status, expression.Result)
+	}
+	if _, ok := s.agents.median("fast", "+"); !ok {
+		t.Error("Expected time of fast agent to be recorded")
+	}
+
+	// После временной ошибки одной из копий задача остается у второго агента
+	submitTestExpression(t, db, userID, "2+2")
+	id = claim(slow)[0].Id
+	claim(fast)
+	if err := s.applyResult(ctx, db, "slow", &pb.PostResultRequest{Id: id, Error: "busy", Retryable: true}); err != nil {
+		t.Fatal(err)
+	}
+	task, err := selectTaskByID(ctx, db, int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != "calculating" || task.AgentID != "fast" || task.SpeculativeAgentID != "" || task.Attempts != 0 {
+		t.Errorf("Expected task to be left to fast agent, but got %+v", task)
+	}
+	if !heartbeat(fast, id) {
+		t.Error("Expected fast agent to keep computing the task")
+	}
+}
+
+func TestSpeculationSubtree(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+
+	// Без SPECULATION_FACTOR копии задач не выдаются
+	t.Setenv("SPECULATION_FACTOR", "")
+	config := ConfigFromEnv()
+	if config.SpeculationFactor != 0 {
+		t.Errorf("Expected speculation to be disabled by default, but got factor %v", config.SpeculationFactor)
+	}
+	config.SpeculationFactor = 3
+	for _, op := range []string{"+", "*"} {
+		config.OperationTimes[op] = 100 * time.Millisecond
+	}
+	s := NewServer(config)
+	agent := &pb.AgentInfo{Id: "a"}
+	s.agents.seen(agent)
+
+	submitTestChunks(t, db, userID, "(1+2)*(3+4)", 5)
+	tasks, err := s.claimTasks(ctx, db, agent, 1)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("Expected subtree task to be claimed, but got %v (%v)", tasks, err)
+	}
+	task, err := selectTaskByID(ctx, db, int(tasks[0].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Operation != subtreeOperation {
+		t.Fatalf("Expected subtree task, but got %q", task.Operation)
+	}
+	// Обычное время подвыражения - сумма времен его операций, а не медиана других подвыражений
+	if expected := s.expectedDuration(task); expected != 300*time.Millisecond {
+		t.Errorf("Expected subtree to take 300ms, but got %v", expected)
+	}
+	s.recordTaskTime(task, "a")
+	if _, ok := s.agents.median("a", subtreeOperation); ok {
+		t.Error("Expected subtree time not to be recorded")
+	}
+}
@@ -0,0 +1,202 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/f1rsov08/go_calc_2/pkg/calculation"
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+// Операция задачи, которая вычисляет подвыражение из поля tree целиком
+const subtreeOperation = "subtree"
+
+// resolveTask проверяет, вычислены ли аргументы задачи, и подставляет их значения.
+// Возвращает ошибку, если задача еще ждет результатов других задач
+func resolveTask(ctx context.Context, db querier, task Task) (candidate, error) {
+	c := candidate{task: task}
+	if task.Operation != subtreeOperation {
+		p1, arg1, err := getResult(ctx, db, task.Arg1)
+		if err != nil {
+			return c, err
+		}
+		p2, arg2, err := getResult(ctx, db, task.Arg2)
+		if err != nil {
+			return c, err
+		}
+		for _, p := range []int{p1, p2} {
+			if p >= 0 {
+				c.deps = append(c.deps, p)
+			}
+		}
+		c.arg1, c.arg2 = arg1, arg2
+		return c, nil
+	}
+
+	tree, err := parseTree(task)
+	if err != nil {
+		return c, err
+	}
+	resolved, err := resolveTree(ctx, db, tree, &c.deps)
+	if err != nil {
+		return c, err
+	}
+	c.tree = resolved
+	return c, nil
+}
+
+// parseTree разбирает подвыражение задачи subtree
+func parseTree(task Task) (*calculation.Node, error) {
+	var tree calculation.Node
+	if err := json.Unmarshal([]byte(task.Tree), &tree); err != nil {
+		return nil, fmt.Errorf("task %d has invalid subexpression: %w", task.ID, err)
+	}
+	return &tree, nil
+}
+
+// walkTree вызывает visit для каждого узла подвыражения
+func walkTree(node *calculation.Node, visit func(node *calculation.Node)) {
+	visit(node)
+	for _, arg := range node.Args {
+		walkTree(arg, visit)
+	}
+}
+
+// resolveTree заменяет ссылки id<N> в подвыражении результатами задач и запоминает эти задачи в deps
+func resolveTree(ctx context.Context, db querier, node *calculation.Node, deps *[]int) (*calculation.Node, error) {
+	if node.Var != "" {
+		id, value, err := getResult(ctx, db, node.Var)
+		if err != nil {
+			return nil, err
+		}
+		if id >= 0 {
+			*deps = append(*deps, id)
+		}
+		return &calculation.Node{Value: value}, nil
+	}
+	resolved := &calculation.Node{Value: node.Value, Op: node.Op}
+	for _, arg := range node.Args {
+		r, err := resolveTree(ctx, db, arg, deps)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Args = append(resolved.Args, r)
+	}
+	return resolved, nil
+}
+
+// taskRefs возвращает ссылки id<N> на задачи, результаты которых нужны задаче
+func taskRefs(task Task) ([]string, error) {
+	if task.Operation != subtreeOperation {
+		var refs []string
+		for _, arg := range []string{task.Arg1, task.Arg2} {
+			if strings.HasPrefix(arg, "id") {
+				refs = append(refs, arg)
+			}
+		}
+		return refs, nil
+	}
+	tree, err := parseTree(task)
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	walkTree(tree, func(node *calculation.Node) {
+		if node.Var != "" {
+			refs = append(refs, node.Var)
+		}
+	})
+	return refs, nil
+}
+
+// runnableBy проверяет, может ли агент вычислить задачу с подставленными аргументами
+func (c candidate) runnableBy(agent *pb.AgentInfo) bool {
+	if !matchesLabels(agent, c.task.Labels) {
+		return false
+	}
+	if c.tree == nil {
+		return capable(agent, c.task.Operation, c.arg1, c.arg2)
+	}
+	return capableTree(agent, c.tree)
+}
+
+// capableTree проверяет, поддерживает ли агент все операции подвыражения и укладываются ли его числа
+// в ограничение агента. Промежуточные результаты заранее не известны и не проверяются
+func capableTree(agent *pb.AgentInfo, node *calculation.Node) bool {
+	if node.IsNumber() {
+		return acceptsOperands(agent, node.Value)
+	}
+	if !supportsOperation(agent, node.Op) {
+		return false
+	}
+	for _, arg := range node.Args {
+		if !capableTree(agent, arg) {
+			return false
+		}
+	}
+	return true
+}
+
+// treeOperationTime возвращает время вычисления подвыражения - сумму времен его операций
+func (s *Server) treeOperationTime(tree *calculation.Node) time.Duration {
+	var total time.Duration
+	walkTree(tree, func(node *calculation.Node) {
+		if node.Op != "" {
+			total += s.operationTime(node.Op)
+		}
+	})
+	return total
+}
+
+// taskMessage возвращает задачу для агента. Подвыражение передается деревом вместе со временем
+// каждой его операции, чтобы агент выдержал их так же, как для отдельных задач
+func (s *Server) taskMessage(c candidate) (*pb.Task, error) {
+	task := &pb.Task{
+		Id:            int64(c.task.ID),
+		Arg1:          float32(c.arg1),
+		Arg2:          float32(c.arg2),
+		Operation:     c.task.Operation,
+		OperationTime: s.operationTime(c.task.Operation).Milliseconds(),
+	}
+	if c.tree == nil {
+		return task, nil
+	}
+	task.OperationTime = s.treeOperationTime(c.tree).Milliseconds()
+	data, err := json.Marshal(c.tree)
+	if err != nil {
+		return nil, err
+	}
+	task.Expression = string(data)
+	task.OperationTimes = make(map[string]int64)
+	walkTree(c.tree, func(node *calculation.Node) {
+		if node.Op != "" {
+			task.OperationTimes[node.Op] = s.operationTime(node.Op).Milliseconds()
+		}
+	})
+	return task, nil
+}
+
+// markCandidateCalculating выдает задачу агенту и сохраняет в ней подставленные аргументы,
+// чтобы ее можно было выдать повторно после удаления задач-аргументов
+func markCandidateCalculating(ctx context.Context, db querier, c candidate, agentID string, startedAt int64, leaseUntil int64) error {
+	if err := markTaskCalculating(ctx, db, c.task.ID, c.arg1, c.arg2, agentID, startedAt, leaseUntil); err != nil {
+		return err
+	}
+	if c.tree == nil {
+		return nil
+	}
+	data, err := json.Marshal(c.tree)
+	if err != nil {
+		return err
+	}
+	return updateTaskField(ctx, db, c.task.ID, "tree", string(data))
+}
+
+// refID возвращает идентификатор задачи из ссылки id<N>
+func refID(ref string) (int, error) {
+	return strconv.Atoi(strings.TrimPrefix(ref, "id"))
+}
@@ -0,0 +1,41 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTaskChunks(t *testing.T) {
+	cases := []struct {
+		granularity int
+		tasks       int
+	}{
+		{1, 5},
+		// (1+2)*(3+4) становится одной задачей, а вычитание и деление - второй
+		{3, 2},
+		{5, 1},
+		{100, 1},
+	}
+	for _, c := range cases {
+		db := newTestDB(t)
+		ctx := context.Background()
+		userID := newTestUser(t, db, "user")
+		id := submitTestChunks(t, db, userID, "(1+2)*(3+4)-10/5", c.granularity)
+		tasks, err := selectTasks(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks) != c.tasks {
+			t.Errorf("Granularity %d: expected %d tasks, but got %d", c.granularity, c.tasks, len(tasks))
+		}
+
+		computeAll(t, NewServer(ConfigFromEnv()), db)
+		expression, err := selectExpressionByID(ctx, db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expression.Status != "complete" || expression.Result != 19 {
+			t.Errorf("Granularity %d: expected 19, but got %q %v", c.granularity, expression.Status, expression.Result)
+		}
+	}
+}
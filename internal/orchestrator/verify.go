@@ -0,0 +1,191 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// Количество результатов проверяемой задачи, после которого задача без двух совпавших
+// результатов переводится в dead letter
+const maxVerifyRuns = 5
+
+// Результат проверяемой задачи, присланный одним из агентов
+type taskVote struct {
+	TaskID  int
+	AgentID string
+	Result  float64
+}
+
+// Расхождение результата агента с принятым результатом задачи
+type Mismatch struct {
+	AgentID   string  `json:"agent_id"`
+	TaskID    int     `json:"task_id"`
+	Result    float64 `json:"result"`   // Результат агента
+	Accepted  float64 `json:"accepted"` // Результат, с которым согласились другие агенты
+	CreatedAt int64   `json:"created_at"`
+}
+
+func insertTaskVote(ctx context.Context, db querier, vote taskVote) error {
+	q := "INSERT INTO task_votes (task_id, agent_id, result) values ($1, $2, $3)"
+	_, err := db.ExecContext(ctx, q, vote.TaskID, vote.AgentID, vote.Result)
+	return err
+}
+
+func selectTaskVotes(ctx context.Context, db querier) ([]taskVote, error) {
+	var votes []taskVote
+	rows, err := db.QueryContext(ctx, "SELECT task_id, agent_id, result FROM task_votes ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v := taskVote{}
+		if err := rows.Scan(&v.TaskID, &v.AgentID, &v.Result); err != nil {
+			return nil, err
+		}
+		votes = append(votes, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+func selectTaskVotesByTaskID(ctx context.Context, db querier, taskID int) ([]taskVote, error) {
+	var votes []taskVote
+	rows, err := db.QueryContext(ctx, "SELECT task_id, agent_id, result FROM task_votes WHERE task_id = $1 ORDER BY id", taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v := taskVote{}
+		if err := rows.Scan(&v.TaskID, &v.AgentID, &v.Result); err != nil {
+			return nil, err
+		}
+		votes = append(votes, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+func deleteTaskVotes(ctx context.Context, db querier, taskID int) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM task_votes WHERE task_id = $1", taskID)
+	return err
+}
+
+func insertMismatch(ctx context.Context, db querier, m Mismatch) error {
+	q := "INSERT INTO agent_mismatches (agent_id, task_id, result, accepted, created_at) values ($1, $2, $3, $4, $5)"
+	_, err := db.ExecContext(ctx, q, m.AgentID, m.TaskID, m.Result, m.Accepted, m.CreatedAt)
+	return err
+}
+
+func selectMismatches(ctx context.Context, db querier) ([]Mismatch, error) {
+	var mismatches []Mismatch
+	q := "SELECT agent_id, task_id, result, accepted, created_at FROM agent_mismatches ORDER BY id"
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m := Mismatch{}
+		if err := rows.Scan(&m.AgentID, &m.TaskID, &m.Result, &m.Accepted, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return mismatches, nil
+}
+
+// taskVoters возвращает агентов, уже приславших результат каждой из проверяемых задач
+func taskVoters(ctx context.Context, db querier) (map[int]map[string]bool, error) {
+	votes, err := selectTaskVotes(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	voters := make(map[int]map[string]bool)
+	for _, vote := range votes {
+		if voters[vote.TaskID] == nil {
+			voters[vote.TaskID] = make(map[string]bool)
+		}
+		voters[vote.TaskID][vote.AgentID] = true
+	}
+	return voters, nil
+}
+
+// verifyResult учитывает результат проверяемой задачи. Задача проверяется, если у нее уже есть
+// результаты других агентов или она попала в долю VerifyFraction. Возвращает true, если результат
+// подтвержден другим агентом и его можно принять; иначе задача ждет в очереди агента, который ее еще не
+// вычислял. Повторный результат того же агента не учитывается: совпадение засчитывается только между разными агентами.
+// Агенты, результаты которых разошлись с подтвержденным, записываются в agent_mismatches.
+// current означает, что результат прислал агент, которому задача выдана сейчас
+func (s *Server) verifyResult(ctx context.Context, db querier, task Task, agentID string, current bool, result float64) (bool, error) {
+	votes, err := selectTaskVotesByTaskID(ctx, db, task.ID)
+	if err != nil {
+		return false, err
+	}
+	// Опоздавший результат предыдущего агента принимается только для непроверяемой задачи:
+	// у проверяемой он уже заменен новой выдачей
+	if !current {
+		return len(votes) == 0, nil
+	}
+	if len(votes) == 0 && (s.config.VerifyFraction <= 0 || rand.Float64() >= s.config.VerifyFraction) {
+		return true, nil
+	}
+	// Результаты сравниваются с той точностью, с которой их передает агент
+	agreed, voted := false, false
+	for _, vote := range votes {
+		switch {
+		case vote.AgentID == agentID:
+			voted = true
+		case float32(vote.Result) == float32(result):
+			agreed = true
+		}
+	}
+	if voted && !agreed {
+		log.Printf("agent %s already returned result of task %d, waiting for another agent", agentID, task.ID)
+		return false, updateTaskRetry(ctx, db, task.ID, "waiting", task.Attempts, 0, task.LastError)
+	}
+	if !agreed {
+		if err := insertTaskVote(ctx, db, taskVote{TaskID: task.ID, AgentID: agentID, Result: result}); err != nil {
+			return false, err
+		}
+		if len(votes)+1 >= maxVerifyRuns {
+			reason := fmt.Sprintf("%d agents returned different results", len(votes)+1)
+			log.Printf("task %d moved to dead letter: %s", task.ID, reason)
+			return false, markDead(ctx, db, task, task.Attempts, reason)
+		}
+		// Задача снова ждет агента, попытка вычисления не считается неудачной
+		return false, updateTaskRetry(ctx, db, task.ID, "waiting", task.Attempts, 0, task.LastError)
+	}
+
+	now := time.Now().UnixMilli()
+	for _, vote := range votes {
+		if vote.AgentID == agentID {
+			continue
+		}
+		if float32(vote.Result) == float32(result) {
+			s.agents.record(vote.AgentID, outcomeSuccess)
+			continue
+		}
+		s.agents.record(vote.AgentID, outcomeMismatch)
+		log.Printf("agent %s returned %v for task %d, but other agents agreed on %v", vote.AgentID, vote.Result, task.ID, result)
+		m := Mismatch{AgentID: vote.AgentID, TaskID: task.ID, Result: vote.Result, Accepted: result, CreatedAt: now}
+		if err := insertMismatch(ctx, db, m); err != nil {
+			return false, err
+		}
+	}
+	return true, deleteTaskVotes(ctx, db, task.ID)
+}
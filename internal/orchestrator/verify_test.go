@@ -0,0 +1,162 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestVerifyResult(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+	exprID := submitTestExpression(t, db, userID, "1+2")
+
+	config := ConfigFromEnv()
+	config.VerifyFraction = 1
+	s := NewServer(config)
+	agents := map[string]*pb.AgentInfo{}
+	for _, id := range []string{"a", "b", "c"} {
+		agents[id] = &pb.AgentInfo{Id: id}
+		s.agents.seen(agents[id])
+	}
+
+	// compute выдает задачу агенту и отправляет его результат
+	compute := func(agentID string, result float32) bool {
+		t.Helper()
+		tasks, err := s.claimTasks(ctx, db, agents[agentID], 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tasks) == 0 {
+			return false
+		}
+		if err := s.applyResult(ctx, db, agentID, &pb.PostResultRequest{Id: tasks[0].Id, Result: result}); err != nil {
+			t.Fatal(err)
+		}
+		return true
+	}
+	status := func() string {
+		t.Helper()
+		expression, err := selectExpressionByID(ctx, db, exprID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return expression.Status
+	}
+
+	if !compute("a", 3) {
+		t.Fatal("Expected task to be claimed by agent a")
+	}
+	if status() != "waiting" {
+		t.Fatalf("Expected result of a single agent not to be accepted, but got status %q", status())
+	}
+	// Второй результат должен прислать другой агент
+	if compute("a", 3) {
+		t.Fatal("Expected task not to be claimed by agent a again")
+	}
+	if !compute("b", 4) || status() != "waiting" {
+		t.Fatalf("Expected disagreeing results not to be accepted, but got status %q", status())
+	}
+	if !compute("c", 3) {
+		t.Fatal("Expected task to be claimed by agent c")
+	}
+
+	expression, err := selectExpressionByID(ctx, db, exprID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "complete" || expression.Result != 3 {
+		t.Fatalf("Expected expression to complete with 3, but got %q %v", expression.Status, expression.Result)
+	}
+	mismatches, err := selectMismatches(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].AgentID != "b" || mismatches[0].Result != 4 || mismatches[0].Accepted != 3 {
+		t.Fatalf("Expected agent b to be flagged, but got %+v", mismatches)
+	}
+}
+
+func TestVerifySingleAgent(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "user")
+	exprID := submitTestExpression(t, db, userID, "1+2")
+
+	config := ConfigFromEnv()
+	config.VerifyFraction = 1
+	s := NewServer(config)
+	agent := &pb.AgentInfo{Id: "a"}
+	s.agents.seen(agent)
+
+	tasks, err := s.claimTasks(ctx, db, agent, 1)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("Expected task to be claimed, but got %v (%v)", tasks, err)
+	}
+	if err := s.applyResult(ctx, db, "a", &pb.PostResultRequest{Id: tasks[0].Id, Result: 3}); err != nil {
+		t.Fatal(err)
+	}
+	// Других агентов нет, но тот же агент задачу повторно не получает
+	if tasks, err := s.claimTasks(ctx, db, agent, 1); err != nil || len(tasks) != 0 {
+		t.Fatalf("Expected task not to be claimed by the same agent again, but got %v (%v)", tasks, err)
+	}
+
+	// Повторный результат того же агента не подтверждает задачу и не добавляет голос
+	task, err := selectTaskByID(ctx, db, int(tasks[0].Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := s.verifyResult(ctx, db, task, "a", true, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted {
+		t.Error("Expected two results of one agent not to be accepted")
+	}
+	votes, err := selectTaskVotesByTaskID(ctx, db, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(votes) != 1 {
+		t.Errorf("Expected one vote of agent a, but got %+v", votes)
+	}
+	expression, err := selectExpressionByID(ctx, db, exprID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "waiting" {
+		t.Errorf("Expected expression to wait for another agent, but got %q", expression.Status)
+	}
+	// Единственный агент уже прислал результат, поэтому подходящих агентов для задачи нет
+	s.config.NoCapableAgentWait = 0
+	if err := s.checkCapableAgents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expression, err = selectExpressionByID(ctx, db, exprID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != statusNoCapableAgent {
+		t.Errorf("Expected expression to be %q, but got %q", statusNoCapableAgent, expression.Status)
+	}
+
+	// Результат появившегося другого агента подтверждает задачу
+	other := &pb.AgentInfo{Id: "b"}
+	s.agents.seen(other)
+	tasks, err = s.claimTasks(ctx, db, other, 1)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("Expected task to be claimed by agent b, but got %v (%v)", tasks, err)
+	}
+	if err := s.applyResult(ctx, db, "b", &pb.PostResultRequest{Id: tasks[0].Id, Result: 3}); err != nil {
+		t.Fatal(err)
+	}
+	expression, err = selectExpressionByID(ctx, db, exprID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "complete" || expression.Result != 3 {
+		t.Errorf("Expected expression to complete with 3, but got %q %v", expression.Status, expression.Result)
+	}
+}
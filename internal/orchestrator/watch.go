@@ -0,0 +1,155 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"time"
+)
+
+// Статус выражения, для задач которого нет ни одного подходящего агента
+const statusNoCapableAgent = "waiting: no capable agent"
+
+// Периодичность фоновых проверок
+const watchInterval = time.Second
+
+// watch периодически проверяет состояние ожидающих задач
+func (s *Server) watch() {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+		if err := s.checkCapableAgents(context.Background()); err != nil {
+			log.Println("capable agents check failed:", err)
+		}
+		if err := s.checkDeadlines(context.Background()); err != nil {
+			log.Println("deadlines check failed:", err)
+		}
+		if err := s.checkLeases(context.Background()); err != nil {
+			log.Println("leases check failed:", err)
+		}
+	}
+}
+
+// checkCapableAgents помечает выражения, готовые задачи которых дольше NoCapableAgentWait
+// не может выполнить ни один из подключенных агентов, и снимает пометку, когда такой агент появляется.
+// Проверяемую задачу может выполнить только агент, еще не приславший ее результат
+func (s *Server) checkCapableAgents(ctx context.Context) error {
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks, err := selectTasks(ctx, db)
+	if err != nil {
+		return err
+	}
+	agents := s.agents.available(s.config.AgentTimeout)
+	voters, err := taskVoters(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	unroutable := make(map[int]time.Time)
+	stuck := make(map[int]bool)
+	for _, task := range tasks {
+		if _, ok := stuck[task.ExpressionID]; !ok {
+			stuck[task.ExpressionID] = false
+		}
+		if task.Status != "waiting" {
+			continue
+		}
+		c, err := resolveTask(ctx, db, task)
+		if err != nil {
+			continue
+		}
+		routable := false
+		for _, agent := range agents {
+			if c.runnableBy(agent) && !voters[task.ID][agent.GetId()] {
+				routable = true
+				break
+			}
+		}
+		if routable {
+			continue
+		}
+		since, ok := s.unroutable[task.ID]
+		if !ok {
+			since = time.Now()
+		}
+		unroutable[task.ID] = since
+		if time.Since(since) >= s.config.NoCapableAgentWait {
+			stuck[task.ExpressionID] = true
+		}
+	}
+	s.unroutable = unroutable
+
+	for id, isStuck := range stuck {
+		expression, err := selectExpressionByID(ctx, db, id)
+		if err != nil {
+			continue
+		}
+		if isStuck && expression.Status == "waiting" {
+			err = updateExpressionField(ctx, db, id, "status", statusNoCapableAgent)
+		} else if !isStuck && expression.Status == statusNoCapableAgent {
+			err = updateExpressionField(ctx, db, id, "status", "waiting")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errInvalidTimeout = errors.New("invalid timeout")
+
+// expressionTimeout возвращает время на вычисление выражения: запрошенное в миллисекундах или DefaultTimeout,
+// но не больше MaxTimeout. 0 - без ограничения
+func (c *Config) expressionTimeout(timeoutMs *int64) (time.Duration, error) {
+	timeout := c.DefaultTimeout
+	if timeoutMs != nil {
+		// Слишком большое значение переполнило бы time.Duration
+		if *timeoutMs <= 0 || *timeoutMs > math.MaxInt64/int64(time.Millisecond) {
+			return 0, errInvalidTimeout
+		}
+		timeout = time.Duration(*timeoutMs) * time.Millisecond
+	}
+	if c.MaxTimeout > 0 && (timeout == 0 || timeout > c.MaxTimeout) {
+		timeout = c.MaxTimeout
+	}
+	return timeout, nil
+}
+
+// checkDeadlines завершает с ошибкой выражения, не вычисленные до крайнего срока.
+// Их задачи удаляются, поэтому агенты узнают об отмене, а поздние результаты отбрасываются
+func (s *Server) checkDeadlines(ctx context.Context) error {
+	db, err := sql.Open("sqlite3", "store.db")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expressions, err := selectExpiredExpressions(ctx, db, time.Now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	for _, expression := range expressions {
+		if err := setError(ctx, db, expression.ID, "timeout"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
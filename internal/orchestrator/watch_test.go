@@ -0,0 +1,116 @@
+package orchestrator
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	pb "github.com/f1rsov08/go_calc_2/proto"
+)
+
+func TestExpressionTimeout(t *testing.T) {
+	ms := func(v int64) *int64 { return &v }
+	limit := int64(math.MaxInt64) / int64(time.Millisecond)
+	tests := []struct {
+		defaultTimeout time.Duration
+		maxTimeout     time.Duration
+		timeoutMs      *int64
+		expected       time.Duration
+		valid          bool
+	}{
+		{0, 0, nil, 0, true},
+		{time.Second, 0, nil, time.Second, true},
+		{time.Second, 0, ms(500), 500 * time.Millisecond, true},
+		{0, time.Minute, nil, time.Minute, true},        // Без ограничения нельзя, если задан MaxTimeout
+		{0, time.Minute, ms(120000), time.Minute, true}, // Запрошенное время ограничено сверху
+		{0, time.Minute, ms(limit), time.Minute, true},  // Наибольшее значение без переполнения
+		{0, 0, ms(0), 0, false},
+		{0, 0, ms(-1), 0, false},
+		{0, time.Minute, ms(limit + 1), 0, false}, // Переполнение time.Duration
+		{0, 0, ms(math.MaxInt64), 0, false},
+	}
+
+	for _, test := range tests {
+		config := &Config{DefaultTimeout: test.defaultTimeout, MaxTimeout: test.maxTimeout}
+		timeout, err := config.expressionTimeout(test.timeoutMs)
+		if valid := err == nil; valid != test.valid || timeout != test.expected {
+			t.Errorf("For %+v expected %v (valid %v), but got %v (%v)", test, test.expected, test.valid, timeout, err)
+		}
+	}
+}
+
+func TestCheckDeadlines(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "alice")
+	s := NewServer(ConfigFromEnv())
+
+	expired := submitTestExpression(t, db, userID, "1+2")
+	pending := submitTestExpression(t, db, userID, "3+4")
+	now := time.Now().UnixMilli()
+	if err := updateExpressionField(ctx, db, expired, "deadline", now-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateExpressionField(ctx, db, pending, "deadline", now+time.Hour.Milliseconds()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkDeadlines(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expression, err := selectExpressionByID(ctx, db, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "error: timeout" {
+		t.Errorf("Expected expired expression to fail with timeout, but got %q", expression.Status)
+	}
+	expression, err = selectExpressionByID(ctx, db, pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "waiting" {
+		t.Errorf("Expected expression before its deadline to keep waiting, but got %q", expression.Status)
+	}
+	// Задачи просроченного выражения удаляются, остальные остаются
+	tasks, err := selectTasks(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range tasks {
+		if task.ExpressionID == expired {
+			t.Errorf("Expected tasks of expired expression to be deleted, but got %+v", task)
+		}
+	}
+	if len(tasks) == 0 {
+		t.Error("Expected tasks of pending expression to remain")
+	}
+}
+
+func TestLateResultAfterDeadline(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	userID := newTestUser(t, db, "alice")
+	s := NewServer(ConfigFromEnv())
+
+	// Крайний срок прошел, но checkDeadlines еще не успел его проверить
+	exprID := submitTestExpression(t, db, userID, "1+2")
+	tasks, err := s.claimTasks(ctx, db, &pb.AgentInfo{Id: "agent"}, 1)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("Expected task to be claimed, but got %v (%v)", tasks, err)
+	}
+	if err := updateExpressionField(ctx, db, exprID, "deadline", time.Now().UnixMilli()-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.applyResult(ctx, db, "agent", &pb.PostResultRequest{Id: tasks[0].Id, Result: 3}); err != nil {
+		t.Fatal(err)
+	}
+	expression, err := selectExpressionByID(ctx, db, exprID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expression.Status != "error: timeout" {
+		t.Errorf("Expected result after deadline to fail with timeout, but got %q", expression.Status)
+	}
+}
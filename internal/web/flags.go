@@ -0,0 +1,11 @@
+package web
+
+import "flag"
+
+// RegisterFlags регистрирует флаги командной строки, повторяющие переменные окружения.
+// Значения по умолчанию берутся из текущей конфигурации, поэтому флаги переопределяют окружение
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "web-port", c.Addr, "порт веб-интерфейса (WEB_PORT)")
+	fs.StringVar(&c.APIURL, "api-url", c.APIURL, "адрес HTTP API оркестратора (API_URL)")
+	fs.StringVar(&c.Token, "web-token", c.Token, "токен для обращения к API (WEB_TOKEN)")
+}
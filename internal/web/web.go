@@ -2,16 +2,63 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"net/http"
+	"os"
 	"strconv"
 )
 
+type Config struct {
+	Addr   string // Порт, на котором будет запущен веб-интерфейс
+	APIURL string // Адрес HTTP API оркестратора
+	Token  string // Токен, с которым веб-интерфейс обращается к API
+}
+
+// Функция для создания конфигурации из переменных окружения
+func ConfigFromEnv() *Config {
+	config := new(Config)
+	config.Addr = os.Getenv("WEB_PORT")
+	if config.Addr == "" {
+		config.Addr = "8081"
+	}
+	config.APIURL = os.Getenv("API_URL")
+	if config.APIURL == "" {
+		config.APIURL = "http://localhost:8080"
+	}
+	config.Token = os.Getenv("WEB_TOKEN")
+	return config
+}
+
+// Структура приложения, содержащая конфигурацию
+type Application struct {
+	config     *Config
+	httpServer *http.Server
+	pageData   PageData
+}
+
+// Функция для создания нового экземпляра приложения
+func New() *Application {
+	return NewWithConfig(ConfigFromEnv())
+}
+
+// Функция для создания нового экземпляра приложения с заданной конфигурацией
+func NewWithConfig(config *Config) *Application {
+	a := &Application{
+		config: config,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", a.indexHandler)
+	a.httpServer = &http.Server{Addr: ":" + config.Addr, Handler: mux}
+	return a
+}
+
 type Expression struct {
-	ID     string `json:"id"`
-	Status string `json:"status"`
-	Result string `json:"result"`
+	ID     int     `json:"id"`
+	Status string  `json:"status"`
+	Result float64 `json:"result"`
 }
 
 type ErrorR struct {
@@ -27,22 +74,28 @@ type PageData struct {
 	Info        string
 }
 
-var pageData PageData
+// Метод для запуска веб-интерфейса
+func (a *Application) Run() error {
+	if err := a.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
 
-func Main() {
-	http.HandleFunc("/", indexHandler)
-	http.ListenAndServe(":8080", nil)
+// Shutdown останавливает веб-интерфейс
+func (a *Application) Shutdown(ctx context.Context) error {
+	return a.httpServer.Shutdown(ctx)
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
+func (a *Application) indexHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		r.ParseForm()
 		expression := r.FormValue("expression")
-		info := sendCalculationRequest(expression)
-		pageData.Info = info
+		info := a.sendCalculationRequest(expression)
+		a.pageData.Info = info
 	}
 
-	pageData.Expressions = fetchExpressions()
+	a.pageData.Expressions = a.fetchExpressions()
 
 	tmpl := `
  <!DOCTYPE html>
@@ -77,14 +130,27 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
  </body>
  </html>`
 	t, _ := template.New("index").Parse(tmpl)
-	t.Execute(w, pageData)
+	t.Execute(w, a.pageData)
+}
+
+// request выполняет запрос к API оркестратора от имени пользователя веб-интерфейса
+func (a *Application) request(method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, a.config.APIURL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.config.Token)
+	}
+	return http.DefaultClient.Do(req)
 }
 
-func sendCalculationRequest(expression string) string {
+func (a *Application) sendCalculationRequest(expression string) string {
 	data := map[string]string{"expression": expression}
 	jsonData, _ := json.Marshal(data)
 
-	resp, err := http.Post("http://localhost:8080/calculate", "application/json", bytes.NewBuffer(jsonData))
+	resp, err := a.request(http.MethodPost, "/api/v1/calculate", jsonData)
 	if err != nil {
 		return "Ошибка при отправке запроса: " + err.Error()
 	}
@@ -93,7 +159,7 @@ func sendCalculationRequest(expression string) string {
 	var idResponse IDR
 	var errorResponse ErrorR
 
-	if resp.StatusCode == http.StatusOK {
+	if resp.StatusCode == http.StatusCreated {
 		json.NewDecoder(resp.Body).Decode(&idResponse)
 		return "ID: " + strconv.Itoa(idResponse.ID)
 	} else {
@@ -102,8 +168,8 @@ func sendCalculationRequest(expression string) string {
 	}
 }
 
-func fetchExpressions() []Expression {
-	resp, err := http.Get("http://localhost:8080/expressions")
+func (a *Application) fetchExpressions() []Expression {
+	resp, err := a.request(http.MethodGet, "/api/v1/expressions", nil)
 	if err != nil {
 		return nil
 	}
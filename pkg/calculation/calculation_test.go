@@ -1,10 +1,35 @@
 package calculation
 
 import (
+	"math"
 	"testing"
+
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
 )
 
+// registerTestOperations регистрирует операции, регистрация которых должна сделать их доступными
+// в выражениях без изменений парсера, и удаляет их из реестра по окончании теста
+func registerTestOperations(t *testing.T) {
+	t.Helper()
+	operation.Register(operation.Operation{
+		Name:  "mod",
+		Arity: 2,
+		Func:  func(args ...float64) (float64, error) { return math.Mod(args[0], args[1]), nil },
+	})
+	operation.Register(operation.Operation{
+		Name:       "//",
+		Arity:      2,
+		Precedence: operation.PrecedenceMultiplicative,
+		Func:       func(args ...float64) (float64, error) { return math.Floor(args[0] / args[1]), nil },
+	})
+	t.Cleanup(func() {
+		operation.Remove("mod")
+		operation.Remove("//")
+	})
+}
+
 func TestCalc(t *testing.T) {
+	registerTestOperations(t)
 	tests := []struct {
 		expression string
 		expected   float64
@@ -22,6 +47,20 @@ func TestCalc(t *testing.T) {
 		{"abc", 0, true},         // Лишние символы
 		{"", 0, true},            // Пустое выражение
 		{"(3 - 1) * (4 / 2)", 4, false},
+		{"-(1 + 2) * 2", -6, false}, // Минус перед скобкой
+		{"8 / 2 / 2", 2, false},     // Операции одного приоритета выполняются слева направо
+		{"1 2", 0, true},            // Пропущена операция
+		{"2 *", 0, true},            // Пропущен аргумент
+		{"1 % 2", 0, true},          // Незарегистрированная операция
+		{"mod(7, 4)", 3, false},     // Функция, зарегистрированная в тесте
+		{"7 // 2 + 1", 4, false},    // Инфиксная операция, зарегистрированная в тесте
+		{"mod(7)", 0, true},         // Неверное количество аргументов
+		{"unknown(1, 2)", 0, true},  // Незарегистрированная функция
+		{"2^10", 1024, false},       // Возведение в степень
+		{"2^3^2", 512, false},       // Степень выполняется справа налево
+		{"-2^2", -4, false},         // Унарный минус слабее степени
+		{"2^-1", 0.5, false},        // Отрицательный показатель
+		{"x + 1", 0, true},          // Переменная вне определения функции
 	}
 
 	for _, test := range tests {
@@ -41,3 +80,22 @@ func TestCalc(t *testing.T) {
 		})
 	}
 }
+
+func TestIsIdentifier(t *testing.T) {
+	tests := map[string]bool{
+		"annuity": true,
+		"_f2":     true,
+		"функция": true,
+		"":        false,
+		"2f":      false,
+		"a-b":     false,
+		"f(x)":    false,
+		"mod //":  false,
+		"x y":     false,
+	}
+	for name, expected := range tests {
+		if IsIdentifier(name) != expected {
+			t.Errorf("Expected IsIdentifier(%q) to be %v", name, expected)
+		}
+	}
+}
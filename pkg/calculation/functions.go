@@ -0,0 +1,158 @@
+package calculation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
+)
+
+// Предельное количество узлов выражения после подстановки функций. Параметр, который
+// встречается в теле функции несколько раз, подставляется несколько раз, поэтому вложенные
+// функции могут увеличивать выражение очень быстро
+const MaxInlinedNodes = 10000
+
+// Function - пользовательская функция вида name(x, y) = x^2 + 3*y
+type Function struct {
+	Name   string
+	Params []string
+	Body   *Node
+}
+
+// ParseFunction разбирает определение функции вида name(x, y) = x^2 + 3*y.
+// Тело может вызывать другие функции, они проверяются при подстановке
+func ParseFunction(definition string) (*Function, error) {
+	tokens, err := tokenize(definition)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+
+	name := p.next()
+	if name.kind != tokenIdent {
+		return nil, p.unexpected(name)
+	}
+	if _, ok := operation.Lookup(name.text); ok {
+		return nil, fmt.Errorf("%w: %q is a built-in operation", ErrInvalidExpression, name.text)
+	}
+	if t := p.next(); t.kind != tokenLeftParen {
+		return nil, p.unexpected(t)
+	}
+	var params []string
+	for p.peek().kind != tokenRightParen {
+		if len(params) > 0 {
+			if t := p.next(); t.kind != tokenComma {
+				return nil, p.unexpected(t)
+			}
+		}
+		param := p.next()
+		if param.kind != tokenIdent {
+			return nil, p.unexpected(param)
+		}
+		for _, existing := range params {
+			if existing == param.text {
+				return nil, fmt.Errorf("%w: duplicate parameter %q", ErrInvalidExpression, param.text)
+			}
+		}
+		params = append(params, param.text)
+	}
+	p.next()
+	if t := p.next(); t.kind != tokenAssign {
+		return nil, p.unexpected(t)
+	}
+
+	p.params = params
+	body, err := p.expression(1)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, p.unexpected(t)
+	}
+	return &Function{Name: name.text, Params: params, Body: body}, nil
+}
+
+// Resolver возвращает пользовательскую функцию по имени
+type Resolver func(name string) (*Function, error)
+
+// Inline подставляет в выражение тела пользовательских функций, пока в нем не останутся
+// только операции из реестра. Рекурсия и вложенность глубже maxDepth считаются ошибкой
+func Inline(node *Node, resolve Resolver, maxDepth int) (*Node, error) {
+	in := &inliner{resolve: resolve, maxDepth: maxDepth}
+	return in.inline(node, nil, nil)
+}
+
+type inliner struct {
+	resolve  Resolver
+	maxDepth int
+	nodes    int // Количество узлов результата
+}
+
+// inline подставляет функции в node. env содержит уже подставленные аргументы вызванной функции,
+// stack - цепочку вызовов, которая привела к node
+func (in *inliner) inline(node *Node, env map[string]*Node, stack []string) (*Node, error) {
+	if node.Var != "" {
+		if arg, ok := env[node.Var]; ok {
+			return in.copy(arg)
+		}
+		// Параметр внешней функции остается, если тело проверяется без аргументов
+		return in.copy(node)
+	}
+	if node.IsNumber() {
+		return in.copy(node)
+	}
+
+	args := make([]*Node, len(node.Args))
+	for i, arg := range node.Args {
+		inlined, err := in.inline(arg, env, stack)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = inlined
+	}
+	if node.Op != "" {
+		in.nodes++
+		if in.nodes > MaxInlinedNodes {
+			return nil, fmt.Errorf("%w: expression has more than %d nodes", ErrInvalidExpression, MaxInlinedNodes)
+		}
+		return &Node{Op: node.Op, Args: args}, nil
+	}
+
+	for _, name := range stack {
+		if name == node.Func {
+			return nil, fmt.Errorf("%w: recursive function %s -> %s", ErrInvalidExpression, strings.Join(stack, " -> "), node.Func)
+		}
+	}
+	if len(stack) >= in.maxDepth {
+		return nil, fmt.Errorf("%w: functions are nested deeper than %d", ErrInvalidExpression, in.maxDepth)
+	}
+	fn, err := in.resolve(node.Func)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(fn.Params) {
+		return nil, fmt.Errorf("%w: function %q expects %d arguments, but got %d", ErrInvalidExpression, fn.Name, len(fn.Params), len(args))
+	}
+	bodyEnv := make(map[string]*Node, len(args))
+	for i, param := range fn.Params {
+		bodyEnv[param] = args[i]
+	}
+	return in.inline(fn.Body, bodyEnv, append(stack[:len(stack):len(stack)], node.Func))
+}
+
+// copy копирует подставленное поддерево, учитывая его узлы в общем количестве
+func (in *inliner) copy(node *Node) (*Node, error) {
+	in.nodes++
+	if in.nodes > MaxInlinedNodes {
+		return nil, fmt.Errorf("%w: expression has more than %d nodes", ErrInvalidExpression, MaxInlinedNodes)
+	}
+	c := &Node{Value: node.Value, Op: node.Op, Var: node.Var, Func: node.Func}
+	for _, arg := range node.Args {
+		argCopy, err := in.copy(arg)
+		if err != nil {
+			return nil, err
+		}
+		c.Args = append(c.Args, argCopy)
+	}
+	return c, nil
+}
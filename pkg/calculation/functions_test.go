@@ -0,0 +1,132 @@
+package calculation
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testResolver возвращает функции из определений
+func testResolver(t *testing.T, definitions ...string) Resolver {
+	t.Helper()
+	functions := make(map[string]*Function)
+	for _, definition := range definitions {
+		fn, err := ParseFunction(definition)
+		if err != nil {
+			t.Fatalf("Did not expect error for definition %q, but got: %v", definition, err)
+		}
+		functions[fn.Name] = fn
+	}
+	return func(name string) (*Function, error) {
+		if fn, ok := functions[name]; ok {
+			return fn, nil
+		}
+		return nil, fmt.Errorf("%w: unknown function %q", ErrInvalidExpression, name)
+	}
+}
+
+func TestParseFunction(t *testing.T) {
+	tests := []struct {
+		definition string
+		shouldFail bool
+	}{
+		{"f(x, y) = x^2 + 3*y", false},
+		{"g() = 42", false},
+		{"h(x) = f(x, x) * 2", false}, // Вызов другой функции проверяется при подстановке
+		{"f(x) = x + y", true},        // Неизвестная переменная
+		{"f(x, x) = x", true},         // Повторяющийся параметр
+		{"f(x) x", true},              // Нет знака равенства
+		{"f(x) =", true},              // Нет тела
+		{"(x) = x", true},             // Нет имени
+	}
+
+	for _, test := range tests {
+		t.Run(test.definition, func(t *testing.T) {
+			_, err := ParseFunction(test.definition)
+			if test.shouldFail && err == nil {
+				t.Errorf("Expected error for definition: %s, but got none", test.definition)
+			}
+			if !test.shouldFail && err != nil {
+				t.Errorf("Did not expect error for definition: %s, but got: %v", test.definition, err)
+			}
+		})
+	}
+}
+
+func TestInline(t *testing.T) {
+	resolve := testResolver(t,
+		"f(x, y) = x^2 + 3*y",
+		"g(x) = f(x, 1) - x",
+		"sq(x) = x * x",
+		"loop(x) = loop(x) + 1",
+		"ping(x) = pong(x)",
+		"pong(x) = ping(x)",
+		"broken(x) = missing(x)",
+		// Каждый вызов sq удваивает выражение
+		"big(x) = "+strings.Repeat("sq(", 14)+"x"+strings.Repeat(")", 14),
+	)
+
+	tests := []struct {
+		expression string
+		expected   float64
+		errorText  string
+	}{
+		{"f(2, 1)", 7, ""},
+		{"g(3) * 2", 18, ""}, // (f(3, 1) - 3) * 2 = (9 + 3 - 3) * 2
+		{"sq(1 + 2)", 9, ""}, // Аргумент подставляется вместо каждого вхождения параметра
+		{"f(1)", 0, "expects 2 arguments"},
+		{"loop(1)", 0, "recursive function loop -> loop"},
+		{"ping(1)", 0, "recursive function ping -> pong -> ping"},
+		{"broken(1)", 0, "unknown function \"missing\""},
+		{"nothing(1)", 0, "unknown function \"nothing\""},
+		{"big(2)", 0, "more than"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			node, err := Parse(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			node, err = Inline(node, resolve, 16)
+			if test.errorText != "" {
+				if err == nil || !strings.Contains(err.Error(), test.errorText) {
+					t.Fatalf("Expected error containing %q, but got %v", test.errorText, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Did not expect error, but got: %v", err)
+			}
+			result, err := Eval(node)
+			if err != nil || result != test.expected {
+				t.Fatalf("Expected %v, but got %v (%v)", test.expected, result, err)
+			}
+		})
+	}
+}
+
+func TestInlineDepth(t *testing.T) {
+	// Цепочка f0 -> f1 -> ... -> f5 без рекурсии
+	var definitions []string
+	for i := 0; i < 5; i++ {
+		definitions = append(definitions, fmt.Sprintf("f%d(x) = f%d(x) + 1", i, i+1))
+	}
+	definitions = append(definitions, "f5(x) = x")
+	resolve := testResolver(t, definitions...)
+
+	node, err := Parse("f0(0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Inline(node, resolve, 3); err == nil || !strings.Contains(err.Error(), "deeper than 3") {
+		t.Fatalf("Expected depth error, but got %v", err)
+	}
+	inlined, err := Inline(node, resolve, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := Eval(inlined); err != nil || result != 5 {
+		t.Fatalf("Expected 5, but got %v (%v)", result, err)
+	}
+}
@@ -0,0 +1,278 @@
+package calculation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/f1rsov08/go_calc_2/pkg/operation"
+)
+
+// Виды лексем
+const (
+	tokenNumber = iota
+	tokenIdent
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenComma
+	tokenAssign
+	tokenEnd
+)
+
+// Унарный минус связывает сильнее умножения, но слабее возведения в степень: -2^2 = -(2^2)
+const unaryPrecedence = operation.PrecedenceMultiplicative + 1
+
+type token struct {
+	kind int
+	text string
+	pos  int // Позиция в выражении
+}
+
+// tokenize разбивает выражение на лексемы. Операторами считаются обозначения
+// зарегистрированных инфиксных операций, при совпадении выбирается самое длинное
+func tokenize(expression string) ([]token, error) {
+	var symbols []string
+	for _, op := range operation.All() {
+		if op.Infix() {
+			symbols = append(symbols, op.Name)
+		}
+	}
+
+	var tokens []token
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			if i < len(runes) && runes[i] == '.' {
+				i++
+				// Точка может быть в числе только один раз и не в конце
+				if i == len(runes) || !unicode.IsDigit(runes[i]) {
+					return nil, fmt.Errorf("%w: number ends with a dot at %d", ErrInvalidExpression, i)
+				}
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i]), start})
+		case identStart(c):
+			start := i
+			for i < len(runes) && identPart(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, string(runes[start:i]), start})
+		case c == '(':
+			tokens = append(tokens, token{tokenLeftParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRightParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case c == '=':
+			tokens = append(tokens, token{tokenAssign, "=", i})
+			i++
+		default:
+			symbol := ""
+			rest := string(runes[i:])
+			for _, s := range symbols {
+				if strings.HasPrefix(rest, s) && len(s) > len(symbol) {
+					symbol = s
+				}
+			}
+			if symbol == "" {
+				return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidExpression, c, i)
+			}
+			tokens = append(tokens, token{tokenOperator, symbol, i})
+			i += len([]rune(symbol))
+		}
+	}
+	return append(tokens, token{tokenEnd, "", len(runes)}), nil
+}
+
+// identStart проверяет, может ли имя функции или параметра начинаться с символа c
+func identStart(c rune) bool {
+	return unicode.IsLetter(c) || c == '_'
+}
+
+// identPart проверяет, может ли символ c быть продолжением имени
+func identPart(c rune) bool {
+	return identStart(c) || unicode.IsDigit(c)
+}
+
+// IsIdentifier проверяет, что name можно записать в выражении как имя функции
+func IsIdentifier(name string) bool {
+	for i, c := range name {
+		if i == 0 && !identStart(c) || !identPart(c) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// parser разбирает выражение методом рекурсивного спуска с учетом приоритета операций
+type parser struct {
+	tokens []token
+	pos    int
+	params []string // Параметры определяемой функции, допустимые в выражении как переменные
+}
+
+// Parse разбирает выражение в дерево
+func Parse(expression string) (*Node, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	node, err := p.expression(1)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, p.unexpected(t)
+	}
+	return node, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) unexpected(t token) error {
+	if t.kind == tokenEnd {
+		return fmt.Errorf("%w: unexpected end of expression", ErrInvalidExpression)
+	}
+	return fmt.Errorf("%w: unexpected %q at %d", ErrInvalidExpression, t.text, t.pos)
+}
+
+// expression разбирает последовательность инфиксных операций с приоритетом не ниже minPrecedence
+func (p *parser) expression(minPrecedence int) (*Node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokenOperator {
+			return left, nil
+		}
+		op, ok := operation.Lookup(t.text)
+		if !ok || op.Precedence < minPrecedence {
+			return left, nil
+		}
+		p.next()
+		// Операции одного приоритета выполняются слева направо, кроме правоассоциативных
+		next := op.Precedence + 1
+		if op.RightAssoc {
+			next = op.Precedence
+		}
+		right, err := p.expression(next)
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Op: op.Name, Args: []*Node{left, right}}
+	}
+}
+
+// unary разбирает унарные плюс и минус перед операндом
+func (p *parser) unary() (*Node, error) {
+	t := p.peek()
+	if t.kind == tokenOperator && (t.text == "-" || t.text == "+") {
+		p.next()
+		operand, err := p.expression(unaryPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "+" {
+			return operand, nil
+		}
+		if operand.IsNumber() {
+			return &Node{Value: -operand.Value}, nil
+		}
+		return &Node{Op: "-", Args: []*Node{{Value: 0}, operand}}, nil
+	}
+	return p.primary()
+}
+
+// primary разбирает число, выражение в скобках, параметр или вызов функции
+func (p *parser) primary() (*Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidExpression, err)
+		}
+		return &Node{Value: value}, nil
+	case tokenLeftParen:
+		node, err := p.expression(1)
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRightParen {
+			return nil, p.unexpected(t)
+		}
+		return node, nil
+	case tokenIdent:
+		if p.peek().kind == tokenLeftParen {
+			return p.call(t)
+		}
+		for _, param := range p.params {
+			if param == t.text {
+				return &Node{Var: t.text}, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: unknown variable %q at %d", ErrInvalidExpression, t.text, t.pos)
+	}
+	return nil, p.unexpected(t)
+}
+
+// call разбирает вызов функции name(a, b, ...). Функция, которой нет в реестре операций,
+// считается пользовательской и проверяется при подстановке в Inline
+func (p *parser) call(name token) (*Node, error) {
+	op, ok := operation.Lookup(name.text)
+	if t := p.next(); t.kind != tokenLeftParen {
+		return nil, p.unexpected(t)
+	}
+	node := &Node{Op: name.text}
+	if !ok {
+		node = &Node{Func: name.text}
+	}
+	if p.peek().kind != tokenRightParen {
+		for {
+			arg, err := p.expression(1)
+			if err != nil {
+				return nil, err
+			}
+			node.Args = append(node.Args, arg)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if t := p.next(); t.kind != tokenRightParen {
+		return nil, p.unexpected(t)
+	}
+	if ok && len(node.Args) != op.Arity {
+		return nil, fmt.Errorf("%w: function %q expects %d arguments, but got %d", ErrInvalidExpression, op.Name, op.Arity, len(node.Args))
+	}
+	return node, nil
+}
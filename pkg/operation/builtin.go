@@ -0,0 +1,67 @@
+package operation
+
+import (
+	"math"
+	"time"
+)
+
+// Приоритеты встроенных инфиксных операций
+const (
+	PrecedenceAdditive       = 1 // + и -
+	PrecedenceMultiplicative = 2 // * и /
+	PrecedencePower          = 3 // ^
+)
+
+func init() {
+	Register(Operation{
+		Name:       "+",
+		Arity:      2,
+		Precedence: PrecedenceAdditive,
+		Func:       func(args ...float64) (float64, error) { return args[0] + args[1], nil },
+		CostEnv:    "TIME_ADDITION_MS",
+	})
+	Register(Operation{
+		Name:       "-",
+		Arity:      2,
+		Precedence: PrecedenceAdditive,
+		Func:       func(args ...float64) (float64, error) { return args[0] - args[1], nil },
+		CostEnv:    "TIME_SUBTRACTION_MS",
+	})
+	Register(Operation{
+		Name:       "*",
+		Arity:      2,
+		Precedence: PrecedenceMultiplicative,
+		Func:       func(args ...float64) (float64, error) { return args[0] * args[1], nil },
+		CostEnv:    "TIME_MULTIPLICATIONS_MS",
+	})
+	Register(Operation{
+		Name:       "/",
+		Arity:      2,
+		Precedence: PrecedenceMultiplicative,
+		Func: func(args ...float64) (float64, error) {
+			if args[1] == 0 {
+				return 0, &Error{Code: DivisionByZero, Message: "division by zero"}
+			}
+			return args[0] / args[1], nil
+		},
+		CostEnv: "TIME_DIVISIONS_MS",
+	})
+	Register(Operation{
+		Name:       "^",
+		Arity:      2,
+		Precedence: PrecedencePower,
+		RightAssoc: true,
+		Func: func(args ...float64) (float64, error) {
+			base, exponent := args[0], args[1]
+			if base == 0 && exponent < 0 {
+				return 0, &Error{Code: DivisionByZero, Message: "division by zero"}
+			}
+			if base < 0 && exponent != math.Trunc(exponent) {
+				return 0, &Error{Code: DomainError, Message: "fractional power of a negative number"}
+			}
+			return math.Pow(base, exponent), nil
+		},
+		Cost:    200 * time.Millisecond,
+		CostEnv: "TIME_POWER_MS",
+	})
+}
@@ -0,0 +1,28 @@
+package operation
+
+import "errors"
+
+// ErrTemporary оборачивает ошибки, после которых вычисление можно повторить,
+// например если процесс плагина перезапускается
+var ErrTemporary = errors.New("temporary failure")
+
+// Code - тип ошибки вычисления
+type Code string
+
+const (
+	DivisionByZero       Code = "division_by_zero"      // Деление на ноль
+	Overflow             Code = "overflow"              // Результат вышел за пределы представимых чисел
+	NaN                  Code = "nan"                   // Результат не является числом
+	UnsupportedOperation Code = "unsupported_operation" // Операция не зарегистрирована
+	DomainError          Code = "domain_error"          // Аргументы вне области определения операции
+)
+
+// Error - ошибка вычисления операции, которую нет смысла повторять
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
@@ -0,0 +1,137 @@
+// Package operation содержит реестр операций, общий для оркестратора и агента:
+// парсер оркестратора узнает из него синтаксис и приоритет операций, планировщик - время
+// выполнения, а агент - саму реализацию. Новая операция, зарегистрированная через Register,
+// сразу становится доступна во всех трех местах
+package operation
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Func вычисляет операцию. Количество аргументов совпадает с Arity
+type Func func(args ...float64) (float64, error)
+
+type Operation struct {
+	Name       string        // Обозначение в выражении: символ инфиксной операции или имя функции
+	Arity      int           // Количество аргументов
+	Precedence int           // Приоритет инфиксной операции, 0 - операция записывается как функция name(a, b)
+	RightAssoc bool          // Инфиксная операция выполняется справа налево, как возведение в степень
+	Func       Func          // Реализация
+	Cost       time.Duration // Время выполнения по умолчанию
+	CostEnv    string        // Переменная окружения, задающая время выполнения в миллисекундах
+}
+
+// Infix проверяет, записывается ли операция между аргументами
+func (op *Operation) Infix() bool {
+	return op.Precedence > 0
+}
+
+var (
+	mu         sync.RWMutex
+	operations = make(map[string]*Operation)
+)
+
+// Register добавляет операцию в реестр. Как и database/sql.Register, вызывается из init
+// и паникует при повторной регистрации или некорректном описании операции
+func Register(op Operation) {
+	if err := Add(op); err != nil {
+		panic("operation: " + err.Error())
+	}
+}
+
+// Add добавляет операцию в реестр во время работы, например при загрузке плагина.
+// В отличие от Register, возвращает ошибку вместо паники
+func Add(op Operation) error {
+	if op.Name == "" {
+		return fmt.Errorf("operation with empty name")
+	}
+	if op.Func == nil {
+		return fmt.Errorf("operation %q with nil func", op.Name)
+	}
+	if op.Arity < 1 {
+		return fmt.Errorf("operation %q with arity %d", op.Name, op.Arity)
+	}
+	if op.Infix() && op.Arity != 2 {
+		return fmt.Errorf("infix operation %q must have arity 2", op.Name)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := operations[op.Name]; ok {
+		return fmt.Errorf("operation %q is already registered", op.Name)
+	}
+	operations[op.Name] = &op
+	return nil
+}
+
+// Remove удаляет операцию из реестра
+func Remove(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(operations, name)
+}
+
+// Lookup возвращает операцию по ее обозначению
+func Lookup(name string) (*Operation, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	op, ok := operations[name]
+	return op, ok
+}
+
+// All возвращает все зарегистрированные операции, упорядоченные по обозначению
+func All() []*Operation {
+	mu.RLock()
+	defer mu.RUnlock()
+	all := make([]*Operation, 0, len(operations))
+	for _, op := range operations {
+		all = append(all, op)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Names возвращает обозначения всех зарегистрированных операций
+func Names() []string {
+	all := All()
+	names := make([]string, len(all))
+	for i, op := range all {
+		names[i] = op.Name
+	}
+	return names
+}
+
+// Apply вычисляет операцию name. Неизвестная операция и результат, не являющийся
+// конечным числом, возвращаются как *Error
+func Apply(name string, args ...float64) (float64, error) {
+	op, ok := Lookup(name)
+	if !ok {
+		return 0, &Error{Code: UnsupportedOperation, Message: fmt.Sprintf("unsupported operation %q", name)}
+	}
+	if len(args) != op.Arity {
+		return 0, fmt.Errorf("operation %q expects %d arguments, but got %d", name, op.Arity, len(args))
+	}
+	result, err := op.Func(args...)
+	if err != nil {
+		return 0, err
+	}
+	if err := CheckFinite(result); err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// CheckFinite возвращает ошибку, если x - бесконечность или NaN
+func CheckFinite(x float64) error {
+	switch {
+	case math.IsNaN(x):
+		return &Error{Code: NaN, Message: "result is not a number"}
+	case math.IsInf(x, 0):
+		return &Error{Code: Overflow, Message: "overflow"}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package operation
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name         string
+		operation    string
+		args         []float64
+		expected     float64
+		expectedCode Code
+	}{
+		{"addition", "+", []float64{1, 2}, 3, ""},
+		{"subtraction", "-", []float64{1, 2}, -1, ""},
+		{"multiplication", "*", []float64{2, -3}, -6, ""},
+		{"division", "/", []float64{5, 10}, 0.5, ""},
+		{"division by zero", "/", []float64{1, 0}, 0, DivisionByZero},
+		{"power", "^", []float64{2, 10}, 1024, ""},
+		{"negative base", "^", []float64{-2, 3}, -8, ""},
+		{"zero to negative power", "^", []float64{0, -1}, 0, DivisionByZero},
+		{"fractional power of negative", "^", []float64{-8, 0.5}, 0, DomainError},
+		{"power overflow", "^", []float64{10, 400}, 0, Overflow},
+		{"overflow", "*", []float64{math.MaxFloat64, 2}, 0, Overflow},
+		{"nan", "-", []float64{math.Inf(1), math.Inf(1)}, 0, NaN},
+		{"unsupported operation", "%", []float64{1, 2}, 0, UnsupportedOperation},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := Apply(test.operation, test.args...)
+			if test.expectedCode == "" {
+				if err != nil {
+					t.Fatalf("Did not expect error, but got: %v", err)
+				}
+				if result != test.expected {
+					t.Fatalf("Expected %v, but got %v", test.expected, result)
+				}
+				return
+			}
+			var opErr *Error
+			if !errors.As(err, &opErr) || opErr.Code != test.expectedCode {
+				t.Fatalf("Expected error with code %q, but got %v", test.expectedCode, err)
+			}
+		})
+	}
+}
+
+func TestApplyArity(t *testing.T) {
+	if _, err := Apply("+", 1); err == nil {
+		t.Fatal("Expected error for wrong number of arguments, but got none")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected panic on second registration of +, but got none")
+		}
+	}()
+	Register(Operation{Name: "+", Arity: 2, Func: func(args ...float64) (float64, error) { return 0, nil }})
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation Operation
+	}{
+		{"empty name", Operation{Arity: 2, Func: func(args ...float64) (float64, error) { return 0, nil }}},
+		{"nil func", Operation{Name: "nil", Arity: 2}},
+		{"zero arity", Operation{Name: "zero", Func: func(args ...float64) (float64, error) { return 0, nil }}},
+		{"unary infix", Operation{Name: "!", Arity: 1, Precedence: 1, Func: func(args ...float64) (float64, error) { return 0, nil }}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Expected panic, but got none")
+				}
+			}()
+			Register(test.operation)
+		})
+	}
+}
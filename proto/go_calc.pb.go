@@ -21,16 +21,214 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Тип ошибки вычисления
+type ErrorCode int32
+
+const (
+	ErrorCode_ERROR_CODE_UNSPECIFIED           ErrorCode = 0 // Тип не указан
+	ErrorCode_ERROR_CODE_DIVISION_BY_ZERO      ErrorCode = 1 // Деление на ноль
+	ErrorCode_ERROR_CODE_OVERFLOW              ErrorCode = 2 // Результат вышел за пределы представимых чисел
+	ErrorCode_ERROR_CODE_NAN                   ErrorCode = 3 // Результат не является числом
+	ErrorCode_ERROR_CODE_UNSUPPORTED_OPERATION ErrorCode = 4 // Агент не умеет выполнять операцию
+	ErrorCode_ERROR_CODE_DOMAIN_ERROR          ErrorCode = 5 // Аргументы вне области определения операции
+)
+
+// Enum value maps for ErrorCode.
+var (
+	ErrorCode_name = map[int32]string{
+		0: "ERROR_CODE_UNSPECIFIED",
+		1: "ERROR_CODE_DIVISION_BY_ZERO",
+		2: "ERROR_CODE_OVERFLOW",
+		3: "ERROR_CODE_NAN",
+		4: "ERROR_CODE_UNSUPPORTED_OPERATION",
+		5: "ERROR_CODE_DOMAIN_ERROR",
+	}
+	ErrorCode_value = map[string]int32{
+		"ERROR_CODE_UNSPECIFIED":           0,
+		"ERROR_CODE_DIVISION_BY_ZERO":      1,
+		"ERROR_CODE_OVERFLOW":              2,
+		"ERROR_CODE_NAN":                   3,
+		"ERROR_CODE_UNSUPPORTED_OPERATION": 4,
+		"ERROR_CODE_DOMAIN_ERROR":          5,
+	}
+)
+
+func (x ErrorCode) Enum() *ErrorCode {
+	p := new(ErrorCode)
+	*p = x
+	return p
+}
+
+func (x ErrorCode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ErrorCode) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_go_calc_proto_enumTypes[0].Descriptor()
+}
+
+func (ErrorCode) Type() protoreflect.EnumType {
+	return &file_proto_go_calc_proto_enumTypes[0]
+}
+
+func (x ErrorCode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ErrorCode.Descriptor instead.
+func (ErrorCode) EnumDescriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{0}
+}
+
+// Сведения об агенте, передаваемые вместе с запросом задач
+type AgentInfo struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Id               string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`                                                                                   // Идентификатор агента
+	Operations       []string               `protobuf:"bytes,2,rep,name=operations,proto3" json:"operations,omitempty"`                                                                   // Поддерживаемые операции, пустой список - все операции
+	MaxOperand       float64                `protobuf:"fixed64,3,opt,name=max_operand,json=maxOperand,proto3" json:"max_operand,omitempty"`                                               // Максимальный модуль аргумента, 0 - без ограничений
+	CustomOperations []*OperationSpec       `protobuf:"bytes,4,rep,name=custom_operations,json=customOperations,proto3" json:"custom_operations,omitempty"`                               // Операции плагинов, которых может не быть в реестре оркестратора
+	Labels           map[string]string      `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // Метки агента, по которым оркестратор выбирает для него задачи, например pool=premium
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *AgentInfo) Reset() {
+	*x = AgentInfo{}
+	mi := &file_proto_go_calc_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentInfo) ProtoMessage() {}
+
+func (x *AgentInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_go_calc_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentInfo.ProtoReflect.Descriptor instead.
+func (*AgentInfo) Descriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AgentInfo) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *AgentInfo) GetOperations() []string {
+	if x != nil {
+		return x.Operations
+	}
+	return nil
+}
+
+func (x *AgentInfo) GetMaxOperand() float64 {
+	if x != nil {
+		return x.MaxOperand
+	}
+	return 0
+}
+
+func (x *AgentInfo) GetCustomOperations() []*OperationSpec {
+	if x != nil {
+		return x.CustomOperations
+	}
+	return nil
+}
+
+func (x *AgentInfo) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+// Описание операции, которую агент вычисляет с помощью плагина
+type OperationSpec struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`                    // Имя функции
+	Arity         int32                  `protobuf:"varint,2,opt,name=arity,proto3" json:"arity,omitempty"`                 // Количество аргументов
+	CostMs        int64                  `protobuf:"varint,3,opt,name=cost_ms,json=costMs,proto3" json:"cost_ms,omitempty"` // Время выполнения по умолчанию в миллисекундах
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OperationSpec) Reset() {
+	*x = OperationSpec{}
+	mi := &file_proto_go_calc_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OperationSpec) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OperationSpec) ProtoMessage() {}
+
+func (x *OperationSpec) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_go_calc_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OperationSpec.ProtoReflect.Descriptor instead.
+func (*OperationSpec) Descriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *OperationSpec) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *OperationSpec) GetArity() int32 {
+	if x != nil {
+		return x.Arity
+	}
+	return 0
+}
+
+func (x *OperationSpec) GetCostMs() int64 {
+	if x != nil {
+		return x.CostMs
+	}
+	return 0
+}
+
 // Сообщение для запроса задачи
 type GetTaskRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	Agent         *AgentInfo             `protobuf:"bytes,1,opt,name=agent,proto3" json:"agent,omitempty"` // Агент, запрашивающий задачу
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GetTaskRequest) Reset() {
 	*x = GetTaskRequest{}
-	mi := &file_proto_go_calc_proto_msgTypes[0]
+	mi := &file_proto_go_calc_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -42,7 +240,7 @@ func (x *GetTaskRequest) String() string {
 func (*GetTaskRequest) ProtoMessage() {}
 
 func (x *GetTaskRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_go_calc_proto_msgTypes[0]
+	mi := &file_proto_go_calc_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -55,24 +253,33 @@ func (x *GetTaskRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetTaskRequest.ProtoReflect.Descriptor instead.
 func (*GetTaskRequest) Descriptor() ([]byte, []int) {
-	return file_proto_go_calc_proto_rawDescGZIP(), []int{0}
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetTaskRequest) GetAgent() *AgentInfo {
+	if x != nil {
+		return x.Agent
+	}
+	return nil
 }
 
 // Сообщение для ответа с задачей
 type Task struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`                                            // Идентификатор задачи
-	Arg1          float32                `protobuf:"fixed32,2,opt,name=arg1,proto3" json:"arg1,omitempty"`                                       // Имя первого аргумента
-	Arg2          float32                `protobuf:"fixed32,3,opt,name=arg2,proto3" json:"arg2,omitempty"`                                       // Имя второго аргумента
-	Operation     string                 `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`                               // Операция
-	OperationTime int64                  `protobuf:"varint,5,opt,name=operation_time,json=operationTime,proto3" json:"operation_time,omitempty"` // Время выполнения операции
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Id             int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`                                                                                                                         // Идентификатор задачи
+	Arg1           float32                `protobuf:"fixed32,2,opt,name=arg1,proto3" json:"arg1,omitempty"`                                                                                                                    // Имя первого аргумента
+	Arg2           float32                `protobuf:"fixed32,3,opt,name=arg2,proto3" json:"arg2,omitempty"`                                                                                                                    // Имя второго аргумента
+	Operation      string                 `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`                                                                                                            // Операция
+	OperationTime  int64                  `protobuf:"varint,5,opt,name=operation_time,json=operationTime,proto3" json:"operation_time,omitempty"`                                                                              // Время выполнения операции
+	Expression     string                 `protobuf:"bytes,6,opt,name=expression,proto3" json:"expression,omitempty"`                                                                                                          // Подвыражение в виде JSON дерева calculation.Node, если задача вычисляет его целиком
+	OperationTimes map[string]int64       `protobuf:"bytes,7,rep,name=operation_times,json=operationTimes,proto3" json:"operation_times,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"` // Время выполнения каждой операции подвыражения в миллисекундах
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
 }
 
 func (x *Task) Reset() {
 	*x = Task{}
-	mi := &file_proto_go_calc_proto_msgTypes[1]
+	mi := &file_proto_go_calc_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -84,7 +291,7 @@ func (x *Task) String() string {
 func (*Task) ProtoMessage() {}
 
 func (x *Task) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_go_calc_proto_msgTypes[1]
+	mi := &file_proto_go_calc_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -97,7 +304,7 @@ func (x *Task) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Task.ProtoReflect.Descriptor instead.
 func (*Task) Descriptor() ([]byte, []int) {
-	return file_proto_go_calc_proto_rawDescGZIP(), []int{1}
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *Task) GetId() int64 {
@@ -135,6 +342,20 @@ func (x *Task) GetOperationTime() int64 {
 	return 0
 }
 
+func (x *Task) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+func (x *Task) GetOperationTimes() map[string]int64 {
+	if x != nil {
+		return x.OperationTimes
+	}
+	return nil
+}
+
 type GetTaskResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Task          *Task                  `protobuf:"bytes,1,opt,name=task,proto3" json:"task,omitempty"` // Задача
@@ -144,7 +365,7 @@ type GetTaskResponse struct {
 
 func (x *GetTaskResponse) Reset() {
 	*x = GetTaskResponse{}
-	mi := &file_proto_go_calc_proto_msgTypes[2]
+	mi := &file_proto_go_calc_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -156,7 +377,7 @@ func (x *GetTaskResponse) String() string {
 func (*GetTaskResponse) ProtoMessage() {}
 
 func (x *GetTaskResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_go_calc_proto_msgTypes[2]
+	mi := &file_proto_go_calc_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -169,7 +390,7 @@ func (x *GetTaskResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetTaskResponse.ProtoReflect.Descriptor instead.
 func (*GetTaskResponse) Descriptor() ([]byte, []int) {
-	return file_proto_go_calc_proto_rawDescGZIP(), []int{2}
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *GetTaskResponse) GetTask() *Task {
@@ -182,16 +403,18 @@ func (x *GetTaskResponse) GetTask() *Task {
 // Сообщение для приема результата обработки данных
 type PostResultRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`          // Идентификатор задачи
-	Result        float32                `protobuf:"fixed32,2,opt,name=result,proto3" json:"result,omitempty"` // Результат обработки данных
-	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`     // Ошибка
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`                                                       // Идентификатор задачи
+	Result        float32                `protobuf:"fixed32,2,opt,name=result,proto3" json:"result,omitempty"`                                              // Результат обработки данных
+	Error         string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`                                                  // Ошибка
+	Retryable     bool                   `protobuf:"varint,4,opt,name=retryable,proto3" json:"retryable,omitempty"`                                         // Ошибка временная, задачу можно выполнить повторно
+	ErrorCode     ErrorCode              `protobuf:"varint,5,opt,name=error_code,json=errorCode,proto3,enum=go_calc.ErrorCode" json:"error_code,omitempty"` // Тип ошибки
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *PostResultRequest) Reset() {
 	*x = PostResultRequest{}
-	mi := &file_proto_go_calc_proto_msgTypes[3]
+	mi := &file_proto_go_calc_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -203,7 +426,7 @@ func (x *PostResultRequest) String() string {
 func (*PostResultRequest) ProtoMessage() {}
 
 func (x *PostResultRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_go_calc_proto_msgTypes[3]
+	mi := &file_proto_go_calc_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -216,7 +439,7 @@ func (x *PostResultRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PostResultRequest.ProtoReflect.Descriptor instead.
 func (*PostResultRequest) Descriptor() ([]byte, []int) {
-	return file_proto_go_calc_proto_rawDescGZIP(), []int{3}
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *PostResultRequest) GetId() int64 {
@@ -240,6 +463,20 @@ func (x *PostResultRequest) GetError() string {
 	return ""
 }
 
+func (x *PostResultRequest) GetRetryable() bool {
+	if x != nil {
+		return x.Retryable
+	}
+	return false
+}
+
+func (x *PostResultRequest) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_ERROR_CODE_UNSPECIFIED
+}
+
 // Сообщение для ответа на прием результата
 type PostResultResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -250,7 +487,7 @@ type PostResultResponse struct {
 
 func (x *PostResultResponse) Reset() {
 	*x = PostResultResponse{}
-	mi := &file_proto_go_calc_proto_msgTypes[4]
+	mi := &file_proto_go_calc_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -262,7 +499,7 @@ func (x *PostResultResponse) String() string {
 func (*PostResultResponse) ProtoMessage() {}
 
 func (x *PostResultResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_go_calc_proto_msgTypes[4]
+	mi := &file_proto_go_calc_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -275,7 +512,7 @@ func (x *PostResultResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PostResultResponse.ProtoReflect.Descriptor instead.
 func (*PostResultResponse) Descriptor() ([]byte, []int) {
-	return file_proto_go_calc_proto_rawDescGZIP(), []int{4}
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *PostResultResponse) GetStatus() string {
@@ -285,30 +522,490 @@ func (x *PostResultResponse) GetStatus() string {
 	return ""
 }
 
+// Сообщение для запроса нескольких задач
+type GetTasksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MaxN          int32                  `protobuf:"varint,1,opt,name=max_n,json=maxN,proto3" json:"max_n,omitempty"` // Максимальное количество задач
+	Agent         *AgentInfo             `protobuf:"bytes,2,opt,name=agent,proto3" json:"agent,omitempty"`            // Агент, запрашивающий задачи
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTasksRequest) Reset() {
+	*x = GetTasksRequest{}
+	mi := &file_proto_go_calc_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTasksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTasksRequest) ProtoMessage() {}
+
+func (x *GetTasksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_go_calc_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTasksRequest.ProtoReflect.Descriptor instead.
+func (*GetTasksRequest) Descriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetTasksRequest) GetMaxN() int32 {
+	if x != nil {
+		return x.MaxN
+	}
+	return 0
+}
+
+func (x *GetTasksRequest) GetAgent() *AgentInfo {
+	if x != nil {
+		return x.Agent
+	}
+	return nil
+}
+
+// Сообщение для ответа с несколькими задачами
+type GetTasksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tasks         []*Task                `protobuf:"bytes,1,rep,name=tasks,proto3" json:"tasks,omitempty"`                              // Задачи
+	QueueDepth    int32                  `protobuf:"varint,2,opt,name=queue_depth,json=queueDepth,proto3" json:"queue_depth,omitempty"` // Сколько еще готовых задач, которые может выполнить агент, осталось в очереди
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTasksResponse) Reset() {
+	*x = GetTasksResponse{}
+	mi := &file_proto_go_calc_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTasksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTasksResponse) ProtoMessage() {}
+
+func (x *GetTasksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_go_calc_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTasksResponse.ProtoReflect.Descriptor instead.
+func (*GetTasksResponse) Descriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GetTasksResponse) GetTasks() []*Task {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
+func (x *GetTasksResponse) GetQueueDepth() int32 {
+	if x != nil {
+		return x.QueueDepth
+	}
+	return 0
+}
+
+// Сообщение для приема нескольких результатов
+type PostResultsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*PostResultRequest   `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"` // Результаты
+	Agent         *AgentInfo             `protobuf:"bytes,2,opt,name=agent,proto3" json:"agent,omitempty"`     // Агент, вычисливший задачи
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PostResultsRequest) Reset() {
+	*x = PostResultsRequest{}
+	mi := &file_proto_go_calc_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PostResultsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PostResultsRequest) ProtoMessage() {}
+
+func (x *PostResultsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_go_calc_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PostResultsRequest.ProtoReflect.Descriptor instead.
+func (*PostResultsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *PostResultsRequest) GetResults() []*PostResultRequest {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *PostResultsRequest) GetAgent() *AgentInfo {
+	if x != nil {
+		return x.Agent
+	}
+	return nil
+}
+
+// Сообщение для ответа на прием нескольких результатов
+type PostResultsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Statuses      []*PostResultResponse  `protobuf:"bytes,1,rep,name=statuses,proto3" json:"statuses,omitempty"` // Статусы в порядке результатов
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PostResultsResponse) Reset() {
+	*x = PostResultsResponse{}
+	mi := &file_proto_go_calc_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PostResultsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PostResultsResponse) ProtoMessage() {}
+
+func (x *PostResultsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_go_calc_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PostResultsResponse.ProtoReflect.Descriptor instead.
+func (*PostResultsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *PostResultsResponse) GetStatuses() []*PostResultResponse {
+	if x != nil {
+		return x.Statuses
+	}
+	return nil
+}
+
+// Сообщение о задачах, которые агент сейчас вычисляет
+type HeartbeatRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Agent         *AgentInfo             `protobuf:"bytes,1,opt,name=agent,proto3" json:"agent,omitempty"`                            // Агент, отправляющий сообщение
+	TaskIds       []int64                `protobuf:"varint,2,rep,packed,name=task_ids,json=taskIds,proto3" json:"task_ids,omitempty"` // Идентификаторы вычисляемых задач
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatRequest) Reset() {
+	*x = HeartbeatRequest{}
+	mi := &file_proto_go_calc_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatRequest) ProtoMessage() {}
+
+func (x *HeartbeatRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_go_calc_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatRequest.ProtoReflect.Descriptor instead.
+func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *HeartbeatRequest) GetAgent() *AgentInfo {
+	if x != nil {
+		return x.Agent
+	}
+	return nil
+}
+
+func (x *HeartbeatRequest) GetTaskIds() []int64 {
+	if x != nil {
+		return x.TaskIds
+	}
+	return nil
+}
+
+// Сообщение со списком задач, вычисление которых нужно прекратить
+type HeartbeatResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CancelledIds  []int64                `protobuf:"varint,1,rep,packed,name=cancelled_ids,json=cancelledIds,proto3" json:"cancelled_ids,omitempty"` // Идентификаторы отмененных задач
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatResponse) Reset() {
+	*x = HeartbeatResponse{}
+	mi := &file_proto_go_calc_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatResponse) ProtoMessage() {}
+
+func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_go_calc_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatResponse.ProtoReflect.Descriptor instead.
+func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *HeartbeatResponse) GetCancelledIds() []int64 {
+	if x != nil {
+		return x.CancelledIds
+	}
+	return nil
+}
+
+// Сообщение с задачами, которые агент возвращает в очередь невычисленными
+type ReleaseTasksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Agent         *AgentInfo             `protobuf:"bytes,1,opt,name=agent,proto3" json:"agent,omitempty"`                            // Агент, возвращающий задачи
+	TaskIds       []int64                `protobuf:"varint,2,rep,packed,name=task_ids,json=taskIds,proto3" json:"task_ids,omitempty"` // Идентификаторы возвращаемых задач
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseTasksRequest) Reset() {
+	*x = ReleaseTasksRequest{}
+	mi := &file_proto_go_calc_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseTasksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseTasksRequest) ProtoMessage() {}
+
+func (x *ReleaseTasksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_go_calc_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseTasksRequest.ProtoReflect.Descriptor instead.
+func (*ReleaseTasksRequest) Descriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ReleaseTasksRequest) GetAgent() *AgentInfo {
+	if x != nil {
+		return x.Agent
+	}
+	return nil
+}
+
+func (x *ReleaseTasksRequest) GetTaskIds() []int64 {
+	if x != nil {
+		return x.TaskIds
+	}
+	return nil
+}
+
+// Сообщение для ответа на возврат задач
+type ReleaseTasksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseTasksResponse) Reset() {
+	*x = ReleaseTasksResponse{}
+	mi := &file_proto_go_calc_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseTasksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseTasksResponse) ProtoMessage() {}
+
+func (x *ReleaseTasksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_go_calc_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseTasksResponse.ProtoReflect.Descriptor instead.
+func (*ReleaseTasksResponse) Descriptor() ([]byte, []int) {
+	return file_proto_go_calc_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ReleaseTasksResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
 var File_proto_go_calc_proto protoreflect.FileDescriptor
 
 const file_proto_go_calc_proto_rawDesc = "" +
 	"\n" +
-	"\x13proto/go_calc.proto\x12\ago_calc\"\x10\n" +
-	"\x0eGetTaskRequest\"\x83\x01\n" +
+	"\x13proto/go_calc.proto\x12\ago_calc\"\x94\x02\n" +
+	"\tAgentInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1e\n" +
+	"\n" +
+	"operations\x18\x02 \x03(\tR\n" +
+	"operations\x12\x1f\n" +
+	"\vmax_operand\x18\x03 \x01(\x01R\n" +
+	"maxOperand\x12C\n" +
+	"\x11custom_operations\x18\x04 \x03(\v2\x16.go_calc.OperationSpecR\x10customOperations\x126\n" +
+	"\x06labels\x18\x05 \x03(\v2\x1e.go_calc.AgentInfo.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"R\n" +
+	"\rOperationSpec\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05arity\x18\x02 \x01(\x05R\x05arity\x12\x17\n" +
+	"\acost_ms\x18\x03 \x01(\x03R\x06costMs\":\n" +
+	"\x0eGetTaskRequest\x12(\n" +
+	"\x05agent\x18\x01 \x01(\v2\x12.go_calc.AgentInfoR\x05agent\"\xb2\x02\n" +
 	"\x04Task\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
 	"\x04arg1\x18\x02 \x01(\x02R\x04arg1\x12\x12\n" +
 	"\x04arg2\x18\x03 \x01(\x02R\x04arg2\x12\x1c\n" +
 	"\toperation\x18\x04 \x01(\tR\toperation\x12%\n" +
-	"\x0eoperation_time\x18\x05 \x01(\x03R\roperationTime\"4\n" +
+	"\x0eoperation_time\x18\x05 \x01(\x03R\roperationTime\x12\x1e\n" +
+	"\n" +
+	"expression\x18\x06 \x01(\tR\n" +
+	"expression\x12J\n" +
+	"\x0foperation_times\x18\a \x03(\v2!.go_calc.Task.OperationTimesEntryR\x0eoperationTimes\x1aA\n" +
+	"\x13OperationTimesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\"4\n" +
 	"\x0fGetTaskResponse\x12!\n" +
-	"\x04task\x18\x01 \x01(\v2\r.go_calc.TaskR\x04task\"Q\n" +
+	"\x04task\x18\x01 \x01(\v2\r.go_calc.TaskR\x04task\"\xa2\x01\n" +
 	"\x11PostResultRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x16\n" +
 	"\x06result\x18\x02 \x01(\x02R\x06result\x12\x14\n" +
-	"\x05error\x18\x03 \x01(\tR\x05error\",\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\x12\x1c\n" +
+	"\tretryable\x18\x04 \x01(\bR\tretryable\x121\n" +
+	"\n" +
+	"error_code\x18\x05 \x01(\x0e2\x12.go_calc.ErrorCodeR\terrorCode\",\n" +
 	"\x12PostResultResponse\x12\x16\n" +
-	"\x06status\x18\x01 \x01(\tR\x06status2\x92\x01\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\"P\n" +
+	"\x0fGetTasksRequest\x12\x13\n" +
+	"\x05max_n\x18\x01 \x01(\x05R\x04maxN\x12(\n" +
+	"\x05agent\x18\x02 \x01(\v2\x12.go_calc.AgentInfoR\x05agent\"X\n" +
+	"\x10GetTasksResponse\x12#\n" +
+	"\x05tasks\x18\x01 \x03(\v2\r.go_calc.TaskR\x05tasks\x12\x1f\n" +
+	"\vqueue_depth\x18\x02 \x01(\x05R\n" +
+	"queueDepth\"t\n" +
+	"\x12PostResultsRequest\x124\n" +
+	"\aresults\x18\x01 \x03(\v2\x1a.go_calc.PostResultRequestR\aresults\x12(\n" +
+	"\x05agent\x18\x02 \x01(\v2\x12.go_calc.AgentInfoR\x05agent\"N\n" +
+	"\x13PostResultsResponse\x127\n" +
+	"\bstatuses\x18\x01 \x03(\v2\x1b.go_calc.PostResultResponseR\bstatuses\"W\n" +
+	"\x10HeartbeatRequest\x12(\n" +
+	"\x05agent\x18\x01 \x01(\v2\x12.go_calc.AgentInfoR\x05agent\x12\x19\n" +
+	"\btask_ids\x18\x02 \x03(\x03R\ataskIds\"8\n" +
+	"\x11HeartbeatResponse\x12#\n" +
+	"\rcancelled_ids\x18\x01 \x03(\x03R\fcancelledIds\"Z\n" +
+	"\x13ReleaseTasksRequest\x12(\n" +
+	"\x05agent\x18\x01 \x01(\v2\x12.go_calc.AgentInfoR\x05agent\x12\x19\n" +
+	"\btask_ids\x18\x02 \x03(\x03R\ataskIds\".\n" +
+	"\x14ReleaseTasksResponse\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status*\xb8\x01\n" +
+	"\tErrorCode\x12\x1a\n" +
+	"\x16ERROR_CODE_UNSPECIFIED\x10\x00\x12\x1f\n" +
+	"\x1bERROR_CODE_DIVISION_BY_ZERO\x10\x01\x12\x17\n" +
+	"\x13ERROR_CODE_OVERFLOW\x10\x02\x12\x12\n" +
+	"\x0eERROR_CODE_NAN\x10\x03\x12$\n" +
+	" ERROR_CODE_UNSUPPORTED_OPERATION\x10\x04\x12\x1b\n" +
+	"\x17ERROR_CODE_DOMAIN_ERROR\x10\x052\xae\x03\n" +
 	"\vTaskService\x12<\n" +
 	"\aGetTask\x12\x17.go_calc.GetTaskRequest\x1a\x18.go_calc.GetTaskResponse\x12E\n" +
 	"\n" +
-	"PostResult\x12\x1a.go_calc.PostResultRequest\x1a\x1b.go_calc.PostResultResponseB%Z#github.com/f1rsov08/go_calc_2/protob\x06proto3"
+	"PostResult\x12\x1a.go_calc.PostResultRequest\x1a\x1b.go_calc.PostResultResponse\x12?\n" +
+	"\bGetTasks\x12\x18.go_calc.GetTasksRequest\x1a\x19.go_calc.GetTasksResponse\x12H\n" +
+	"\vPostResults\x12\x1b.go_calc.PostResultsRequest\x1a\x1c.go_calc.PostResultsResponse\x12B\n" +
+	"\tHeartbeat\x12\x19.go_calc.HeartbeatRequest\x1a\x1a.go_calc.HeartbeatResponse\x12K\n" +
+	"\fReleaseTasks\x12\x1c.go_calc.ReleaseTasksRequest\x1a\x1d.go_calc.ReleaseTasksResponseB%Z#github.com/f1rsov08/go_calc_2/protob\x06proto3"
 
 var (
 	file_proto_go_calc_proto_rawDescOnce sync.Once
@@ -322,25 +1019,59 @@ func file_proto_go_calc_proto_rawDescGZIP() []byte {
 	return file_proto_go_calc_proto_rawDescData
 }
 
-var file_proto_go_calc_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_proto_go_calc_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_proto_go_calc_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
 var file_proto_go_calc_proto_goTypes = []any{
-	(*GetTaskRequest)(nil),     // 0: go_calc.GetTaskRequest
-	(*Task)(nil),               // 1: go_calc.Task
-	(*GetTaskResponse)(nil),    // 2: go_calc.GetTaskResponse
-	(*PostResultRequest)(nil),  // 3: go_calc.PostResultRequest
-	(*PostResultResponse)(nil), // 4: go_calc.PostResultResponse
+	(ErrorCode)(0),               // 0: go_calc.ErrorCode
+	(*AgentInfo)(nil),            // 1: go_calc.AgentInfo
+	(*OperationSpec)(nil),        // 2: go_calc.OperationSpec
+	(*GetTaskRequest)(nil),       // 3: go_calc.GetTaskRequest
+	(*Task)(nil),                 // 4: go_calc.Task
+	(*GetTaskResponse)(nil),      // 5: go_calc.GetTaskResponse
+	(*PostResultRequest)(nil),    // 6: go_calc.PostResultRequest
+	(*PostResultResponse)(nil),   // 7: go_calc.PostResultResponse
+	(*GetTasksRequest)(nil),      // 8: go_calc.GetTasksRequest
+	(*GetTasksResponse)(nil),     // 9: go_calc.GetTasksResponse
+	(*PostResultsRequest)(nil),   // 10: go_calc.PostResultsRequest
+	(*PostResultsResponse)(nil),  // 11: go_calc.PostResultsResponse
+	(*HeartbeatRequest)(nil),     // 12: go_calc.HeartbeatRequest
+	(*HeartbeatResponse)(nil),    // 13: go_calc.HeartbeatResponse
+	(*ReleaseTasksRequest)(nil),  // 14: go_calc.ReleaseTasksRequest
+	(*ReleaseTasksResponse)(nil), // 15: go_calc.ReleaseTasksResponse
+	nil,                          // 16: go_calc.AgentInfo.LabelsEntry
+	nil,                          // 17: go_calc.Task.OperationTimesEntry
 }
 var file_proto_go_calc_proto_depIdxs = []int32{
-	1, // 0: go_calc.GetTaskResponse.task:type_name -> go_calc.Task
-	0, // 1: go_calc.TaskService.GetTask:input_type -> go_calc.GetTaskRequest
-	3, // 2: go_calc.TaskService.PostResult:input_type -> go_calc.PostResultRequest
-	2, // 3: go_calc.TaskService.GetTask:output_type -> go_calc.GetTaskResponse
-	4, // 4: go_calc.TaskService.PostResult:output_type -> go_calc.PostResultResponse
-	3, // [3:5] is the sub-list for method output_type
-	1, // [1:3] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	2,  // 0: go_calc.AgentInfo.custom_operations:type_name -> go_calc.OperationSpec
+	16, // 1: go_calc.AgentInfo.labels:type_name -> go_calc.AgentInfo.LabelsEntry
+	1,  // 2: go_calc.GetTaskRequest.agent:type_name -> go_calc.AgentInfo
+	17, // 3: go_calc.Task.operation_times:type_name -> go_calc.Task.OperationTimesEntry
+	4,  // 4: go_calc.GetTaskResponse.task:type_name -> go_calc.Task
+	0,  // 5: go_calc.PostResultRequest.error_code:type_name -> go_calc.ErrorCode
+	1,  // 6: go_calc.GetTasksRequest.agent:type_name -> go_calc.AgentInfo
+	4,  // 7: go_calc.GetTasksResponse.tasks:type_name -> go_calc.Task
+	6,  // 8: go_calc.PostResultsRequest.results:type_name -> go_calc.PostResultRequest
+	1,  // 9: go_calc.PostResultsRequest.agent:type_name -> go_calc.AgentInfo
+	7,  // 10: go_calc.PostResultsResponse.statuses:type_name -> go_calc.PostResultResponse
+	1,  // 11: go_calc.HeartbeatRequest.agent:type_name -> go_calc.AgentInfo
+	1,  // 12: go_calc.ReleaseTasksRequest.agent:type_name -> go_calc.AgentInfo
+	3,  // 13: go_calc.TaskService.GetTask:input_type -> go_calc.GetTaskRequest
+	6,  // 14: go_calc.TaskService.PostResult:input_type -> go_calc.PostResultRequest
+	8,  // 15: go_calc.TaskService.GetTasks:input_type -> go_calc.GetTasksRequest
+	10, // 16: go_calc.TaskService.PostResults:input_type -> go_calc.PostResultsRequest
+	12, // 17: go_calc.TaskService.Heartbeat:input_type -> go_calc.HeartbeatRequest
+	14, // 18: go_calc.TaskService.ReleaseTasks:input_type -> go_calc.ReleaseTasksRequest
+	5,  // 19: go_calc.TaskService.GetTask:output_type -> go_calc.GetTaskResponse
+	7,  // 20: go_calc.TaskService.PostResult:output_type -> go_calc.PostResultResponse
+	9,  // 21: go_calc.TaskService.GetTasks:output_type -> go_calc.GetTasksResponse
+	11, // 22: go_calc.TaskService.PostResults:output_type -> go_calc.PostResultsResponse
+	13, // 23: go_calc.TaskService.Heartbeat:output_type -> go_calc.HeartbeatResponse
+	15, // 24: go_calc.TaskService.ReleaseTasks:output_type -> go_calc.ReleaseTasksResponse
+	19, // [19:25] is the sub-list for method output_type
+	13, // [13:19] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
 }
 
 func init() { file_proto_go_calc_proto_init() }
@@ -353,13 +1084,14 @@ func file_proto_go_calc_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_go_calc_proto_rawDesc), len(file_proto_go_calc_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   5,
+			NumEnums:      1,
+			NumMessages:   17,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_proto_go_calc_proto_goTypes,
 		DependencyIndexes: file_proto_go_calc_proto_depIdxs,
+		EnumInfos:         file_proto_go_calc_proto_enumTypes,
 		MessageInfos:      file_proto_go_calc_proto_msgTypes,
 	}.Build()
 	File_proto_go_calc_proto = out.File
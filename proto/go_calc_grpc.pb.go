@@ -19,8 +19,12 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	TaskService_GetTask_FullMethodName    = "/go_calc.TaskService/GetTask"
-	TaskService_PostResult_FullMethodName = "/go_calc.TaskService/PostResult"
+	TaskService_GetTask_FullMethodName      = "/go_calc.TaskService/GetTask"
+	TaskService_PostResult_FullMethodName   = "/go_calc.TaskService/PostResult"
+	TaskService_GetTasks_FullMethodName     = "/go_calc.TaskService/GetTasks"
+	TaskService_PostResults_FullMethodName  = "/go_calc.TaskService/PostResults"
+	TaskService_Heartbeat_FullMethodName    = "/go_calc.TaskService/Heartbeat"
+	TaskService_ReleaseTasks_FullMethodName = "/go_calc.TaskService/ReleaseTasks"
 )
 
 // TaskServiceClient is the client API for TaskService service.
@@ -33,6 +37,14 @@ type TaskServiceClient interface {
 	GetTask(ctx context.Context, in *GetTaskRequest, opts ...grpc.CallOption) (*GetTaskResponse, error)
 	// Прием результата обработки данных
 	PostResult(ctx context.Context, in *PostResultRequest, opts ...grpc.CallOption) (*PostResultResponse, error)
+	// Получение нескольких задач за один запрос
+	GetTasks(ctx context.Context, in *GetTasksRequest, opts ...grpc.CallOption) (*GetTasksResponse, error)
+	// Прием нескольких результатов за один запрос
+	PostResults(ctx context.Context, in *PostResultsRequest, opts ...grpc.CallOption) (*PostResultsResponse, error)
+	// Сообщение о вычисляемых задачах и получение списка отмененных
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	// Возврат выданных, но не вычисленных задач
+	ReleaseTasks(ctx context.Context, in *ReleaseTasksRequest, opts ...grpc.CallOption) (*ReleaseTasksResponse, error)
 }
 
 type taskServiceClient struct {
@@ -63,6 +75,46 @@ func (c *taskServiceClient) PostResult(ctx context.Context, in *PostResultReques
 	return out, nil
 }
 
+func (c *taskServiceClient) GetTasks(ctx context.Context, in *GetTasksRequest, opts ...grpc.CallOption) (*GetTasksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetTasksResponse)
+	err := c.cc.Invoke(ctx, TaskService_GetTasks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskServiceClient) PostResults(ctx context.Context, in *PostResultsRequest, opts ...grpc.CallOption) (*PostResultsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PostResultsResponse)
+	err := c.cc.Invoke(ctx, TaskService_PostResults_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, TaskService_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taskServiceClient) ReleaseTasks(ctx context.Context, in *ReleaseTasksRequest, opts ...grpc.CallOption) (*ReleaseTasksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReleaseTasksResponse)
+	err := c.cc.Invoke(ctx, TaskService_ReleaseTasks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TaskServiceServer is the server API for TaskService service.
 // All implementations must embed UnimplementedTaskServiceServer
 // for forward compatibility.
@@ -73,6 +125,14 @@ type TaskServiceServer interface {
 	GetTask(context.Context, *GetTaskRequest) (*GetTaskResponse, error)
 	// Прием результата обработки данных
 	PostResult(context.Context, *PostResultRequest) (*PostResultResponse, error)
+	// Получение нескольких задач за один запрос
+	GetTasks(context.Context, *GetTasksRequest) (*GetTasksResponse, error)
+	// Прием нескольких результатов за один запрос
+	PostResults(context.Context, *PostResultsRequest) (*PostResultsResponse, error)
+	// Сообщение о вычисляемых задачах и получение списка отмененных
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	// Возврат выданных, но не вычисленных задач
+	ReleaseTasks(context.Context, *ReleaseTasksRequest) (*ReleaseTasksResponse, error)
 	mustEmbedUnimplementedTaskServiceServer()
 }
 
@@ -89,6 +149,18 @@ func (UnimplementedTaskServiceServer) GetTask(context.Context, *GetTaskRequest)
 func (UnimplementedTaskServiceServer) PostResult(context.Context, *PostResultRequest) (*PostResultResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PostResult not implemented")
 }
+func (UnimplementedTaskServiceServer) GetTasks(context.Context, *GetTasksRequest) (*GetTasksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTasks not implemented")
+}
+func (UnimplementedTaskServiceServer) PostResults(context.Context, *PostResultsRequest) (*PostResultsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PostResults not implemented")
+}
+func (UnimplementedTaskServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedTaskServiceServer) ReleaseTasks(context.Context, *ReleaseTasksRequest) (*ReleaseTasksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseTasks not implemented")
+}
 func (UnimplementedTaskServiceServer) mustEmbedUnimplementedTaskServiceServer() {}
 func (UnimplementedTaskServiceServer) testEmbeddedByValue()                     {}
 
@@ -146,6 +218,78 @@ func _TaskService_PostResult_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TaskService_GetTasks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTasksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServiceServer).GetTasks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskService_GetTasks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServiceServer).GetTasks(ctx, req.(*GetTasksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskService_PostResults_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PostResultsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServiceServer).PostResults(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskService_PostResults_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServiceServer).PostResults(ctx, req.(*PostResultsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskService_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaskService_ReleaseTasks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseTasksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskServiceServer).ReleaseTasks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaskService_ReleaseTasks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskServiceServer).ReleaseTasks(ctx, req.(*ReleaseTasksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // TaskService_ServiceDesc is the grpc.ServiceDesc for TaskService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -161,6 +305,22 @@ var TaskService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "PostResult",
 			Handler:    _TaskService_PostResult_Handler,
 		},
+		{
+			MethodName: "GetTasks",
+			Handler:    _TaskService_GetTasks_Handler,
+		},
+		{
+			MethodName: "PostResults",
+			Handler:    _TaskService_PostResults_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _TaskService_Heartbeat_Handler,
+		},
+		{
+			MethodName: "ReleaseTasks",
+			Handler:    _TaskService_ReleaseTasks_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/go_calc.proto",